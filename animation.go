@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/suixinio/webp-img/imaging"
+)
+
+// errNotAnimated 图片不是动画GIF，无法提取海报帧
+var errNotAnimated = errors.New("图片不是动画GIF")
+
+// setWebPLoop 使用webpmux设置动画WebP的循环次数，0 表示无限循环
+func setWebPLoop(webpPath string, loop int) error {
+	if _, err := exec.LookPath("webpmux"); err != nil {
+		return fmt.Errorf("未找到webpmux工具，无法设置循环次数")
+	}
+
+	output, err := exec.Command("webpmux", "-set", "loop", strconv.Itoa(loop), webpPath, "-o", webpPath).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("webpmux执行失败: %v, 输出: %s", err, output)
+	}
+	return nil
+}
+
+// posterName 返回第frame帧（从1开始）海报在衍生版本目录中的名称
+func posterName(frame int) string {
+	return "poster-" + strconv.Itoa(frame)
+}
+
+// ensurePoster 返回动画GIF第frame帧（从1开始）的静态WebP路径，不存在时从原图生成
+func ensurePoster(files *imageFiles, frame int) (string, error) {
+	if files.OriginalPath == "" || !isAnimatedGIF(files.OriginalPath) {
+		return "", errNotAnimated
+	}
+
+	webpPath := files.WebpPath
+	if webpPath == "" {
+		webpPath = filepath.Join(config.WebpDir, strings.TrimSuffix(files.RelPath, filepath.Ext(files.RelPath))+".webp")
+	}
+	target := variantPath(webpPath, posterName(frame))
+	if isRegularFile(target) {
+		return target, nil
+	}
+
+	// 解码GIF和编码WebP占用转换池，避免大量海报请求同时转换
+	var err error
+	withConversionSlot(func() {
+		target, err = writePoster(files.OriginalPath, webpPath, frame)
+	})
+	if err != nil {
+		return "", err
+	}
+	return target, nil
+}
+
+// writePoster 从原图中提取第frame帧，作为名为 poster-N 的衍生版本保存
+// 等待转换池期间其他请求可能已经生成，已存在时直接返回，不再解码原图
+func writePoster(originalPath, webpPath string, frame int) (string, error) {
+	if target := variantPath(webpPath, posterName(frame)); isRegularFile(target) {
+		return target, nil
+	}
+
+	data, err := os.ReadFile(originalPath)
+	if err != nil {
+		return "", fmt.Errorf("读取原图失败: %w", err)
+	}
+	img, err := imaging.GIFFrame(data, frame-1)
+	if err != nil {
+		return "", err
+	}
+
+	dir := variantDir(webpPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("创建衍生版本目录失败: %w", err)
+	}
+	frameFile, err := writeTempPNG(img, dir, ".poster-*.png")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(frameFile)
+
+	return ensureVariant(frameFile, webpPath, posterName(frame), nil)
+}
+
+// parseFrame 解析从1开始的帧序号，未指定时为第1帧
+func parseFrame(value string) (int, error) {
+	if value == "" {
+		return 1, nil
+	}
+	frame, err := strconv.Atoi(value)
+	if err != nil || frame < 1 {
+		return 0, fmt.Errorf("frame 必须是从1开始的整数")
+	}
+	return frame, nil
+}
+
+// servePoster 提供海报帧，转换结果可能是体积更小的PNG
+func servePoster(c *gin.Context, path string) {
+	serveFile(c, path, contentTypeByExt("."+formatOf(path)), "")
+}
+
+// imagePosterHandler 返回动画图片第N帧的静态WebP，通过 frame 参数指定帧序号（从1开始）
+func imagePosterHandler(c *gin.Context, imagePath string) {
+	frame, err := parseFrame(c.Query("frame"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	files, ok := resolveImage(imagePath)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "图片不存在"})
+		return
+	}
+
+	poster, err := ensurePoster(files, frame)
+	if err != nil {
+		switch {
+		case errors.Is(err, errNotAnimated):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, imaging.ErrFrameOutOfRange):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		default:
+			log.Printf("生成海报帧失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "生成海报帧失败"})
+		}
+		return
+	}
+	servePoster(c, poster)
+}
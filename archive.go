@@ -0,0 +1,320 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 压缩包格式
+const (
+	archiveZip   = "zip"
+	archiveTarGz = "tar.gz"
+	archiveTar   = "tar"
+)
+
+// detectArchiveFormat 根据文件头判断压缩包格式，不支持时返回空字符串
+func detectArchiveFormat(r io.ReaderAt) string {
+	header := make([]byte, 512)
+	n, _ := r.ReadAt(header, 0)
+	header = header[:n]
+	switch {
+	case bytes.HasPrefix(header, []byte("PK\x03\x04")), bytes.HasPrefix(header, []byte("PK\x05\x06")):
+		return archiveZip
+	case bytes.HasPrefix(header, []byte{0x1f, 0x8b}):
+		return archiveTarGz
+	case len(header) >= 262 && string(header[257:262]) == "ustar":
+		return archiveTar
+	}
+	return ""
+}
+
+// cleanArchivePath 检查压缩包中的文件路径，返回所在目录和文件名
+// 绝对路径、盘符和包含 .. 的路径可能写到目标目录之外（zip slip），一律拒绝
+func cleanArchivePath(name string) (dir, base string, err error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if strings.HasPrefix(name, "/") || strings.Contains(name, ":") {
+		return "", "", errors.New("不安全的路径")
+	}
+	var segments []string
+	for _, segment := range strings.Split(name, "/") {
+		switch {
+		case segment == "" || segment == ".":
+			continue
+		case segment == "..":
+			return "", "", errors.New("不安全的路径")
+		case strings.HasPrefix(segment, ".") || segment == "__MACOSX":
+			return "", "", errors.New("隐藏文件")
+		case strings.ContainsFunc(segment, func(r rune) bool { return r < 0x20 || r == 0x7f }):
+			return "", "", errors.New("不安全的路径")
+		}
+		segments = append(segments, segment)
+	}
+	if len(segments) == 0 {
+		return "", "", errors.New("不安全的路径")
+	}
+	return path.Join(segments[:len(segments)-1]...), segments[len(segments)-1], nil
+}
+
+// archiveImport 一次压缩包导入的选项和每个文件的结果
+type archiveImport struct {
+	keepDirs   bool // 是否保留压缩包中的目录结构
+	encodeOpts *EncodeOptions
+
+	mu        sync.Mutex
+	results   []gin.H
+	files     int    // 已处理的文件数（不含目录）
+	totalSize int64  // 已接受的文件解压后的总大小
+	stopped   string // 达到限制后停止导入的原因
+}
+
+// admit 检查压缩包中的一个文件是否需要导入，需要时返回结果的序号和保存的子目录
+// 不导入的文件直接记录结果；达到文件数或总大小限制后 stopped 不为空，调用方应停止读取
+func (imp *archiveImport) admit(name string, size int64, regular bool) (index int, dir string, ok bool) {
+	imp.mu.Lock()
+	defer imp.mu.Unlock()
+
+	imp.files++
+	if imp.files > config.ArchiveMaxFiles {
+		imp.stopped = fmt.Sprintf("压缩包中的文件超过 %d 个，之后的文件未导入", config.ArchiveMaxFiles)
+		return 0, "", false
+	}
+
+	result := gin.H{"entry": name}
+	imp.results = append(imp.results, result)
+	index = len(imp.results) - 1
+
+	entryDir, base, err := cleanArchivePath(name)
+	switch {
+	case err != nil:
+		result["status"], result["message"] = "skipped", err.Error()
+	case !regular:
+		result["status"], result["message"] = "skipped", "不是普通文件"
+	case !isSupportedImage(path.Ext(base)):
+		result["status"], result["message"] = "skipped", "不支持的文件类型"
+	case size > config.ArchiveFileMaxSize:
+		result["status"], result["message"] = "error", fmt.Sprintf("文件超过大小限制 (%d MB)", config.ArchiveFileMaxSize/(1024*1024))
+	case imp.totalSize+size > config.ArchiveTotalSize:
+		imp.results = imp.results[:index]
+		imp.stopped = fmt.Sprintf("解压后的总大小超过 %d MB，之后的文件未导入", config.ArchiveTotalSize/(1024*1024))
+	default:
+		imp.totalSize += size
+		if imp.keepDirs {
+			dir = entryDir
+		}
+		return index, dir, true
+	}
+	return 0, "", false
+}
+
+// importEntry 按普通上传的流程保存和转换压缩包中的一张图片
+func (imp *archiveImport) importEntry(index int, dir string, r io.Reader) {
+	imp.mu.Lock()
+	name := imp.results[index]["entry"].(string)
+	imp.mu.Unlock()
+
+	result := recoverUpload(name, func() gin.H {
+		result, err := processUpload(&uploadSource{
+			Reader:   r,
+			Filename: path.Base(strings.ReplaceAll(name, "\\", "/")),
+			Dir:      dir,
+		}, imp.encodeOpts)
+		if err != nil {
+			return gin.H{"status": "error", "message": err.Error()}
+		}
+		return result
+	})
+	result["entry"] = name
+
+	imp.mu.Lock()
+	imp.results[index] = result
+	imp.mu.Unlock()
+}
+
+// importZip 导入zip中的图片，文件可以随机读取，因此并发处理
+func (imp *archiveImport) importZip(r io.ReaderAt, size int64) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+
+	// 解压和保存并发进行，转换数量仍由 processUpload 中的转换池限制
+	var wg sync.WaitGroup
+	workers := make(chan struct{}, config.UploadWorkers)
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		// zip 读取时会检查解压后的大小与声明的 UncompressedSize64 一致，声明的大小可以用于限制
+		index, dir, ok := imp.admit(f.Name, int64(f.UncompressedSize64), f.Mode().IsRegular())
+		if imp.stopped != "" {
+			break
+		}
+		if !ok {
+			continue
+		}
+
+		wg.Add(1)
+		workers <- struct{}{}
+		go func(f *zip.File) {
+			defer wg.Done()
+			defer func() { <-workers }()
+			rc, err := f.Open()
+			if err != nil {
+				imp.mu.Lock()
+				imp.results[index]["status"], imp.results[index]["message"] = "error", "读取压缩包中的文件失败"
+				imp.mu.Unlock()
+				return
+			}
+			defer rc.Close()
+			imp.importEntry(index, dir, rc)
+		}(f)
+	}
+	wg.Wait()
+	return nil
+}
+
+// importTar 导入tar或tar.gz中的图片，只能顺序读取，逐个处理
+func (imp *archiveImport) importTar(r io.Reader, gzipped bool) error {
+	if gzipped {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	tr := tar.NewReader(r)
+	for imp.stopped == "" {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag == tar.TypeDir || header.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+		// tar 中文件的大小就是实际读取的字节数，链接和设备文件不会导入
+		index, dir, ok := imp.admit(header.Name, header.Size, header.Typeflag == tar.TypeReg)
+		if ok {
+			imp.importEntry(index, dir, tr)
+		}
+	}
+	return nil
+}
+
+// report 汇总导入结果，格式与批量上传相同，另外统计跳过的文件
+func (imp *archiveImport) report() gin.H {
+	succeeded, failed, skipped := 0, 0, 0
+	for _, result := range imp.results {
+		switch result["status"] {
+		case "success":
+			succeeded++
+		case "skipped":
+			skipped++
+		default:
+			failed++
+		}
+	}
+
+	status := batchStatus(succeeded, succeeded+failed)
+	if succeeded == 0 {
+		status = "error"
+	}
+	report := gin.H{
+		"status":    status,
+		"total":     len(imp.results),
+		"succeeded": succeeded,
+		"failed":    failed,
+		"skipped":   skipped,
+		"results":   imp.results,
+	}
+	if imp.stopped != "" {
+		report["message"] = imp.stopped
+	} else if succeeded+failed == 0 {
+		report["message"] = "压缩包中没有支持的图片"
+	}
+	return report
+}
+
+// archiveUploadHandler 导入zip、tar.gz或tar压缩包中的所有图片，每张图片按普通上传的流程转换
+// 表单字段 archive 为压缩包，structure=keep 时在日期目录下保留压缩包中的目录结构，默认为 flatten
+func archiveUploadHandler(c *gin.Context) {
+	// 预留1MB给表单的其他字段
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, config.ArchiveMaxSize+1024*1024)
+	header, err := c.FormFile("archive")
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			respondUploadError(c, &uploadError{http.StatusRequestEntityTooLarge, fmt.Sprintf("压缩包超过大小限制 (%d MB)", config.ArchiveMaxSize/(1024*1024))})
+			return
+		}
+		respondUploadError(c, &uploadError{http.StatusBadRequest, "获取文件错误"})
+		return
+	}
+	if header.Size > config.ArchiveMaxSize {
+		respondUploadError(c, &uploadError{http.StatusRequestEntityTooLarge, fmt.Sprintf("压缩包超过大小限制 (%d MB)", config.ArchiveMaxSize/(1024*1024))})
+		return
+	}
+
+	encodeOpts, err := parseEncodeOptions(c)
+	if err != nil {
+		respondUploadError(c, &uploadError{http.StatusBadRequest, err.Error()})
+		return
+	}
+	imp := &archiveImport{encodeOpts: encodeOpts}
+	switch c.PostForm("structure") {
+	case "", "flatten":
+	case "keep":
+		imp.keepDirs = true
+	default:
+		respondUploadError(c, &uploadError{http.StatusBadRequest, "structure 必须是 keep 或 flatten"})
+		return
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		respondUploadError(c, &uploadError{http.StatusBadRequest, "获取文件错误"})
+		return
+	}
+	defer file.Close()
+
+	switch detectArchiveFormat(file) {
+	case archiveZip:
+		err = imp.importZip(file, header.Size)
+	case archiveTarGz:
+		err = imp.importTar(file, true)
+	case archiveTar:
+		err = imp.importTar(file, false)
+	default:
+		respondUploadError(c, &uploadError{http.StatusBadRequest, "不支持的压缩包格式，只支持 zip、tar.gz 和 tar"})
+		return
+	}
+	if err != nil {
+		log.Printf("读取压缩包 %s 失败: %v", header.Filename, err)
+		if len(imp.results) == 0 {
+			respondUploadError(c, &uploadError{http.StatusBadRequest, "压缩包无效"})
+			return
+		}
+		// 已导入的图片仍然有效，返回到损坏位置为止的结果
+		imp.stopped = "压缩包已损坏，之后的文件未导入"
+	}
+
+	report := imp.report()
+	log.Printf("导入压缩包 %s: 成功 %d 个，失败 %d 个，跳过 %d 个", header.Filename, report["succeeded"], report["failed"], report["skipped"])
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,495 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	backupFormat       = "webp-img-backup"
+	backupVersion      = 1
+	backupManifestName = "manifest.json"
+
+	// 最近修改过的文件可能正在写入（例如转换中的WebP），等待这段时间后再备份
+	backupSettleTime = 2 * time.Second
+	// 一直在变化的文件最多重试的轮数，之后跳过并记录在清单中
+	backupRetryRounds = 5
+)
+
+// backupSection 备份中的一个目录，name 为压缩包中的顶层目录名
+type backupSection struct {
+	name string
+	dir  string
+}
+
+// backupSections 需要备份的目录
+// 先备份WebP再备份原图：备份期间新上传的图片最多只缺少WebP（访问时会重新生成），不会出现没有原图的WebP
+// WebP目录中包括动画的视频版本；衍生版本可以重新生成，可续传上传的临时数据没有保留价值，都不备份
+// 访问密码和JWT密钥只来自环境变量，服务器没有保存用户或令牌，备份中不包含任何凭据
+func backupSections() []backupSection {
+	return []backupSection{
+		{"webp", config.WebpDir},
+		{"pics", config.PicsDir},
+		{"meta", config.MetaDir},
+		{"versions", config.VersionDir},
+	}
+}
+
+// backupFile 清单中的一个文件
+type backupFile struct {
+	Path    string    `json:"path"` // 压缩包中的路径，形如 pics/25/06/18/1750214400-123.jpg
+	Size    int64     `json:"size"`
+	SHA256  string    `json:"sha256"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// backupSkipped 清单中未能备份的文件及原因
+type backupSkipped struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+// backupManifest 备份清单，作为最后一个文件写入压缩包，恢复时用于校验
+type backupManifest struct {
+	Format    string          `json:"format"`
+	Version   int             `json:"version"`
+	CreatedAt time.Time       `json:"created_at"`
+	Sections  []string        `json:"sections"`
+	Files     []backupFile    `json:"files"`
+	Skipped   []backupSkipped `json:"skipped,omitempty"`
+}
+
+// isTempFile 判断是否为写入过程中的临时文件，这些文件不备份
+func isTempFile(name string) bool {
+	return strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".tmp")
+}
+
+// backupWriter 将文件写入备份压缩包
+type backupWriter struct {
+	tw       *tar.Writer
+	spool    *os.File // 暂存文件内容，确认读取期间文件没有变化后再写入压缩包
+	manifest *backupManifest
+}
+
+// errFileChanged 文件在读取期间发生了变化或刚被修改过
+var errFileChanged = errors.New("文件正在写入")
+
+// add 备份一个文件，文件刚被修改过或读取期间发生变化时返回 errFileChanged
+func (w *backupWriter) add(name, path string) error {
+	before, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if time.Since(before.ModTime()) < backupSettleTime {
+		return errFileChanged
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	if err := w.spool.Truncate(0); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := w.spool.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(w.spool, hash), f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	after, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if size != after.Size() || !after.ModTime().Equal(before.ModTime()) {
+		return errFileChanged
+	}
+
+	if err := w.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     0644,
+		ModTime:  after.ModTime(),
+	}); err != nil {
+		return err
+	}
+	if _, err := w.spool.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.CopyN(w.tw, w.spool, size); err != nil {
+		return err
+	}
+
+	w.manifest.Files = append(w.manifest.Files, backupFile{
+		Path:    name,
+		Size:    size,
+		SHA256:  hex.EncodeToString(hash.Sum(nil)),
+		ModTime: after.ModTime(),
+	})
+	return nil
+}
+
+// writeBackup 将所有目录写入备份压缩包，返回清单
+func writeBackup(out io.Writer) (*backupManifest, error) {
+	spool, err := os.CreateTemp("", "webp-img-backup-*")
+	if err != nil {
+		return nil, fmt.Errorf("创建临时文件失败: %w", err)
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	gz := gzip.NewWriter(out)
+	w := &backupWriter{
+		tw:       tar.NewWriter(gz),
+		spool:    spool,
+		manifest: &backupManifest{Format: backupFormat, Version: backupVersion, CreatedAt: time.Now()},
+	}
+
+	for _, section := range backupSections() {
+		w.manifest.Sections = append(w.manifest.Sections, section.name)
+
+		// 正在写入的文件放到本目录的最后重试，保证同一目录的文件在压缩包中相邻
+		pending := make(map[string]string)
+		err := filepath.WalkDir(section.dir, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if errors.Is(err, fs.ErrNotExist) && p == section.dir {
+					return nil
+				}
+				return err
+			}
+			if !d.Type().IsRegular() || isTempFile(d.Name()) {
+				return nil
+			}
+			rel, err := filepath.Rel(section.dir, p)
+			if err != nil {
+				return err
+			}
+			name := path.Join(section.name, filepath.ToSlash(rel))
+			if err := w.add(name, p); errors.Is(err, errFileChanged) {
+				pending[name] = p
+			} else if errors.Is(err, fs.ErrNotExist) {
+				// 遍历后被删除或重命名的文件（例如旧的历史版本）不需要备份
+				return nil
+			} else if err != nil {
+				return fmt.Errorf("备份 %s 失败: %w", p, err)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for round := 0; round < backupRetryRounds && len(pending) > 0; round++ {
+			time.Sleep(backupSettleTime)
+			for name, p := range pending {
+				err := w.add(name, p)
+				if err == nil || errors.Is(err, fs.ErrNotExist) {
+					delete(pending, name)
+				} else if !errors.Is(err, errFileChanged) {
+					return nil, fmt.Errorf("备份 %s 失败: %w", p, err)
+				}
+			}
+		}
+		for name := range pending {
+			log.Printf("警告: %s 一直在变化，未备份", name)
+			w.manifest.Skipped = append(w.manifest.Skipped, backupSkipped{Path: name, Reason: errFileChanged.Error()})
+		}
+	}
+
+	data, err := json.MarshalIndent(w.manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := w.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     backupManifestName,
+		Size:     int64(len(data)),
+		Mode:     0644,
+		ModTime:  w.manifest.CreatedAt,
+	}); err != nil {
+		return nil, err
+	}
+	if _, err := w.tw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return w.manifest, nil
+}
+
+// backupCommand 执行 backup 子命令
+// 先写入 .partial 文件，完成后再重命名，中断的备份不会被误认为是完整的
+func backupCommand(args []string) int {
+	flags := flag.NewFlagSet("backup", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), commandUsage)
+		flags.PrintDefaults()
+	}
+	output := flags.String("o", "", "输出文件，默认为当前目录下的 webp-img-backup-时间.tar.gz")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *output == "" {
+		*output = "webp-img-backup-" + time.Now().Format("20060102-150405") + ".tar.gz"
+	}
+	// 备份文件写在上传目录或被备份的目录中时会通过 /uploads 公开访问，还会被下一次备份打包进去
+	dirs := []string{config.UploadDir}
+	for _, section := range backupSections() {
+		dirs = append(dirs, section.dir)
+	}
+	for _, dir := range dirs {
+		if pathWithin(dir, *output) {
+			log.Printf("备份文件不能保存在数据目录 %s 中，请使用 -o 指定其他位置", dir)
+			return 1
+		}
+	}
+
+	partial := *output + ".partial"
+	f, err := os.Create(partial)
+	if err != nil {
+		log.Printf("创建备份文件失败: %v", err)
+		return 1
+	}
+	manifest, err := writeBackup(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(partial, *output)
+	}
+	if err != nil {
+		os.Remove(partial)
+		log.Printf("备份失败: %v", err)
+		return 1
+	}
+
+	log.Printf("备份完成: %s，共 %d 个文件，跳过 %d 个", *output, len(manifest.Files), len(manifest.Skipped))
+	log.Printf("备份中不包含访问密码和JWT密钥，迁移时请另外保留 WEBP_ACCESS_PASSWORD 和 WEBP_JWT_SECRET 等环境变量")
+	return 0
+}
+
+// pathWithin 判断 p 是否位于目录 dir 之中（按绝对路径比较）
+func pathWithin(dir, p string) bool {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	absPath, err := filepath.Abs(p)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(absDir, absPath)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, "../")
+}
+
+// readBackup 将备份解压到各目录对应的临时目录，返回清单和实际解压的文件
+// 路径经过检查，不会写到临时目录之外
+func readBackup(r io.Reader, staging map[string]string) (*backupManifest, map[string]backupFile, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("不是有效的备份文件: %w", err)
+	}
+	defer gz.Close()
+
+	var manifest *backupManifest
+	extracted := make(map[string]backupFile)
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("读取备份失败: %w", err)
+		}
+		// 目录会随文件一起创建；重新打包的备份中可能包含目录条目
+		if header.Typeflag == tar.TypeDir {
+			continue
+		}
+		if header.Typeflag != tar.TypeReg {
+			return nil, nil, fmt.Errorf("备份中包含不支持的条目: %s", header.Name)
+		}
+
+		if header.Name == backupManifestName {
+			manifest = &backupManifest{}
+			if err := json.NewDecoder(tr).Decode(manifest); err != nil {
+				return nil, nil, fmt.Errorf("解析备份清单失败: %w", err)
+			}
+			continue
+		}
+
+		dir, base, err := cleanArchivePath(header.Name)
+		section, rest, _ := strings.Cut(dir, "/")
+		root, ok := staging[section]
+		if err != nil || !ok {
+			return nil, nil, fmt.Errorf("备份中包含无效的路径: %s", header.Name)
+		}
+		dst := filepath.Join(root, filepath.FromSlash(rest), base)
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return nil, nil, err
+		}
+		f, err := os.Create(dst)
+		if err != nil {
+			return nil, nil, err
+		}
+		hash := sha256.New()
+		size, err := io.Copy(io.MultiWriter(f, hash), tr)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("解压 %s 失败: %w", header.Name, err)
+		}
+		os.Chtimes(dst, header.ModTime, header.ModTime)
+		extracted[header.Name] = backupFile{Path: header.Name, Size: size, SHA256: hex.EncodeToString(hash.Sum(nil))}
+	}
+
+	if manifest == nil {
+		return nil, nil, errors.New("备份中没有清单，可能不完整")
+	}
+	return manifest, extracted, nil
+}
+
+// verifyBackup 按清单校验解压出的文件：不能缺少、不能多出，大小和SHA-256必须一致
+func verifyBackup(manifest *backupManifest, extracted map[string]backupFile) error {
+	if manifest.Format != backupFormat || manifest.Version > backupVersion {
+		return fmt.Errorf("不支持的备份格式: %s 版本 %d", manifest.Format, manifest.Version)
+	}
+	listed := make(map[string]bool, len(manifest.Files))
+	for _, file := range manifest.Files {
+		listed[file.Path] = true
+		got, ok := extracted[file.Path]
+		if !ok {
+			return fmt.Errorf("备份中缺少文件: %s", file.Path)
+		}
+		if got.Size != file.Size || got.SHA256 != file.SHA256 {
+			return fmt.Errorf("文件校验失败: %s", file.Path)
+		}
+	}
+	for name := range extracted {
+		if !listed[name] {
+			return fmt.Errorf("备份中包含清单之外的文件: %s", name)
+		}
+	}
+	return nil
+}
+
+// hasFiles 判断目录中是否已经有文件，只有空目录的实例视为新实例
+func hasFiles(dir string) bool {
+	found := false
+	filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			found = true
+			return fs.SkipAll
+		}
+		return nil
+	})
+	return found
+}
+
+// restoreCommand 执行 restore 子命令
+// 先解压到各目录旁边的临时目录并按清单校验，全部通过后才替换目标目录，失败时不改变现有数据
+func restoreCommand(args []string) int {
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprint(os.Stderr, commandUsage)
+		return 2
+	}
+
+	sections := backupSections()
+	for _, section := range sections {
+		if hasFiles(section.dir) {
+			log.Printf("恢复失败: %s 不为空，只能恢复到新实例", section.dir)
+			return 1
+		}
+	}
+
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		log.Printf("打开备份文件失败: %v", err)
+		return 1
+	}
+	defer f.Close()
+
+	// 临时目录与目标目录在同一文件系统中，校验通过后可以直接重命名
+	staging := make(map[string]string)
+	for _, section := range sections {
+		staging[section.name] = section.dir + ".restoring"
+	}
+	cleanup := func() {
+		for _, dir := range staging {
+			os.RemoveAll(dir)
+		}
+	}
+	cleanup()
+	for _, dir := range staging {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			log.Printf("创建临时目录失败: %v", err)
+			cleanup()
+			return 1
+		}
+	}
+
+	manifest, extracted, err := readBackup(f, staging)
+	if err == nil {
+		err = verifyBackup(manifest, extracted)
+	}
+	if err != nil {
+		log.Printf("恢复失败: %v", err)
+		cleanup()
+		return 1
+	}
+
+	for _, section := range sections {
+		// 目标目录只包含空目录（已在开始时检查），替换前再确认一次
+		if hasFiles(section.dir) {
+			log.Printf("恢复失败: %s 在恢复期间写入了文件", section.dir)
+			cleanup()
+			return 1
+		}
+		if err := os.RemoveAll(section.dir); err != nil {
+			log.Printf("恢复失败: %v", err)
+			cleanup()
+			return 1
+		}
+		if err := os.Rename(staging[section.name], section.dir); err != nil {
+			log.Printf("恢复 %s 失败: %v", section.dir, err)
+			cleanup()
+			return 1
+		}
+	}
+
+	log.Printf("恢复完成: 共 %d 个文件，备份时间 %s", len(manifest.Files), manifest.CreatedAt.Format("2006-01-02 15:04:05"))
+	if len(manifest.Skipped) > 0 {
+		log.Printf("警告: 备份时有 %d 个文件未能备份", len(manifest.Skipped))
+	}
+	return 0
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"runtime/debug"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 转换池，限制所有上传请求同时进行的转换数量，容量为 UploadWorkers
+var conversionSlots chan struct{}
+
+// withConversionSlot 占用转换池中的一个位置执行 fn，fn 发生panic时也会释放
+func withConversionSlot(fn func()) {
+	conversionSlots <- struct{}{}
+	defer func() { <-conversionSlots }()
+	fn()
+}
+
+// recoverUpload 执行单个文件的上传处理，将其中的panic转换为该文件的错误结果
+// 批量上传在单独的goroutine中处理文件，gin的Recovery中间件无法捕获，panic会导致整个服务退出
+func recoverUpload(name string, fn func() gin.H) (result gin.H) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("处理上传文件 %s 时发生panic: %v\n%s", name, r, debug.Stack())
+			result = gin.H{"status": "error", "message": "处理文件失败"}
+		}
+	}()
+	return fn()
+}
+
+// ndjsonContentType 逐行返回批量上传进度时使用的内容类型
+const ndjsonContentType = "application/x-ndjson"
+
+// wantsNDJSON 判断客户端是否要求以NDJSON逐个返回上传结果
+func wantsNDJSON(c *gin.Context) bool {
+	return strings.Contains(c.GetHeader("Accept"), ndjsonContentType)
+}
+
+// wantsBatchResponse 判断只有一个文件时是否也按批量上传的格式返回结果
+func wantsBatchResponse(c *gin.Context) bool {
+	batch := c.PostForm("batch")
+	return batch == "true" || batch == "1" || batch == "yes" || wantsNDJSON(c)
+}
+
+// uploadFormFile 处理批量上传中的单个文件，失败时返回错误状态而不影响其他文件
+func uploadFormFile(header *multipart.FileHeader, encodeOpts *EncodeOptions) gin.H {
+	file, err := header.Open()
+	if err != nil {
+		return gin.H{"status": "error", "message": "获取文件错误"}
+	}
+	defer file.Close()
+
+	response, err := processUpload(&uploadSource{
+		Reader:      file,
+		Filename:    header.Filename,
+		ContentType: header.Header.Get("Content-Type"),
+	}, encodeOpts)
+	if err != nil {
+		return gin.H{"status": "error", "message": err.Error()}
+	}
+	return response
+}
+
+// batchUploadHandler 并发处理表单中的多个文件，每个文件单独返回成功或失败
+// 请求头 Accept 包含 application/x-ndjson 时，每完成一个文件输出一行结果，最后一行为汇总
+func batchUploadHandler(c *gin.Context, headers []*multipart.FileHeader, encodeOpts *EncodeOptions) {
+	if len(headers) > config.UploadMaxFiles {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  "error",
+			"message": fmt.Sprintf("单次最多上传 %d 个文件", config.UploadMaxFiles),
+		})
+		return
+	}
+
+	// 文件已由表单解析保存，转换数量由 processUpload 中的转换池限制
+	results := make([]gin.H, len(headers))
+	done := make(chan int, len(headers))
+	for i, header := range headers {
+		go func(i int, header *multipart.FileHeader) {
+			result := recoverUpload(header.Filename, func() gin.H {
+				return uploadFormFile(header, encodeOpts)
+			})
+			result["index"] = i
+			result["filename"] = header.Filename
+			results[i] = result
+			done <- i
+		}(i, header)
+	}
+
+	stream := wantsNDJSON(c)
+	if stream {
+		c.Header("Content-Type", ndjsonContentType)
+		c.Header("Cache-Control", "no-cache")
+		c.Header("X-Accel-Buffering", "no") // 禁止反向代理缓冲，保证进度实时到达
+		c.Status(http.StatusOK)
+	}
+
+	succeeded := 0
+	encoder := json.NewEncoder(c.Writer)
+	for range headers {
+		i := <-done
+		if results[i]["status"] == "success" {
+			succeeded++
+		}
+		if stream {
+			if err := encoder.Encode(results[i]); err != nil {
+				// 客户端断开后继续等待剩余文件处理完成，已上传的图片仍然有效
+				log.Printf("输出上传进度失败: %v", err)
+				stream = false
+				continue
+			}
+			c.Writer.Flush()
+		}
+	}
+	log.Printf("批量上传完成: 成功 %d 个，失败 %d 个", succeeded, len(headers)-succeeded)
+
+	summary := gin.H{
+		"status":    batchStatus(succeeded, len(headers)),
+		"total":     len(headers),
+		"succeeded": succeeded,
+		"failed":    len(headers) - succeeded,
+	}
+	if wantsNDJSON(c) {
+		if stream {
+			summary["done"] = true
+			encoder.Encode(summary)
+		}
+		return
+	}
+	summary["results"] = results
+	c.JSON(http.StatusOK, summary)
+}
+
+// batchStatus 返回批量上传的整体状态: success/partial/error
+func batchStatus(succeeded, total int) string {
+	switch succeeded {
+	case total:
+		return "success"
+	case 0:
+		return "error"
+	}
+	return "partial"
+}
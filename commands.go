@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// commandUsage 子命令的用法说明
+const commandUsage = `用法: webp-img [命令] [参数]
+
+不带命令时启动服务器。命令:
+  backup  [-o 文件]   备份原图、WebP、元数据和历史版本到 tar.gz，服务器运行时也可以执行
+                      不包含凭据：访问密码和JWT密钥（WEBP_ACCESS_PASSWORD、WEBP_JWT_SECRET）
+                      只来自环境变量，迁移时需要另外带到新实例
+  restore 文件        校验备份并恢复到新实例（目标目录必须为空）
+  verify  [-repair] [-dir 目录] [-json]
+                      校验原图、WebP和校验和，-repair 时重新生成有问题的WebP
+`
+
+// runCommand 执行子命令，返回进程退出码；配置与服务器相同，从环境变量读取
+func runCommand(args []string) int {
+	switch args[0] {
+	case "backup":
+		return backupCommand(args[1:])
+	case "restore":
+		return restoreCommand(args[1:])
+	case "verify":
+		return verifyCommand(args[1:])
+	case "help", "-h", "-help", "--help":
+		fmt.Print(commandUsage)
+		return 0
+	}
+	fmt.Fprintf(os.Stderr, "未知命令: %s\n\n%s", args[0], commandUsage)
+	return 2
+}
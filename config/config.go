@@ -3,7 +3,11 @@ package config
 import (
 	"log"
 	"os"
+	"runtime"
+	"slices"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -17,11 +21,55 @@ type Config struct {
 	TemplateDir string
 	PicsDir     string // 原始图片目录
 	WebpDir     string // WebP图片目录
+	MetaDir     string // 图片元数据目录
+	VariantDir  string // 衍生版本目录（栅格化尺寸、海报帧等）
+	VersionDir  string // 历史版本目录（编辑或重新生成前的原图和WebP）
+	ChunkDir    string // 可续传上传的临时目录（不对外公开）
 
 	// 图片转换配置
-	WebPQuality           int  // WebP质量 (1-100)
-	ConvertExistingImages bool // 启动时是否转换现有图片
-	ForceRegenerateWebP   bool // 是否强制重新生成WebP文件（即使已存在）
+	WebPQuality           int      // WebP质量 (1-100)
+	WebPLossless          bool     // 默认是否使用无损压缩
+	WebPNearLossless      int      // 默认近无损预处理强度 (0-100)，100 表示关闭
+	WebPPreset            string   // 默认cwebp预设 (photo/picture/drawing/icon/text)，为空表示不使用
+	AutoEncodeMode        bool     // 未指定编码参数时是否根据图片内容自动选择编码模式
+	AutoOrient            bool     // 是否按EXIF方向自动旋转图片
+	MetadataPolicy        string   // 元数据策略: strip(全部移除)/icc(只保留ICC)/all(全部保留)
+	SanitizeOriginals     bool     // 是否按元数据策略清理上传的原始图片
+	ICCMode               string   // ICC颜色配置文件处理方式: keep(保留)/srgb(转换为sRGB)/ignore(由元数据策略决定)
+	SVGRasterize          bool     // 是否将SVG栅格化为WebP，关闭时直接提供清理后的SVG
+	SVGRasterWidths       []int    // SVG栅格化宽度，第一个用于默认WebP，其余生成衍生版本；为空时使用SVG自身尺寸
+	ResponsiveWidths      []int    // 上传后预先生成的响应式宽度，从小到大排列，为空表示不生成
+	ResponsiveSizes       string   // 生成srcset代码时使用的sizes属性
+	VideoFormats          []string // 动画GIF额外生成的视频格式 (mp4/webm)，按优先级排列，为空表示不生成
+	VideoCRF              int      // 视频编码质量 (CRF，0-63)，数值越小质量越高
+	ConvertExistingImages bool     // 启动时是否转换现有图片
+	ForceRegenerateWebP   bool     // 是否强制重新生成WebP文件（即使已存在）
+	MaxVersions           int      // 每张图片保留的历史版本数，0 表示不保留
+
+	// 水印配置
+	WatermarkPresets map[string]WatermarkPreset // 水印预设，从 WEBP_WATERMARK_FILE 指定的JSON文件加载
+	Watermark        string                     // 转换时为所有版本添加的水印预设名称，为空表示不添加
+
+	// 上传配置
+	UploadWorkers  int   // 同时进行的图片转换数量，所有上传请求共用
+	UploadMaxFiles int   // 单个上传请求最多包含的文件数
+	UploadMaxBody  int64 // 表单上传请求体的最大字节数
+
+	// 可续传上传（tus）配置
+	ChunkMaxSize int64         // 可续传上传的最大文件大小（字节）
+	ChunkExpiry  time.Duration // 未完成的上传保留时间，超时后删除
+
+	// 压缩包导入配置
+	ArchiveMaxSize     int64 // 上传的压缩包最大字节数
+	ArchiveMaxFiles    int   // 压缩包中最多处理的文件数
+	ArchiveFileMaxSize int64 // 压缩包中单个文件解压后的最大字节数
+	ArchiveTotalSize   int64 // 压缩包解压后的总字节数上限，防止压缩炸弹
+
+	// 远程URL上传配置
+	RemoteMaxSize      int64         // 从URL上传时允许下载的最大字节数
+	RemoteTimeout      time.Duration // 下载远程图片的总超时时间
+	RemoteMaxRedirects int           // 最多跟随的重定向次数
+	RemoteAllowedHosts []string      // 允许访问的内网主机名、IP或网段（CIDR），默认禁止访问内网地址
 
 	// 安全配置
 	AccessPassword    string        // 页面访问密码
@@ -29,6 +77,14 @@ type Config struct {
 	JWTExpirationTime time.Duration // JWT 过期时间
 	MaxLoginAttempts  int           // 最大登录尝试次数
 	LockoutDuration   time.Duration // 锁定时间
+
+	// 跨域（CORS）配置
+	CORSAllowedOrigins   []string      // 允许的来源，"*" 表示任意来源，支持 https://*.example.com 形式的子域名通配
+	CORSAllowedMethods   []string      // 预检响应中允许的方法
+	CORSAllowedHeaders   []string      // 预检响应中允许的请求头，"*" 表示回显请求的头
+	CORSExposedHeaders   []string      // 允许浏览器脚本读取的响应头
+	CORSAllowCredentials bool          // 是否允许携带凭据（Cookie）
+	CORSMaxAge           time.Duration // 预检结果缓存时间
 }
 
 // LoadConfig 从环境变量加载配置
@@ -40,12 +96,46 @@ func LoadConfig() *Config {
 		TemplateDir:       "./templates",
 		PicsDir:           "./uploads/pics", // 修改为uploads目录内的pics子目录
 		WebpDir:           "./uploads/webp", // 修改为uploads目录内的webp子目录
+		MetaDir:           "./uploads/meta",
+		VariantDir:        "./uploads/variants",
+		VersionDir:        "./uploads/versions",
+		ChunkDir:          "./uploads/chunks",
 		WebPQuality:       80,
+		WebPNearLossless:  100,
+		AutoEncodeMode:    true,
+		AutoOrient:        true,
+		MetadataPolicy:    "strip",
+		ICCMode:           "keep",
+		ResponsiveSizes:   "100vw",
+		VideoCRF:          28,
+		MaxVersions:       10,
 		AccessPassword:    "webpimg",                       // 默认页面访问密码
 		JWTSecret:         "webpimg-secure-jwt-secret-key", // 默认JWT密钥
 		JWTExpirationTime: 24 * time.Hour,                  // JWT默认过期时间为24小时
 		MaxLoginAttempts:  5,                               // 默认最大登录尝试次数
 		LockoutDuration:   1 * time.Hour,                   // 默认锁定时间为1小时
+
+		UploadWorkers:      runtime.NumCPU(),
+		UploadMaxFiles:     100,
+		UploadMaxBody:      512 * 1024 * 1024,
+		ChunkMaxSize:       1024 * 1024 * 1024,
+		ChunkExpiry:        24 * time.Hour,
+		ArchiveMaxSize:     512 * 1024 * 1024,
+		ArchiveMaxFiles:    1000,
+		ArchiveFileMaxSize: 50 * 1024 * 1024,
+		ArchiveTotalSize:   2048 * 1024 * 1024,
+		RemoteMaxSize:      20 * 1024 * 1024,
+		RemoteTimeout:      15 * time.Second,
+		RemoteMaxRedirects: 3,
+
+		// 默认不允许跨域，需通过环境变量显式开启
+		CORSAllowedMethods: []string{"GET", "HEAD", "POST", "PATCH", "DELETE", "OPTIONS"},
+		CORSMaxAge:         10 * time.Minute,
+		CORSAllowedHeaders: []string{"Content-Type", "X-CSRF-Token", "Range",
+			"Tus-Resumable", "Upload-Length", "Upload-Metadata", "Upload-Offset"},
+		CORSExposedHeaders: []string{"Content-Length", "Content-Range", "Content-Disposition",
+			"Location", "Tus-Resumable", "Tus-Version", "Tus-Extension", "Tus-Max-Size",
+			"Upload-Length", "Upload-Offset", "Upload-Expires"},
 	}
 
 	// 从环境变量读取配置，如果设置了则覆盖默认值
@@ -69,6 +159,22 @@ func LoadConfig() *Config {
 		config.WebpDir = webpDir
 	}
 
+	if metaDir := os.Getenv("WEBP_META_DIR"); metaDir != "" {
+		config.MetaDir = metaDir
+	}
+
+	if variantDir := os.Getenv("WEBP_VARIANT_DIR"); variantDir != "" {
+		config.VariantDir = variantDir
+	}
+
+	if versionDir := os.Getenv("WEBP_VERSION_DIR"); versionDir != "" {
+		config.VersionDir = versionDir
+	}
+
+	if chunkDir := os.Getenv("WEBP_CHUNK_DIR"); chunkDir != "" {
+		config.ChunkDir = chunkDir
+	}
+
 	if qualityStr := os.Getenv("WEBP_QUALITY"); qualityStr != "" {
 		if quality, err := strconv.Atoi(qualityStr); err == nil {
 			// 确保质量值在有效范围内
@@ -83,6 +189,125 @@ func LoadConfig() *Config {
 		}
 	}
 
+	if losslessStr := os.Getenv("WEBP_LOSSLESS"); losslessStr != "" {
+		config.WebPLossless = losslessStr == "true" || losslessStr == "1" || losslessStr == "yes"
+	}
+
+	if nearStr := os.Getenv("WEBP_NEAR_LOSSLESS"); nearStr != "" {
+		if near, err := strconv.Atoi(nearStr); err == nil && near >= 0 && near <= 100 {
+			config.WebPNearLossless = near
+		} else {
+			log.Printf("警告: WEBP_NEAR_LOSSLESS 必须是 0-100 之间的整数, 将使用默认值 %d", config.WebPNearLossless)
+		}
+	}
+
+	if preset := os.Getenv("WEBP_PRESET"); preset != "" {
+		switch preset = strings.ToLower(preset); preset {
+		case "photo", "picture", "drawing", "icon", "text":
+			config.WebPPreset = preset
+		default:
+			log.Printf("警告: WEBP_PRESET 无效的预设 %q, 将不使用预设", preset)
+		}
+	}
+
+	if autoStr := os.Getenv("WEBP_AUTO_MODE"); autoStr != "" {
+		config.AutoEncodeMode = autoStr == "true" || autoStr == "1" || autoStr == "yes"
+	}
+
+	if orientStr := os.Getenv("WEBP_AUTO_ORIENT"); orientStr != "" {
+		config.AutoOrient = orientStr == "true" || orientStr == "1" || orientStr == "yes"
+	}
+
+	if policy := os.Getenv("WEBP_METADATA_POLICY"); policy != "" {
+		switch policy = strings.ToLower(policy); policy {
+		case "strip", "icc", "all":
+			config.MetadataPolicy = policy
+		default:
+			log.Printf("警告: WEBP_METADATA_POLICY 无效的策略 %q, 将使用默认值 %s", policy, config.MetadataPolicy)
+		}
+	}
+
+	if sanitizeStr := os.Getenv("WEBP_SANITIZE_ORIGINALS"); sanitizeStr != "" {
+		config.SanitizeOriginals = sanitizeStr == "true" || sanitizeStr == "1" || sanitizeStr == "yes"
+	}
+
+	if iccMode := os.Getenv("WEBP_ICC_MODE"); iccMode != "" {
+		switch iccMode = strings.ToLower(iccMode); iccMode {
+		case "keep", "srgb", "ignore":
+			config.ICCMode = iccMode
+		default:
+			log.Printf("警告: WEBP_ICC_MODE 无效的处理方式 %q, 将使用默认值 %s", iccMode, config.ICCMode)
+		}
+	}
+
+	if rasterizeStr := os.Getenv("WEBP_SVG_RASTERIZE"); rasterizeStr != "" {
+		config.SVGRasterize = rasterizeStr == "true" || rasterizeStr == "1" || rasterizeStr == "yes"
+	}
+
+	if widths := os.Getenv("WEBP_SVG_WIDTHS"); widths != "" {
+		for _, item := range splitList(widths) {
+			if width, err := strconv.Atoi(item); err == nil && width > 0 && width <= 16384 {
+				config.SVGRasterWidths = append(config.SVGRasterWidths, width)
+			} else {
+				log.Printf("警告: WEBP_SVG_WIDTHS 中的宽度 %q 无效，已忽略", item)
+			}
+		}
+	}
+
+	if widths := os.Getenv("WEBP_RESPONSIVE_WIDTHS"); widths != "" {
+		seen := make(map[int]bool)
+		for _, item := range splitList(widths) {
+			if width, err := strconv.Atoi(item); err == nil && width > 0 && width <= 16384 {
+				if !seen[width] {
+					seen[width] = true
+					config.ResponsiveWidths = append(config.ResponsiveWidths, width)
+				}
+			} else {
+				log.Printf("警告: WEBP_RESPONSIVE_WIDTHS 中的宽度 %q 无效，已忽略", item)
+			}
+		}
+		sort.Ints(config.ResponsiveWidths)
+	}
+
+	if sizes := os.Getenv("WEBP_RESPONSIVE_SIZES"); sizes != "" {
+		config.ResponsiveSizes = sizes
+	}
+
+	if watermarkFile := os.Getenv("WEBP_WATERMARK_FILE"); watermarkFile != "" {
+		presets, err := loadWatermarkPresets(watermarkFile)
+		if err != nil {
+			log.Printf("警告: 加载水印预设失败: %v", err)
+		}
+		config.WatermarkPresets = presets
+	}
+
+	if watermark := os.Getenv("WEBP_WATERMARK"); watermark != "" {
+		if _, ok := config.WatermarkPresets[watermark]; ok {
+			config.Watermark = watermark
+		} else {
+			log.Printf("警告: WEBP_WATERMARK 指定的水印预设 %q 不存在，将不添加水印", watermark)
+		}
+	}
+
+	if formats := os.Getenv("WEBP_VIDEO_FORMATS"); formats != "" {
+		for _, format := range splitList(strings.ToLower(formats)) {
+			switch format {
+			case "mp4", "webm":
+				config.VideoFormats = append(config.VideoFormats, format)
+			default:
+				log.Printf("警告: WEBP_VIDEO_FORMATS 中的格式 %q 无效，已忽略", format)
+			}
+		}
+	}
+
+	if crfStr := os.Getenv("WEBP_VIDEO_CRF"); crfStr != "" {
+		if crf, err := strconv.Atoi(crfStr); err == nil && crf >= 0 && crf <= 63 {
+			config.VideoCRF = crf
+		} else {
+			log.Printf("警告: WEBP_VIDEO_CRF 必须是 0-63 之间的整数, 将使用默认值 %d", config.VideoCRF)
+		}
+	}
+
 	// 安全配置
 	if accessPwd := os.Getenv("WEBP_ACCESS_PASSWORD"); accessPwd != "" {
 		config.AccessPassword = accessPwd
@@ -112,6 +337,136 @@ func LoadConfig() *Config {
 		}
 	}
 
+	// 跨域配置
+	if origins := os.Getenv("WEBP_CORS_ALLOWED_ORIGINS"); origins != "" {
+		config.CORSAllowedOrigins = splitList(origins)
+	}
+
+	if methods := os.Getenv("WEBP_CORS_ALLOWED_METHODS"); methods != "" {
+		config.CORSAllowedMethods = splitList(strings.ToUpper(methods))
+	}
+
+	if headers := os.Getenv("WEBP_CORS_ALLOWED_HEADERS"); headers != "" {
+		config.CORSAllowedHeaders = splitList(headers)
+	}
+
+	if exposed := os.Getenv("WEBP_CORS_EXPOSED_HEADERS"); exposed != "" {
+		config.CORSExposedHeaders = splitList(exposed)
+	}
+
+	if credStr := os.Getenv("WEBP_CORS_ALLOW_CREDENTIALS"); credStr != "" {
+		config.CORSAllowCredentials = credStr == "true" || credStr == "1" || credStr == "yes"
+	}
+	// 允许任意来源携带Cookie等于允许任何网站以登录用户的身份调用接口，浏览器也禁止这种组合
+	if config.CORSAllowCredentials && slices.Contains(config.CORSAllowedOrigins, "*") {
+		log.Printf("警告: WEBP_CORS_ALLOWED_ORIGINS 为 * 时不能启用 WEBP_CORS_ALLOW_CREDENTIALS, 将不允许携带凭据")
+		config.CORSAllowCredentials = false
+	}
+
+	if maxAgeStr := os.Getenv("WEBP_CORS_MAX_AGE_SECONDS"); maxAgeStr != "" {
+		if maxAge, err := strconv.Atoi(maxAgeStr); err == nil && maxAge >= 0 {
+			config.CORSMaxAge = time.Duration(maxAge) * time.Second
+		}
+	}
+
+	// 上传配置
+	if workersStr := os.Getenv("WEBP_UPLOAD_WORKERS"); workersStr != "" {
+		if workers, err := strconv.Atoi(workersStr); err == nil && workers > 0 {
+			config.UploadWorkers = workers
+		} else {
+			log.Printf("警告: WEBP_UPLOAD_WORKERS 必须是正整数, 将使用默认值 %d", config.UploadWorkers)
+		}
+	}
+
+	if maxFilesStr := os.Getenv("WEBP_UPLOAD_MAX_FILES"); maxFilesStr != "" {
+		if maxFiles, err := strconv.Atoi(maxFilesStr); err == nil && maxFiles > 0 {
+			config.UploadMaxFiles = maxFiles
+		} else {
+			log.Printf("警告: WEBP_UPLOAD_MAX_FILES 必须是正整数, 将使用默认值 %d", config.UploadMaxFiles)
+		}
+	}
+
+	if sizeStr := os.Getenv("WEBP_UPLOAD_MAX_BODY_MB"); sizeStr != "" {
+		if size, err := strconv.Atoi(sizeStr); err == nil && size > 0 {
+			config.UploadMaxBody = int64(size) * 1024 * 1024
+		} else {
+			log.Printf("警告: WEBP_UPLOAD_MAX_BODY_MB 必须是正整数, 将使用默认值 %d", config.UploadMaxBody/(1024*1024))
+		}
+	}
+
+	// 可续传上传配置
+	if sizeStr := os.Getenv("WEBP_CHUNK_MAX_SIZE_MB"); sizeStr != "" {
+		if size, err := strconv.Atoi(sizeStr); err == nil && size > 0 {
+			config.ChunkMaxSize = int64(size) * 1024 * 1024
+		} else {
+			log.Printf("警告: WEBP_CHUNK_MAX_SIZE_MB 必须是正整数, 将使用默认值 %d", config.ChunkMaxSize/(1024*1024))
+		}
+	}
+
+	if expiryStr := os.Getenv("WEBP_CHUNK_EXPIRE_HOURS"); expiryStr != "" {
+		if expiry, err := strconv.Atoi(expiryStr); err == nil && expiry > 0 {
+			config.ChunkExpiry = time.Duration(expiry) * time.Hour
+		}
+	}
+
+	// 压缩包导入配置
+	if sizeStr := os.Getenv("WEBP_ARCHIVE_MAX_SIZE_MB"); sizeStr != "" {
+		if size, err := strconv.Atoi(sizeStr); err == nil && size > 0 {
+			config.ArchiveMaxSize = int64(size) * 1024 * 1024
+		} else {
+			log.Printf("警告: WEBP_ARCHIVE_MAX_SIZE_MB 必须是正整数, 将使用默认值 %d", config.ArchiveMaxSize/(1024*1024))
+		}
+	}
+
+	if maxFilesStr := os.Getenv("WEBP_ARCHIVE_MAX_FILES"); maxFilesStr != "" {
+		if maxFiles, err := strconv.Atoi(maxFilesStr); err == nil && maxFiles > 0 {
+			config.ArchiveMaxFiles = maxFiles
+		} else {
+			log.Printf("警告: WEBP_ARCHIVE_MAX_FILES 必须是正整数, 将使用默认值 %d", config.ArchiveMaxFiles)
+		}
+	}
+
+	if sizeStr := os.Getenv("WEBP_ARCHIVE_FILE_MAX_SIZE_MB"); sizeStr != "" {
+		if size, err := strconv.Atoi(sizeStr); err == nil && size > 0 {
+			config.ArchiveFileMaxSize = int64(size) * 1024 * 1024
+		} else {
+			log.Printf("警告: WEBP_ARCHIVE_FILE_MAX_SIZE_MB 必须是正整数, 将使用默认值 %d", config.ArchiveFileMaxSize/(1024*1024))
+		}
+	}
+
+	if sizeStr := os.Getenv("WEBP_ARCHIVE_TOTAL_SIZE_MB"); sizeStr != "" {
+		if size, err := strconv.Atoi(sizeStr); err == nil && size > 0 {
+			config.ArchiveTotalSize = int64(size) * 1024 * 1024
+		} else {
+			log.Printf("警告: WEBP_ARCHIVE_TOTAL_SIZE_MB 必须是正整数, 将使用默认值 %d", config.ArchiveTotalSize/(1024*1024))
+		}
+	}
+
+	// 远程URL上传配置
+	if sizeStr := os.Getenv("WEBP_REMOTE_MAX_SIZE_MB"); sizeStr != "" {
+		if size, err := strconv.Atoi(sizeStr); err == nil && size > 0 {
+			config.RemoteMaxSize = int64(size) * 1024 * 1024
+		} else {
+			log.Printf("警告: WEBP_REMOTE_MAX_SIZE_MB 必须是正整数, 将使用默认值 %d", config.RemoteMaxSize/(1024*1024))
+		}
+	}
+
+	if timeoutStr := os.Getenv("WEBP_REMOTE_TIMEOUT_SECONDS"); timeoutStr != "" {
+		if timeout, err := strconv.Atoi(timeoutStr); err == nil && timeout > 0 {
+			config.RemoteTimeout = time.Duration(timeout) * time.Second
+		}
+	}
+
+	if redirectsStr := os.Getenv("WEBP_REMOTE_MAX_REDIRECTS"); redirectsStr != "" {
+		if redirects, err := strconv.Atoi(redirectsStr); err == nil && redirects >= 0 {
+			config.RemoteMaxRedirects = redirects
+		}
+	}
+
+	if hosts := os.Getenv("WEBP_REMOTE_ALLOWED_HOSTS"); hosts != "" {
+		config.RemoteAllowedHosts = splitList(strings.ToLower(hosts))
+	}
+
 	// 确保上传目录存在
 	if err := os.MkdirAll(config.UploadDir, 0755); err != nil {
 		log.Fatalf("无法创建上传目录 %s: %v", config.UploadDir, err)
@@ -127,6 +482,14 @@ func LoadConfig() *Config {
 		config.ForceRegenerateWebP = forceStr == "true" || forceStr == "1" || forceStr == "yes"
 	}
 
+	if versionsStr := os.Getenv("WEBP_MAX_VERSIONS"); versionsStr != "" {
+		if versions, err := strconv.Atoi(versionsStr); err == nil && versions >= 0 {
+			config.MaxVersions = versions
+		} else {
+			log.Printf("警告: WEBP_MAX_VERSIONS 必须是非负整数, 将使用默认值 %d", config.MaxVersions)
+		}
+	}
+
 	// 确保原始图片目录存在
 	if err := os.MkdirAll(config.PicsDir, 0755); err != nil {
 		log.Fatalf("无法创建原始图片目录 %s: %v", config.PicsDir, err)
@@ -137,8 +500,39 @@ func LoadConfig() *Config {
 		log.Fatalf("无法创建WebP图片目录 %s: %v", config.WebpDir, err)
 	}
 
+	// 确保元数据目录存在
+	if err := os.MkdirAll(config.MetaDir, 0755); err != nil {
+		log.Fatalf("无法创建元数据目录 %s: %v", config.MetaDir, err)
+	}
+
+	// 确保衍生版本目录存在
+	if err := os.MkdirAll(config.VariantDir, 0755); err != nil {
+		log.Fatalf("无法创建衍生版本目录 %s: %v", config.VariantDir, err)
+	}
+
+	// 确保历史版本目录存在
+	if err := os.MkdirAll(config.VersionDir, 0755); err != nil {
+		log.Fatalf("无法创建历史版本目录 %s: %v", config.VersionDir, err)
+	}
+
+	// 确保可续传上传的临时目录存在
+	if err := os.MkdirAll(config.ChunkDir, 0755); err != nil {
+		log.Fatalf("无法创建可续传上传目录 %s: %v", config.ChunkDir, err)
+	}
+
 	log.Printf("加载配置: 端口=%s, 模板目录=%s, 原始图片目录=%s, WebP图片目录=%s, WebP质量=%d",
 		config.ServerPort, config.TemplateDir, config.PicsDir, config.WebpDir, config.WebPQuality)
 
 	return config
 }
+
+// splitList 将逗号分隔的字符串拆分为去除空白的列表
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
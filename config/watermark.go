@@ -0,0 +1,106 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+)
+
+// WatermarkPreset 一个水印预设，文字和图片二选一，同时指定时使用图片
+// 数值字段为0时使用默认值: 不透明度0.5、宽度比例0.2、边距0.02
+type WatermarkPreset struct {
+	Text     string  `json:"text"`     // 水印文字
+	Font     string  `json:"font"`     // TrueType/OpenType字体文件路径，为空时使用内置字体
+	Color    string  `json:"color"`    // 文字颜色，形如 #ffffff
+	Image    string  `json:"image"`    // 水印图片路径（建议使用带透明通道的PNG）
+	Position string  `json:"position"` // 位置: top-left/top/top-right/left/center/right/bottom-left/bottom/bottom-right
+	Opacity  float64 `json:"opacity"`  // 不透明度 (0-1]
+	Scale    float64 `json:"scale"`    // 水印宽度占图片宽度的比例 (0-1]
+	Margin   float64 `json:"margin"`   // 水印与图片边缘的距离占图片宽度的比例 [0-0.5)
+}
+
+// 水印可用的位置
+var watermarkPositions = map[string]bool{
+	"top-left":     true,
+	"top":          true,
+	"top-right":    true,
+	"left":         true,
+	"center":       true,
+	"right":        true,
+	"bottom-left":  true,
+	"bottom":       true,
+	"bottom-right": true,
+}
+
+// 水印预设名称只允许字母、数字、下划线和连字符，会用于衍生版本的文件名
+var watermarkNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+// 文字颜色格式
+var watermarkColorPattern = regexp.MustCompile(`^#[0-9A-Fa-f]{6}$`)
+
+// loadWatermarkPresets 从JSON文件加载水印预设，文件内容为预设名称到预设的映射
+// 无效的预设会被忽略，未指定的字段使用默认值
+func loadWatermarkPresets(path string) (map[string]WatermarkPreset, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取水印预设文件失败: %w", err)
+	}
+
+	var raw map[string]WatermarkPreset
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("解析水印预设文件失败: %w", err)
+	}
+
+	presets := make(map[string]WatermarkPreset)
+	for name, preset := range raw {
+		if err := normalizeWatermarkPreset(name, &preset); err != nil {
+			log.Printf("警告: 水印预设 %q 无效，已忽略: %v", name, err)
+			continue
+		}
+		presets[name] = preset
+	}
+	return presets, nil
+}
+
+// normalizeWatermarkPreset 检查水印预设并填充默认值
+func normalizeWatermarkPreset(name string, preset *WatermarkPreset) error {
+	if !watermarkNamePattern.MatchString(name) {
+		return fmt.Errorf("名称只能包含字母、数字、下划线和连字符")
+	}
+	if preset.Text == "" && preset.Image == "" {
+		return fmt.Errorf("必须指定 text 或 image")
+	}
+
+	if preset.Position == "" {
+		preset.Position = "bottom-right"
+	} else if !watermarkPositions[preset.Position] {
+		return fmt.Errorf("无效的位置 %q", preset.Position)
+	}
+
+	if preset.Color == "" {
+		preset.Color = "#ffffff"
+	} else if !watermarkColorPattern.MatchString(preset.Color) {
+		return fmt.Errorf("无效的颜色 %q", preset.Color)
+	}
+
+	if preset.Opacity == 0 {
+		preset.Opacity = 0.5
+	} else if preset.Opacity < 0 || preset.Opacity > 1 {
+		return fmt.Errorf("opacity 必须在 0-1 之间")
+	}
+
+	if preset.Scale == 0 {
+		preset.Scale = 0.2
+	} else if preset.Scale < 0 || preset.Scale > 1 {
+		return fmt.Errorf("scale 必须在 0-1 之间")
+	}
+
+	if preset.Margin == 0 {
+		preset.Margin = 0.02
+	} else if preset.Margin < 0 || preset.Margin >= 0.5 {
+		return fmt.Errorf("margin 必须在 0-0.5 之间")
+	}
+	return nil
+}
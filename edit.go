@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/suixinio/webp-img/imaging"
+	"github.com/suixinio/webp-img/storage"
+)
+
+// editRequest 图片编辑参数，按 裁剪 -> 旋转 -> 翻转 的顺序执行
+type editRequest struct {
+	Crop   *editCrop `json:"crop"`    // 裁剪区域，坐标基于按EXIF方向旋转后显示的图片
+	Rotate int       `json:"rotate"`  // 顺时针旋转角度，必须是90的倍数，负数表示逆时针
+	FlipH  bool      `json:"flip_h"`  // 水平翻转
+	FlipV  bool      `json:"flip_v"`  // 垂直翻转
+	NewURL bool      `json:"new_url"` // 保存为新图片并返回新地址，默认在原地址上更新
+}
+
+// editCrop 裁剪区域（像素）
+type editCrop struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// errEditUnsupported 图片格式不支持编辑
+var errEditUnsupported = errors.New("动画和SVG图片不支持编辑")
+
+// editOutputFormat 根据原图扩展名选择编辑结果的格式和扩展名
+// Go无法编码的格式（HEIC、JPEG XL、WebP等）和GIF（避免调色板量化）保存为PNG
+func editOutputFormat(ext string) (format, outExt string) {
+	switch strings.ToLower(ext) {
+	case ".jpg", ".jpeg":
+		return "jpeg", strings.ToLower(ext)
+	case ".png":
+		return "png", ".png"
+	case ".bmp":
+		return "bmp", ".bmp"
+	case ".tif", ".tiff":
+		return "tiff", strings.ToLower(ext)
+	}
+	return "png", ".png"
+}
+
+// loadForEdit 解码原图并按EXIF方向旋转，使坐标与显示的图片一致，同时返回原图中嵌入的元数据
+func loadForEdit(srcPath string) (image.Image, *imaging.Embedded, error) {
+	ext := strings.ToLower(filepath.Ext(srcPath))
+	if ext == ".svg" || isAnimatedGIF(srcPath) || isAnimatedWebP(srcPath) {
+		return nil, nil, errEditUnsupported
+	}
+
+	// HEIC等格式先解码为PNG，TIFF在解码时已按方向旋转
+	if needsDecoding(ext) {
+		decodedPath, err := decodeToPNG(srcPath, filepath.Dir(srcPath))
+		if err != nil {
+			return nil, nil, err
+		}
+		defer os.Remove(decodedPath)
+		embedded := &imaging.Embedded{}
+		if data, err := os.ReadFile(decodedPath); err == nil {
+			embedded = imaging.ReadEmbedded(data)
+		}
+		img, _, err := imaging.Load(decodedPath)
+		return img, embedded, err
+	}
+
+	data, err := os.ReadFile(srcPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	embedded := imaging.ReadEmbedded(data)
+	img, _, err := imaging.Load(srcPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	if config.AutoOrient {
+		if orientation := embedded.Orientation(); orientation > 1 {
+			img = imaging.Orient(img, orientation)
+		}
+	}
+	return img, embedded, nil
+}
+
+// prepareEditColor 按元数据策略确定编辑结果需要写回的元数据
+// 编辑结果由Go重新编码，不带任何元数据：能写回ICC配置文件时原样写回，
+// 否则（输出为BMP/TIFF，或元数据策略要求移除）将像素转换为sRGB，避免编辑后颜色改变
+func prepareEditColor(img image.Image, embedded *imaging.Embedded, format string) (image.Image, *imaging.Embedded) {
+	out := &imaging.Embedded{}
+	if config.MetadataPolicy == MetadataAll {
+		out.EXIF, out.XMP = embedded.EXIF, embedded.XMP
+		if config.AutoOrient {
+			// 像素已经按方向旋转
+			out.EXIF = imaging.ResetOrientation(embedded.EXIF)
+		}
+	} else if orientation := embedded.Orientation(); !config.AutoOrient && orientation != 1 {
+		// 与 sanitizeFile 相同，未旋转像素时保留只含方向的EXIF
+		out.EXIF = imaging.OrientationEXIF(orientation)
+	}
+	if len(embedded.ICC) == 0 {
+		return img, out
+	}
+
+	// 与 sanitizeFile 相同，原图在元数据策略或ICC处理需要时保留配置文件
+	keep := config.MetadataPolicy != MetadataStrip || config.ICCMode != ICCIgnore
+	if keep && (format == "jpeg" || format == "png") {
+		out.ICC = embedded.ICC
+		return img, out
+	}
+
+	profile, err := imaging.ParseICC(embedded.ICC)
+	if err != nil {
+		log.Printf("解析ICC配置文件失败: %v", err)
+		return img, out
+	}
+	if profile.IsSRGB() {
+		return img, out
+	}
+	if !profile.CanConvert() {
+		log.Printf("不支持将ICC配置文件 %q (%s) 转换为sRGB，编辑结果的颜色可能改变", profile.Description, profile.ColorSpace)
+		return img, out
+	}
+	converted, err := profile.ConvertToSRGB(img)
+	if err != nil {
+		log.Printf("转换为sRGB失败: %v", err)
+		return img, out
+	}
+	log.Printf("已将ICC配置文件 %q 的像素转换为sRGB后编辑", profile.Description)
+	return converted, out
+}
+
+// applyEdit 按编辑参数处理图片
+func applyEdit(img image.Image, req *editRequest) (image.Image, error) {
+	if req.Crop != nil {
+		rect := image.Rect(req.Crop.X, req.Crop.Y, req.Crop.X+req.Crop.Width, req.Crop.Y+req.Crop.Height)
+		cropped, err := imaging.Crop(img, rect)
+		if err != nil {
+			return nil, err
+		}
+		img = cropped
+	}
+
+	switch (req.Rotate%360 + 360) % 360 {
+	case 90:
+		img = imaging.Rotate90(img)
+	case 180:
+		img = imaging.Rotate180(img)
+	case 270:
+		img = imaging.Rotate270(img)
+	}
+
+	if req.FlipH {
+		img = imaging.FlipH(img)
+	}
+	if req.FlipV {
+		img = imaging.FlipV(img)
+	}
+	return img, nil
+}
+
+// writeEditedImage 将编辑结果和需要写回的元数据写入临时文件，再重命名到目标路径
+func writeEditedImage(img image.Image, embedded *imaging.Embedded, format, dstPath string) error {
+	var buf bytes.Buffer
+	if err := imaging.Encode(&buf, img, format); err != nil {
+		return fmt.Errorf("编码编辑结果失败: %w", err)
+	}
+	data := buf.Bytes()
+	if len(embedded.EXIF) > 0 || len(embedded.ICC) > 0 || len(embedded.XMP) > 0 {
+		data, _ = imaging.Embed(data, embedded)
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(dstPath), ".edit-*"+filepath.Ext(dstPath))
+	if err != nil {
+		return fmt.Errorf("创建临时文件失败: %w", err)
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("写入编辑结果失败: %w", err)
+	}
+	if err := os.Rename(f.Name(), dstPath); err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("保存编辑结果失败: %w", err)
+	}
+	return nil
+}
+
+// removeDerivedFiles 删除一张图片的WebP、视频和所有衍生版本，历史版本不受影响
+func removeDerivedFiles(webpPath string) {
+	paths := []string{webpPath}
+	for _, video := range listVideos(webpPath) {
+		paths = append(paths, video[1])
+	}
+	for _, path := range listVariants(webpPath) {
+		paths = append(paths, path)
+	}
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Printf("删除旧版本失败: %v", err)
+		}
+	}
+}
+
+// regenerate 从原图重新生成WebP、占位信息和响应式版本
+func regenerate(originalPath, webpPath, relPath string) error {
+	if err := os.MkdirAll(filepath.Dir(webpPath), 0755); err != nil {
+		return fmt.Errorf("创建WebP目录失败: %w", err)
+	}
+	result, err := convertToWebP(originalPath, webpPath, nil)
+	if err != nil {
+		return err
+	}
+	recordConversion(relPath, result)
+	generateResponsive(originalPath, webpPath, result)
+	if isAnimatedGIF(originalPath) {
+		scheduleVideos(originalPath, webpPath)
+	}
+	return nil
+}
+
+// imageEditHandler 裁剪、旋转或翻转图片，生成新的原图并重新生成WebP和衍生版本
+// 默认在原地址上更新，编辑前的版本保存为历史版本；new_url 为 true 时保存为新图片，原图不变
+func imageEditHandler(c *gin.Context, imagePath string) {
+	files, ok := resolveImage(imagePath)
+	if !ok || files.OriginalPath == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "原图不存在"})
+		return
+	}
+
+	var req editRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的编辑参数"})
+		return
+	}
+	if req.Rotate%90 != 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "旋转角度必须是90的倍数"})
+		return
+	}
+	if req.Crop == nil && req.Rotate%360 == 0 && !req.FlipH && !req.FlipV {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "没有指定编辑操作"})
+		return
+	}
+
+	img, embedded, err := loadForEdit(files.OriginalPath)
+	if err != nil {
+		if errors.Is(err, errEditUnsupported) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("读取待编辑图片失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法读取图片"})
+		return
+	}
+
+	oldExt := filepath.Ext(files.OriginalPath)
+	format, ext := editOutputFormat(oldExt)
+	img, embedded = prepareEditColor(img, embedded, format)
+
+	edited, err := applyEdit(img, &req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	meta, err := metaStore.Load(files.RelPath)
+	if err != nil {
+		meta = &storage.Metadata{OriginalName: filepath.Base(files.RelPath), UploadedAt: time.Now()}
+	}
+	now := time.Now()
+
+	var originalPath, webpPath, relPath string
+	if req.NewURL {
+		// 保存为新图片，元数据沿用原图的文件名
+		if originalPath, webpPath, relPath, err = generatePaths(ext); err != nil {
+			log.Printf("生成文件路径失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "保存编辑结果失败"})
+			return
+		}
+		meta.UploadedAt = now
+		meta.ColorProfile, meta.ColorHandling = "", ""
+	} else {
+		relPath = strings.TrimSuffix(files.RelPath, oldExt) + ext
+		originalPath = filepath.Join(config.PicsDir, relPath)
+		webpPath = filepath.Join(config.WebpDir, strings.TrimSuffix(relPath, ext)+".webp")
+
+		// 编辑前的原图、WebP和元数据保存为历史版本，可以通过回滚恢复
+		if _, err := saveVersion(files, webpPath, "edit"); err != nil {
+			log.Printf("保存历史版本失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "保存编辑结果失败"})
+			return
+		}
+	}
+
+	if err := writeEditedImage(edited, embedded, format, originalPath); err != nil {
+		log.Printf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存编辑结果失败"})
+		return
+	}
+	// 输出格式与原图不同时（例如HEIC保存为PNG），删除旧扩展名的原图
+	if !req.NewURL && originalPath != files.OriginalPath {
+		os.Remove(files.OriginalPath)
+	}
+
+	if info, err := os.Stat(originalPath); err == nil {
+		meta.OriginalSize = info.Size()
+	}
+	if checksum, err := fileSHA256(originalPath); err == nil {
+		meta.SHA256 = checksum
+	}
+	meta.ContentType = contentTypeByExt(ext)
+	meta.EditedAt = &now
+	// 格式改变时删除旧路径的元数据，必须在保存之前进行：元数据路径忽略扩展名，新旧路径可能对应同一个文件
+	if !req.NewURL && relPath != files.RelPath {
+		if err := metaStore.Delete(files.RelPath); err != nil {
+			log.Printf("删除旧的图片元数据失败: %v", err)
+		}
+	}
+	if err := metaStore.Save(relPath, meta); err != nil {
+		log.Printf("保存图片元数据失败: %v", err)
+	}
+
+	if !req.NewURL {
+		removeDerivedFiles(webpPath)
+	}
+	if err := regenerate(originalPath, webpPath, relPath); err != nil {
+		log.Printf("重新生成WebP失败: %v", err)
+	}
+	if !req.NewURL {
+		pruneVersions(webpPath)
+	}
+
+	bounds := edited.Bounds()
+	log.Printf("已编辑图片: %s -> %s (%dx%d)", files.RelPath, relPath, bounds.Dx(), bounds.Dy())
+	c.JSON(http.StatusOK, gin.H{
+		"path":     filepath.ToSlash(relPath),
+		"url":      "/img/" + filepath.ToSlash(relPath),
+		"meta_url": "/api/images/" + filepath.ToSlash(relPath) + "/meta",
+		"width":    bounds.Dx(),
+		"height":   bounds.Dy(),
+		"new_url":  req.NewURL,
+	})
+}
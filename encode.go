@@ -0,0 +1,495 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	cfg "github.com/suixinio/webp-img/config"
+	"github.com/suixinio/webp-img/imaging"
+)
+
+// cwebp支持的预设
+var validPresets = map[string]bool{
+	"photo":   true,
+	"picture": true,
+	"drawing": true,
+	"icon":    true,
+	"text":    true,
+}
+
+// EncodeOptions WebP编码参数
+type EncodeOptions struct {
+	Quality      int     // 有损压缩质量 (1-100)
+	Lossless     bool    // 是否使用无损压缩
+	NearLossless int     // 近无损预处理强度 (0-100)，100 表示关闭
+	Preset       string  // cwebp预设: photo/picture/drawing/icon/text，为空表示不使用预设
+	TargetSSIM   float64 // 目标SSIM (0-1)，大于0时搜索满足该值的最低质量
+	MaxSize      int64   // 输出体积上限（字节），大于0时搜索不超过该值的最高质量
+	AlphaQuality int     // 透明通道的有损压缩质量 (1-100)，0 表示使用cwebp默认值（无损），只对有损压缩生效
+	Width        int     // 输出宽度，高度按比例缩放；0 表示保持原尺寸，只用于生成响应式版本
+
+	Watermark *cfg.WatermarkPreset // 编码前添加的水印，为空表示不添加；动画图片不支持
+
+	// 动画参数，只对动画GIF生效
+	Loop        int    // 循环次数，0 表示无限循环，-1 表示保留GIF中的设置
+	FrameMode   string // 帧压缩方式: lossless/lossy/mixed，为空时使用gif2webp默认的无损压缩
+	KeyframeMin int    // 关键帧最小间隔，0 表示使用gif2webp默认值
+	KeyframeMax int    // 关键帧最大间隔，0 表示使用gif2webp默认值
+}
+
+// 动画帧压缩方式
+var validFrameModes = map[string]bool{
+	"lossless": true,
+	"lossy":    true,
+	"mixed":    true, // 逐帧选择有损或无损中体积较小的一种
+}
+
+// isLossless 判断编码参数是否使用无损或近无损模式
+func (o *EncodeOptions) isLossless() bool {
+	return o.Lossless || o.NearLossless < 100
+}
+
+// mustEncode 判断输出内容是否与原图不同（缩放或加水印），此时不能用原图代替转换结果
+// opts 为空时使用默认参数，只取决于是否配置了默认水印
+func mustEncode(opts *EncodeOptions) bool {
+	if opts == nil {
+		return config.Watermark != ""
+	}
+	return opts.Width > 0 || opts.Watermark != nil
+}
+
+// ConvertResult 记录一次转换实际使用的编码参数
+type ConvertResult struct {
+	Encode    EncodeOptions // 实际使用的编码参数，质量搜索时为最终选定的质量
+	Searched  bool          // 是否进行了质量搜索
+	SSIM      float64       // 质量搜索中测得的SSIM，未设置目标SSIM时为0
+	TargetMet bool          // 质量搜索是否达到了目标
+
+	ColorProfile  string // 原图嵌入的ICC配置文件描述
+	ColorHandling string // ICC配置文件的处理结果: none/kept/converted/stripped
+}
+
+// defaultEncodeOptions 返回配置文件中的默认编码参数
+func defaultEncodeOptions() *EncodeOptions {
+	return &EncodeOptions{
+		Quality:      config.WebPQuality,
+		Lossless:     config.WebPLossless,
+		NearLossless: config.WebPNearLossless,
+		Preset:       config.WebPPreset,
+		Loop:         -1,
+		Watermark:    defaultWatermark(),
+	}
+}
+
+// 自动选择编码模式时各分类使用的压缩质量
+// 无损和近无损模式下质量只影响返回的编码参数，统一使用最高值
+const (
+	autoPhotoQuality      = 75  // 照片的有损压缩质量
+	autoPhotoAlphaQuality = 90  // 带透明通道的照片，透明通道的有损压缩质量
+	autoLosslessQuality   = 100 // 截图和扁平图形
+)
+
+// autoEncodeOptions 分析图片内容，自动选择编码模式和质量
+// 照片使用有损压缩（带透明通道时透明通道也有损压缩），截图和文字使用近无损压缩，扁平图形（包括透明背景的图标）使用无损压缩
+// 未启用自动选择或无法解码图片时返回默认编码参数
+func autoEncodeOptions(srcPath string) *EncodeOptions {
+	opts := defaultEncodeOptions()
+	if !config.AutoEncodeMode {
+		return opts
+	}
+
+	img, _, err := imaging.Load(srcPath)
+	if err != nil {
+		log.Printf("无法分析图片内容，使用默认编码参数: %v", err)
+		return opts
+	}
+
+	analysis := imaging.Analyze(img)
+	switch analysis.Class {
+	case imaging.ClassGraphic:
+		opts.Quality = autoLosslessQuality
+		opts.Lossless = true
+		opts.NearLossless = 100
+		opts.Preset = "icon"
+	case imaging.ClassScreenshot:
+		opts.Quality = autoLosslessQuality
+		opts.Lossless = true
+		opts.NearLossless = 60
+		opts.Preset = "text"
+	default:
+		opts.Quality = autoPhotoQuality
+		opts.Lossless = false
+		opts.NearLossless = 100
+		opts.Preset = "photo"
+		if analysis.HasAlpha {
+			opts.AlphaQuality = autoPhotoAlphaQuality
+		}
+	}
+
+	log.Printf("图片内容分析: %s, 分类=%s, 颜色数=%d, 透明=%v, 平坦比例=%.2f, 边缘比例=%.2f, 质量=%d",
+		srcPath, analysis.Class, analysis.Colors, analysis.HasAlpha, analysis.FlatRatio, analysis.EdgeRatio, opts.Quality)
+	return opts
+}
+
+// parseEncodeOptions 从上传表单中读取编码参数，请求体不是表单时（原始图片、JSON）从URL查询参数读取
+// 未指定任何参数时返回 nil，由调用方决定使用的默认值
+func parseEncodeOptions(c *gin.Context) (*EncodeOptions, error) {
+	if isFormRequest(c) {
+		return parseEncodeParams(c.GetPostForm)
+	}
+	return parseEncodeParams(c.GetQuery)
+}
+
+// parseEncodeParams 通过 get 读取各个编码参数，get 返回参数值和是否指定了该参数
+func parseEncodeParams(get func(key string) (string, bool)) (*EncodeOptions, error) {
+	quality, hasQuality := get("quality")
+	lossless, hasLossless := get("lossless")
+	nearLossless, hasNearLossless := get("near_lossless")
+	preset, hasPreset := get("preset")
+	targetSSIM, hasTargetSSIM := get("target_ssim")
+	maxSize, hasMaxSize := get("max_size")
+	loop, hasLoop := get("loop")
+	frames, hasFrames := get("frames")
+	kmin, hasKmin := get("kmin")
+	kmax, hasKmax := get("kmax")
+
+	if !hasQuality && !hasLossless && !hasNearLossless && !hasPreset && !hasTargetSSIM && !hasMaxSize &&
+		!hasLoop && !hasFrames && !hasKmin && !hasKmax {
+		return nil, nil
+	}
+
+	opts := defaultEncodeOptions()
+
+	if hasQuality && quality != "" {
+		q, err := strconv.Atoi(quality)
+		if err != nil || q < 1 || q > 100 {
+			return nil, fmt.Errorf("quality 必须是 1-100 之间的整数")
+		}
+		opts.Quality = q
+	}
+
+	if hasLossless && lossless != "" {
+		b, err := strconv.ParseBool(lossless)
+		if err != nil {
+			return nil, fmt.Errorf("lossless 必须是布尔值")
+		}
+		opts.Lossless = b
+	}
+
+	if hasNearLossless && nearLossless != "" {
+		// 支持布尔值开关（使用推荐强度60）或直接指定 0-100 的强度
+		if b, err := strconv.ParseBool(nearLossless); err == nil {
+			if b {
+				opts.NearLossless = 60
+			} else {
+				opts.NearLossless = 100
+			}
+		} else if n, err := strconv.Atoi(nearLossless); err == nil && n >= 0 && n <= 100 {
+			opts.NearLossless = n
+		} else {
+			return nil, fmt.Errorf("near_lossless 必须是布尔值或 0-100 之间的整数")
+		}
+	}
+
+	if hasPreset {
+		preset = strings.ToLower(strings.TrimSpace(preset))
+		if preset != "" && !validPresets[preset] {
+			return nil, fmt.Errorf("preset 必须是 photo、picture、drawing、icon 或 text")
+		}
+		opts.Preset = preset
+	}
+
+	if hasTargetSSIM && targetSSIM != "" {
+		v, err := strconv.ParseFloat(targetSSIM, 64)
+		if err != nil || v <= 0 || v >= 1 {
+			return nil, fmt.Errorf("target_ssim 必须是 0-1 之间的小数，例如 0.98")
+		}
+		opts.TargetSSIM = v
+	}
+
+	if hasMaxSize && maxSize != "" {
+		v, err := parseByteSize(maxSize)
+		if err != nil || v <= 0 {
+			return nil, fmt.Errorf("max_size 必须是正数，可带 B/KB/MB 单位，例如 200KB")
+		}
+		opts.MaxSize = v
+	}
+
+	if hasLoop && loop != "" {
+		n, err := strconv.Atoi(loop)
+		if err != nil || n < 0 || n > 65535 {
+			return nil, fmt.Errorf("loop 必须是 0-65535 之间的整数，0 表示无限循环")
+		}
+		opts.Loop = n
+	}
+
+	if hasFrames && frames != "" {
+		frames = strings.ToLower(strings.TrimSpace(frames))
+		if !validFrameModes[frames] {
+			return nil, fmt.Errorf("frames 必须是 lossless、lossy 或 mixed")
+		}
+		opts.FrameMode = frames
+	}
+
+	if hasKmin && kmin != "" {
+		n, err := strconv.Atoi(kmin)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("kmin 必须是正整数")
+		}
+		opts.KeyframeMin = n
+	}
+
+	if hasKmax && kmax != "" {
+		n, err := strconv.Atoi(kmax)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("kmax 必须是正整数")
+		}
+		opts.KeyframeMax = n
+	}
+
+	if opts.KeyframeMin > 0 && (opts.KeyframeMax == 0 || opts.KeyframeMin >= opts.KeyframeMax) {
+		return nil, fmt.Errorf("指定 kmin 时必须同时指定更大的 kmax")
+	}
+
+	// 质量搜索只对有损压缩有意义
+	if opts.TargetSSIM > 0 || opts.MaxSize > 0 {
+		opts.Lossless = false
+		opts.NearLossless = 100
+	}
+
+	return opts, nil
+}
+
+// parseByteSize 解析带单位的文件大小，支持 B、KB、MB（按1024换算），无单位时按字节处理
+func parseByteSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	multiplier := 1.0
+	switch {
+	case strings.HasSuffix(s, "MB"):
+		multiplier, s = 1024*1024, strings.TrimSuffix(s, "MB")
+	case strings.HasSuffix(s, "KB"):
+		multiplier, s = 1024, strings.TrimSuffix(s, "KB")
+	case strings.HasSuffix(s, "B"):
+		s = strings.TrimSuffix(s, "B")
+	}
+
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return 0, err
+	}
+	return int64(v * multiplier), nil
+}
+
+// cwebpArgs 根据编码参数生成cwebp命令行参数
+func cwebpArgs(opts *EncodeOptions, srcPath, dstPath string) []string {
+	var args []string
+
+	// -preset 必须位于其他参数之前，后续参数会覆盖预设中的对应值
+	if opts.Preset != "" {
+		args = append(args, "-preset", opts.Preset)
+	}
+
+	switch {
+	case opts.NearLossless < 100:
+		// 近无损基于无损编码，对像素做轻微预处理以换取更小的体积
+		args = append(args, "-lossless", "-near_lossless", strconv.Itoa(opts.NearLossless), "-m", "6")
+	case opts.Lossless:
+		// -z 为无损预设，级别9压缩率最高
+		args = append(args, "-z", "9")
+	default:
+		args = append(args, "-q", strconv.Itoa(opts.Quality), "-m", "6")
+		if opts.AlphaQuality > 0 {
+			args = append(args, "-alpha_q", strconv.Itoa(opts.AlphaQuality))
+		}
+	}
+
+	if opts.Width > 0 {
+		args = append(args, "-resize", strconv.Itoa(opts.Width), "0")
+	}
+
+	return append(args, "-metadata", cwebpMetadataFlag(), "-mt", srcPath, "-o", dstPath)
+}
+
+// gif2webpArgs 根据编码参数生成gif2webp命令行参数
+// 注意: gif2webp需要参数和值分开传递
+func gif2webpArgs(opts *EncodeOptions, srcPath, dstPath string) []string {
+	var args []string
+
+	switch opts.FrameMode {
+	case "lossy":
+		args = append(args, "-lossy")
+	case "mixed":
+		args = append(args, "-mixed")
+	}
+	args = append(args, "-q", strconv.Itoa(opts.Quality), "-m", "6")
+
+	if opts.KeyframeMax > 0 {
+		if opts.KeyframeMin > 0 {
+			args = append(args, "-kmin", strconv.Itoa(opts.KeyframeMin))
+		}
+		args = append(args, "-kmax", strconv.Itoa(opts.KeyframeMax))
+	} else {
+		// -min_size 会关闭关键帧插入，只在未指定关键帧间隔时使用
+		args = append(args, "-min_size")
+	}
+
+	return append(args, "-metadata", cwebpMetadataFlag(), "-mt", srcPath, "-o", dstPath)
+}
+
+// qualityAttempt 质量搜索中的一次编码尝试
+type qualityAttempt struct {
+	quality int
+	size    int64
+	ssim    float64
+	path    string
+}
+
+// searchQuality 二分搜索cwebp质量，直到满足目标SSIM或体积上限
+// 同时指定两者时优先满足体积上限，在上限内选择达到目标SSIM的最低质量
+func searchQuality(srcPath, dstPath string, opts *EncodeOptions) (*ConvertResult, error) {
+	var reference image.Image
+	if opts.TargetSSIM > 0 {
+		if _, err := exec.LookPath("dwebp"); err != nil {
+			return nil, fmt.Errorf("未找到dwebp工具，无法计算SSIM")
+		}
+		img, _, err := imaging.Load(srcPath)
+		if err != nil {
+			return nil, err
+		}
+		reference = img
+	}
+
+	tmpDir, err := os.MkdirTemp(filepath.Dir(dstPath), ".quality-search-")
+	if err != nil {
+		return nil, fmt.Errorf("创建临时目录失败: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	attempts := make(map[int]*qualityAttempt)
+	encode := func(quality int) (*qualityAttempt, error) {
+		if a, ok := attempts[quality]; ok {
+			return a, nil
+		}
+
+		o := *opts
+		o.Quality = quality
+		out := filepath.Join(tmpDir, fmt.Sprintf("q%d.webp", quality))
+		if output, err := exec.Command("cwebp", cwebpArgs(&o, srcPath, out)...).CombinedOutput(); err != nil {
+			return nil, fmt.Errorf("cwebp转换失败: %v, 输出: %s", err, output)
+		}
+		info, err := os.Stat(out)
+		if err != nil {
+			return nil, fmt.Errorf("获取输出文件信息失败: %w", err)
+		}
+
+		a := &qualityAttempt{quality: quality, size: info.Size(), path: out}
+		if reference != nil {
+			if a.ssim, err = webpSSIM(reference, out, tmpDir); err != nil {
+				return nil, err
+			}
+		}
+		attempts[quality] = a
+		log.Printf("质量搜索: q=%d, 大小=%d字节, SSIM=%.4f", quality, a.size, a.ssim)
+		return a, nil
+	}
+
+	// search 在 [1,100] 内二分查找满足条件的边界质量
+	// lowest 为 true 时返回满足条件的最低质量，否则返回满足条件的最高质量
+	search := func(ok func(*qualityAttempt) bool, lowest bool) (*qualityAttempt, error) {
+		var best *qualityAttempt
+		lo, hi := 1, 100
+		for lo <= hi {
+			mid := (lo + hi) / 2
+			a, err := encode(mid)
+			if err != nil {
+				return nil, err
+			}
+			if ok(a) {
+				best = a
+				if lowest {
+					hi = mid - 1
+				} else {
+					lo = mid + 1
+				}
+			} else if lowest {
+				lo = mid + 1
+			} else {
+				hi = mid - 1
+			}
+		}
+		return best, nil
+	}
+
+	fitsSize := func(a *qualityAttempt) bool { return opts.MaxSize <= 0 || a.size <= opts.MaxSize }
+	meetsSSIM := func(a *qualityAttempt) bool { return opts.TargetSSIM <= 0 || a.ssim >= opts.TargetSSIM }
+
+	var chosen *qualityAttempt
+	targetMet := false
+
+	if opts.TargetSSIM > 0 {
+		best, err := search(meetsSSIM, true)
+		if err != nil {
+			return nil, err
+		}
+		if best != nil && fitsSize(best) {
+			chosen, targetMet = best, true
+		}
+	}
+
+	if chosen == nil && opts.MaxSize > 0 {
+		// 体积上限是硬性要求：选择上限内的最高质量
+		best, err := search(fitsSize, false)
+		if err != nil {
+			return nil, err
+		}
+		if best != nil {
+			chosen = best
+			targetMet = meetsSSIM(best)
+		}
+	}
+
+	if chosen == nil {
+		// 无法满足目标：有体积上限时使用最低质量，否则使用最高质量
+		fallbackQuality := 100
+		if opts.MaxSize > 0 {
+			fallbackQuality = 1
+		}
+		if chosen, err = encode(fallbackQuality); err != nil {
+			return nil, err
+		}
+		log.Printf("质量搜索未能达到目标，使用质量 %d", fallbackQuality)
+	}
+
+	if err := os.Rename(chosen.path, dstPath); err != nil {
+		return nil, fmt.Errorf("保存搜索结果失败: %w", err)
+	}
+
+	result := &ConvertResult{Encode: *opts, Searched: true, SSIM: chosen.ssim, TargetMet: targetMet}
+	result.Encode.Quality = chosen.quality
+	log.Printf("质量搜索完成: 选择质量 %d, 大小=%d字节, SSIM=%.4f, 达到目标=%v",
+		chosen.quality, chosen.size, chosen.ssim, targetMet)
+	return result, nil
+}
+
+// webpSSIM 使用dwebp解码WebP文件，并计算其与参考图片的SSIM
+func webpSSIM(reference image.Image, webpPath, tmpDir string) (float64, error) {
+	pngPath := filepath.Join(tmpDir, filepath.Base(webpPath)+".png")
+	defer os.Remove(pngPath)
+
+	if output, err := exec.Command("dwebp", webpPath, "-png", "-o", pngPath).CombinedOutput(); err != nil {
+		return 0, fmt.Errorf("dwebp解码失败: %v, 输出: %s", err, output)
+	}
+
+	decoded, _, err := imaging.Load(pngPath)
+	if err != nil {
+		return 0, err
+	}
+	return imaging.SSIM(reference, decoded)
+}
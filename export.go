@@ -0,0 +1,231 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 导出请求体的大小上限，只包含图片路径列表
+const maxExportRequestSize = 1024 * 1024
+
+// exportRequest 按图片列表导出的JSON参数
+type exportRequest struct {
+	Paths   []string `json:"paths"`   // 图片路径，形如 YY/MM/DD/timestamp.webp，也可以是 /img/ 开头的地址
+	Include string   `json:"include"` // 导出的文件: webp（默认）、original 或 both
+}
+
+// exportInclude 检查要导出的文件类型，为空时只导出WebP
+func exportInclude(include string) (string, error) {
+	switch include {
+	case "":
+		return "webp", nil
+	case "webp", "original", "both":
+		return include, nil
+	}
+	return "", errors.New("include 必须是 webp、original 或 both")
+}
+
+// collectDirImages 递归收集目录下的所有图片，按相对路径排序
+// 原图和WebP按文件名（不含扩展名）对应，只有其中一个的图片也会列出
+func collectDirImages(dir string) ([]*imageFiles, error) {
+	images := make(map[string]*imageFiles)
+	walk := func(root string, match func(ext string) bool, found func(files *imageFiles, path, rel string)) error {
+		err := filepath.WalkDir(filepath.Join(root, dir), func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			// 以 . 开头的是转换过程中的临时文件
+			if !d.Type().IsRegular() || strings.HasPrefix(d.Name(), ".") || !match(filepath.Ext(path)) {
+				return nil
+			}
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+			key := strings.TrimSuffix(rel, filepath.Ext(rel))
+			files, ok := images[key]
+			if !ok {
+				files = &imageFiles{}
+				images[key] = files
+			}
+			found(files, path, rel)
+			return nil
+		})
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	if err := walk(config.PicsDir, isSupportedImage, func(files *imageFiles, path, rel string) {
+		files.OriginalPath, files.RelPath = path, rel
+	}); err != nil {
+		return nil, err
+	}
+	if err := walk(config.WebpDir, func(ext string) bool { return strings.EqualFold(ext, ".webp") }, func(files *imageFiles, path, rel string) {
+		files.WebpPath = path
+		if files.RelPath == "" {
+			files.RelPath = rel
+		}
+	}); err != nil {
+		return nil, err
+	}
+
+	list := make([]*imageFiles, 0, len(images))
+	for _, files := range images {
+		list = append(list, files)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].RelPath < list[j].RelPath })
+	return list, nil
+}
+
+// exportEntries 返回一张图片需要写入压缩包的文件，每项为压缩包中的路径和磁盘上的路径
+// 只导出WebP时，没有WebP的图片（例如未栅格化的SVG）导出原图；两者都导出时分别放在 webp/ 和 originals/ 下
+func exportEntries(files *imageFiles, include string) [][2]string {
+	rel := filepath.ToSlash(files.RelPath)
+	webpRel := strings.TrimSuffix(rel, filepath.Ext(rel)) + ".webp"
+	var entries [][2]string
+	switch include {
+	case "webp":
+		if files.WebpPath != "" {
+			entries = append(entries, [2]string{webpRel, files.WebpPath})
+		} else if files.OriginalPath != "" {
+			entries = append(entries, [2]string{rel, files.OriginalPath})
+		}
+	case "original":
+		if files.OriginalPath != "" {
+			entries = append(entries, [2]string{rel, files.OriginalPath})
+		}
+	case "both":
+		if files.WebpPath != "" {
+			entries = append(entries, [2]string{"webp/" + webpRel, files.WebpPath})
+		}
+		if files.OriginalPath != "" {
+			entries = append(entries, [2]string{"originals/" + rel, files.OriginalPath})
+		}
+	}
+	return entries
+}
+
+// writeExportZip 以ZIP格式输出图片，边读取边写入响应，不使用临时文件
+// 图片本身已经压缩，ZIP中直接存储，不再压缩
+func writeExportZip(c *gin.Context, images []*imageFiles, include, name string) {
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", attachmentDisposition(name))
+	c.Header("X-Accel-Buffering", "no") // 禁止反向代理缓冲整个压缩包
+	c.Status(http.StatusOK)
+
+	zw := zip.NewWriter(c.Writer)
+	count := 0
+	for _, files := range images {
+		for _, entry := range exportEntries(files, include) {
+			if err := addZipFile(zw, entry[0], entry[1]); err != nil {
+				// 响应已经开始，只能中断输出；客户端会得到不完整的压缩包
+				log.Printf("导出图片 %s 失败: %v", entry[1], err)
+				return
+			}
+			count++
+		}
+	}
+	if err := zw.Close(); err != nil {
+		log.Printf("导出压缩包失败: %v", err)
+		return
+	}
+	log.Printf("导出 %d 个文件: %s", count, name)
+}
+
+// addZipFile 将一个文件写入压缩包
+func addZipFile(zw *zip.Writer, name, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	header.Method = zip.Store
+	w, err := zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// exportDirHandler 将目录下（包括子目录）的所有图片打包为ZIP下载
+// 例如 /api/export?dir=25/06 导出2025年6月的图片，include 指定导出 webp、original 或 both
+func exportDirHandler(c *gin.Context) {
+	include, err := exportInclude(c.Query("include"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 处理相对路径，防止目录遍历攻击
+	dir := strings.TrimPrefix(filepath.Clean("/"+c.Query("dir")), "/")
+	images, err := collectDirImages(dir)
+	if err != nil {
+		log.Printf("读取导出目录失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法读取目录"})
+		return
+	}
+	if len(images) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "目录不存在或没有图片"})
+		return
+	}
+
+	name := "images.zip"
+	if dir != "" {
+		name = "images-" + strings.ReplaceAll(filepath.ToSlash(dir), "/", "-") + ".zip"
+	}
+	writeExportZip(c, images, include, name)
+}
+
+// exportSelectionHandler 将请求中列出的图片打包为ZIP下载，请求体为 {"paths": [...], "include": "both"}
+func exportSelectionHandler(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxExportRequestSize)
+	var req exportRequest
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.Paths) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+		return
+	}
+	include, err := exportInclude(req.Include)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 在开始输出前确认所有图片存在，重复的路径只导出一次
+	var images []*imageFiles
+	seen := make(map[string]bool)
+	for _, p := range req.Paths {
+		files, ok := resolveImage(strings.TrimPrefix(p, "/img/"))
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "图片不存在: " + p})
+			return
+		}
+		if !seen[files.RelPath] {
+			seen[files.RelPath] = true
+			images = append(images, files)
+		}
+	}
+	writeExportZip(c, images, include, "images.zip")
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/png"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/suixinio/webp-img/imaging"
+)
+
+// imageContentTypes 支持转换的图片扩展名及其内容类型
+var imageContentTypes = map[string]string{
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".png":  "image/png",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+	".heic": "image/heic",
+	".heif": "image/heif",
+	".tif":  "image/tiff",
+	".tiff": "image/tiff",
+	".bmp":  "image/bmp",
+	".jxl":  "image/jxl",
+	".svg":  "image/svg+xml",
+}
+
+// externalDecoders cwebp无法读取、需要借助外部工具解码为PNG的格式，按顺序使用第一个可用的工具
+// 所有工具的调用方式都是 工具 输入文件 输出文件
+var externalDecoders = map[string][]string{
+	".heic": {"heif-dec", "heif-convert", "magick"},
+	".heif": {"heif-dec", "heif-convert", "magick"},
+	".jxl":  {"djxl", "magick"},
+}
+
+// isSupportedImage 判断扩展名是否为支持转换的图片格式
+func isSupportedImage(ext string) bool {
+	_, ok := imageContentTypes[strings.ToLower(ext)]
+	return ok
+}
+
+// contentTypeByExt 根据扩展名返回图片的内容类型，未知扩展名按JPEG处理
+func contentTypeByExt(ext string) string {
+	if contentType, ok := imageContentTypes[strings.ToLower(ext)]; ok {
+		return contentType
+	}
+	return "image/jpeg"
+}
+
+// extByContentType 根据上传时的内容类型推断扩展名，无法识别时返回空
+func extByContentType(contentType string) string {
+	switch strings.ToLower(contentType) {
+	case "image/jpeg", "image/jpg", "image/pjpeg":
+		return ".jpg"
+	case "image/png":
+		return ".png"
+	case "image/gif":
+		return ".gif"
+	case "image/webp":
+		return ".webp"
+	case "image/heic", "image/heic-sequence":
+		return ".heic"
+	case "image/heif", "image/heif-sequence":
+		return ".heif"
+	case "image/tiff":
+		return ".tiff"
+	case "image/bmp", "image/x-bmp", "image/x-ms-bmp":
+		return ".bmp"
+	case "image/jxl":
+		return ".jxl"
+	case "image/svg+xml":
+		return ".svg"
+	}
+	return ""
+}
+
+// needsDecoding 判断是否需要先解码为PNG才能交给cwebp编码
+// TIFF虽然部分cwebp版本可以读取，但统一在Go中解码以便按TIFF方向标签旋转
+func needsDecoding(ext string) bool {
+	switch strings.ToLower(ext) {
+	case ".heic", ".heif", ".jxl", ".tif", ".tiff", ".bmp":
+		return true
+	}
+	return false
+}
+
+// decodeToPNG 将cwebp无法直接读取的图片解码为临时PNG文件，返回临时文件路径
+// HEIC/HEIF和JPEG XL使用外部工具解码，BMP和TIFF在Go中解码
+func decodeToPNG(srcPath, tmpDir string) (string, error) {
+	ext := strings.ToLower(filepath.Ext(srcPath))
+	tools, ok := externalDecoders[ext]
+	if !ok {
+		return decodeWithGo(srcPath, tmpDir)
+	}
+
+	f, err := os.CreateTemp(tmpDir, ".decoded-*.png")
+	if err != nil {
+		return "", fmt.Errorf("创建临时文件失败: %w", err)
+	}
+	f.Close()
+	dstPath := f.Name()
+
+	if err := decodeWithTools(tools, srcPath, dstPath); err != nil {
+		os.Remove(dstPath)
+		return "", err
+	}
+	return dstPath, nil
+}
+
+// decodeWithTools 使用第一个可用的外部工具解码图片
+func decodeWithTools(tools []string, srcPath, dstPath string) error {
+	for _, tool := range tools {
+		if _, err := exec.LookPath(tool); err != nil {
+			continue
+		}
+
+		output, err := exec.Command(tool, srcPath, dstPath).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("%s解码失败: %v, 输出: %s", tool, err, output)
+		}
+		// 部分工具在文件包含多张图片时会给输出文件名加序号，此时视为解码失败
+		if info, err := os.Stat(dstPath); err != nil || info.Size() == 0 {
+			return fmt.Errorf("%s没有生成解码结果", tool)
+		}
+		log.Printf("使用%s解码图片: %s", tool, srcPath)
+		return nil
+	}
+	return fmt.Errorf("未找到可用的解码工具: %s", strings.Join(tools, ", "))
+}
+
+// decodeWithGo 在Go中解码BMP/TIFF图片并写入临时PNG文件，TIFF按方向标签旋转像素
+func decodeWithGo(srcPath, tmpDir string) (string, error) {
+	img, format, err := imaging.Load(srcPath)
+	if err != nil {
+		return "", err
+	}
+
+	// TIFF文件本身就是EXIF使用的TIFF结构，可以直接读取方向标签
+	if format == "tiff" && config.AutoOrient {
+		if data, err := os.ReadFile(srcPath); err == nil {
+			if exif, err := imaging.ParseEXIF(data); err == nil && exif.Orientation > 1 {
+				img = imaging.Orient(img, exif.Orientation)
+				log.Printf("按TIFF方向 %d 旋转图片: %s", exif.Orientation, srcPath)
+			}
+		}
+	}
+
+	return writeTempPNG(img, tmpDir, ".decoded-*.png")
+}
+
+// writeTempPNG 将图片写入 dir 中以 pattern 命名的临时PNG文件并返回路径，由调用方负责删除
+func writeTempPNG(img image.Image, dir, pattern string) (string, error) {
+	f, err := os.CreateTemp(dir, pattern)
+	if err != nil {
+		return "", fmt.Errorf("创建临时文件失败: %w", err)
+	}
+	// 临时文件只用于编码，使用最快的压缩级别
+	encoder := png.Encoder{CompressionLevel: png.BestSpeed}
+	err = encoder.Encode(f, img)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("写入临时PNG文件失败: %w", err)
+	}
+	return f.Name(), nil
+}
@@ -8,6 +8,7 @@ require (
 	github.com/gin-gonic/gin v1.9.1
 	github.com/golang-jwt/jwt/v5 v5.2.2 // Add JWT library
 	golang.org/x/crypto v0.38.0 // Add crypto library for password hashing
+	golang.org/x/image v0.24.0 // Add image library for BMP/TIFF decoding
 )
 
 require (
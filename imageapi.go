@@ -0,0 +1,335 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/suixinio/webp-img/imaging"
+)
+
+// imageFiles 一张图片在磁盘上对应的文件
+type imageFiles struct {
+	RelPath      string // 原始图片相对路径，形如 YY/MM/DD/timestamp.ext；原图不存在时为WebP的相对路径
+	OriginalPath string // 原始图片完整路径，不存在时为空
+	WebpPath     string // WebP图片完整路径，不存在时为空
+}
+
+// imageFile 图片元数据接口中列出的单个文件
+type imageFile struct {
+	Kind   string `json:"kind"`           // 文件类型: original/webp/video/variant
+	Name   string `json:"name,omitempty"` // 衍生版本名称，例如 w128
+	Format string `json:"format"`         // 实际格式
+	Size   int64  `json:"size"`           // 文件大小（字节）
+	URL    string `json:"url"`            // 访问地址
+}
+
+// resolveImage 根据请求路径查找原始图片和WebP图片
+// 请求路径可以使用原始扩展名或.webp扩展名，例如画廊中的 /img/YY/MM/DD/timestamp.webp
+func resolveImage(requestPath string) (*imageFiles, bool) {
+	rel := strings.TrimPrefix(filepath.Clean("/"+requestPath), "/")
+	if rel == "" {
+		return nil, false
+	}
+
+	ext := filepath.Ext(rel)
+	dir := filepath.Dir(rel)
+	base := strings.TrimSuffix(filepath.Base(rel), ext)
+	files := &imageFiles{}
+
+	// 优先使用请求中的原始扩展名，否则在同一目录下按文件名查找
+	if candidate := filepath.Join(config.PicsDir, rel); !strings.EqualFold(ext, ".webp") && isRegularFile(candidate) {
+		files.OriginalPath = candidate
+		files.RelPath = rel
+	} else if entries, err := os.ReadDir(filepath.Join(config.PicsDir, dir)); err == nil {
+		for _, entry := range entries {
+			name := entry.Name()
+			if !entry.IsDir() && strings.TrimSuffix(name, filepath.Ext(name)) == base {
+				files.OriginalPath = filepath.Join(config.PicsDir, dir, name)
+				files.RelPath = filepath.Join(dir, name)
+				break
+			}
+		}
+	}
+
+	if webpPath := filepath.Join(config.WebpDir, dir, base+".webp"); isRegularFile(webpPath) {
+		files.WebpPath = webpPath
+	}
+
+	if files.OriginalPath == "" && files.WebpPath == "" {
+		return nil, false
+	}
+	if files.RelPath == "" {
+		files.RelPath = filepath.Join(dir, base+".webp")
+	}
+	return files, true
+}
+
+// isRegularFile 检查路径是否为存在的普通文件
+func isRegularFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// splitImageAction 将 /YY/MM/DD/name.ext/meta 形式的路径拆分为图片路径和操作名称
+func splitImageAction(param string) (imagePath, action string) {
+	param = strings.TrimSuffix(param, "/")
+	i := strings.LastIndex(param, "/")
+	if i <= 0 {
+		return "", ""
+	}
+	return param[:i], param[i+1:]
+}
+
+// imageAPIGetHandler 处理 GET /api/images/*path 下的子资源请求
+func imageAPIGetHandler(c *gin.Context) {
+	if imagePath, version, original, ok := splitVersionPath(c.Param("path")); ok {
+		imageVersionFileHandler(c, imagePath, version, original)
+		return
+	}
+	// 图片路径加 ?v=N 与 /versions/N 相同
+	if version := c.Query("v"); version != "" {
+		imageVersionFileHandler(c, strings.TrimSuffix(c.Param("path"), "/"), version, false)
+		return
+	}
+	imagePath, action := splitImageAction(c.Param("path"))
+	switch action {
+	case "meta":
+		imageMetaHandler(c, imagePath)
+	case "poster":
+		imagePosterHandler(c, imagePath)
+	case "versions":
+		imageVersionsHandler(c, imagePath)
+	default:
+		c.JSON(http.StatusNotFound, gin.H{"error": "不支持的操作"})
+	}
+}
+
+// imageAPIPostHandler 处理 POST /api/images/*path 下的操作请求
+func imageAPIPostHandler(c *gin.Context) {
+	imagePath, action := splitImageAction(c.Param("path"))
+	switch action {
+	case "edit":
+		imageEditHandler(c, imagePath)
+	case "rollback":
+		imageRollbackHandler(c, imagePath)
+	default:
+		c.JSON(http.StatusNotFound, gin.H{"error": "不支持的操作"})
+	}
+}
+
+// imageMetaHandler 返回图片的尺寸、格式、动画、颜色空间、文件大小和EXIF信息
+func imageMetaHandler(c *gin.Context, imagePath string) {
+	files, ok := resolveImage(imagePath)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "图片不存在"})
+		return
+	}
+
+	// 优先从原图读取信息，原图不存在时退回到WebP
+	source := files.OriginalPath
+	if source == "" {
+		source = files.WebpPath
+	}
+	// 只读取需要的部分，避免大图整个读入内存
+	info, err := imaging.InspectFile(source)
+	if err != nil {
+		log.Printf("读取图片失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "读取图片失败"})
+		return
+	}
+	if info.Format == "" {
+		// HEIC、JPEG XL等格式无法读取尺寸，只返回格式
+		info.Format = formatOf(source)
+	}
+	embedded, err := imaging.ReadEmbeddedFile(source)
+	if err != nil {
+		log.Printf("读取图片元数据失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "读取图片失败"})
+		return
+	}
+
+	colorSpace := "sRGB"
+	switch info.ColorModel {
+	case "Gray", "CMYK":
+		colorSpace = info.ColorModel
+	}
+	if len(embedded.ICC) > 0 {
+		if profile, err := imaging.ParseICC(embedded.ICC); err == nil && profile.Description != "" {
+			colorSpace = profile.Description
+		}
+	}
+
+	var exif *imaging.EXIF
+	if len(embedded.EXIF) > 0 {
+		if exif, err = imaging.ParseEXIF(embedded.EXIF); err != nil {
+			log.Printf("解析EXIF失败: %v", err)
+		}
+	}
+
+	// 自动旋转时WebP的显示尺寸与原图方向一致，方向5-8需要交换宽高
+	displayWidth, displayHeight := info.Width, info.Height
+	if config.AutoOrient && embedded.Orientation() >= 5 {
+		displayWidth, displayHeight = info.Height, info.Width
+	}
+
+	response := gin.H{
+		"path":                  filepath.ToSlash(files.RelPath),
+		"url":                   "/img/" + filepath.ToSlash(files.RelPath),
+		"original_name":         filepath.Base(files.RelPath),
+		"format":                info.Format,
+		"width":                 info.Width,
+		"height":                info.Height,
+		"display_width":         displayWidth,
+		"display_height":        displayHeight,
+		"frame_count":           info.Frames,
+		"animated":              info.Frames > 1,
+		"animation_duration_ms": info.DurationMs,
+		"color_space":           colorSpace,
+		"exif":                  exif,
+	}
+
+	if meta, err := metaStore.Load(files.RelPath); err == nil {
+		if meta.OriginalName != "" {
+			response["original_name"] = meta.OriginalName
+		}
+		response["uploaded_at"] = meta.UploadedAt
+		response["edited_at"] = meta.EditedAt
+		response["color_profile"] = meta.ColorProfile
+		response["color_handling"] = meta.ColorHandling
+		response["blurhash"] = meta.BlurHash
+		response["lqip"] = meta.LQIP
+		response["dominant_color"] = meta.DominantColor
+		response["sha256"] = meta.SHA256
+	}
+
+	imageFileList := listImageFiles(files)
+	var originalSize, webpSize int64
+	for _, f := range imageFileList {
+		switch f.Kind {
+		case "original":
+			originalSize = f.Size
+		case "webp":
+			webpSize = f.Size
+		}
+	}
+	response["files"] = imageFileList
+	response["original_size"] = originalSize
+	response["webp_size"] = webpSize
+	if originalSize > 0 && webpSize > 0 {
+		response["compression_ratio"] = 100 - (float64(webpSize) / float64(originalSize) * 100)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// listImageFiles 列出一张图片的原图和所有已生成的版本
+func listImageFiles(files *imageFiles) []imageFile {
+	var list []imageFile
+
+	if files.OriginalPath != "" {
+		if info, err := os.Stat(files.OriginalPath); err == nil {
+			list = append(list, imageFile{
+				Kind:   "original",
+				Format: formatOf(files.OriginalPath),
+				Size:   info.Size(),
+				URL:    "/uploads/" + relToUploadDir(files.OriginalPath),
+			})
+		}
+	}
+
+	if files.WebpPath != "" {
+		if info, err := os.Stat(files.WebpPath); err == nil {
+			rel, _ := filepath.Rel(config.WebpDir, files.WebpPath)
+			list = append(list, imageFile{
+				Kind:   "webp",
+				Format: formatOf(files.WebpPath),
+				Size:   info.Size(),
+				URL:    "/download/webp/" + filepath.ToSlash(rel),
+			})
+		}
+	}
+
+	webpPath := files.WebpPath
+	if webpPath == "" {
+		webpPath = filepath.Join(config.WebpDir, strings.TrimSuffix(files.RelPath, filepath.Ext(files.RelPath))+".webp")
+	}
+
+	urls := videoURLs(webpPath)
+	for _, video := range listVideos(webpPath) {
+		if info, err := os.Stat(video[1]); err == nil {
+			list = append(list, imageFile{
+				Kind:   "video",
+				Format: video[0],
+				Size:   info.Size(),
+				URL:    urls[video[0]],
+			})
+		}
+	}
+
+	// 衍生版本按名称排序，保证输出稳定
+	variants := listVariants(webpPath)
+	names := make([]string, 0, len(variants))
+	for name := range variants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if info, err := os.Stat(variants[name]); err == nil {
+			list = append(list, imageFile{
+				Kind:   "variant",
+				Name:   name,
+				Format: formatOf(variants[name]),
+				Size:   info.Size(),
+				URL:    variantURL(files.RelPath, name, variants[name]),
+			})
+		}
+	}
+
+	return list
+}
+
+// variantURL 返回衍生版本的访问地址，宽度版本、水印版本和第1帧海报可以通过 /img/ 的参数访问
+func variantURL(relPath, name, path string) string {
+	if name == posterName(1) {
+		return "/img/" + filepath.ToSlash(relPath) + "?animated=false"
+	}
+	if preset, found := strings.CutPrefix(name, "wm-"); found {
+		url := "/img/" + filepath.ToSlash(relPath) + "?wm="
+		if i := strings.IndexByte(preset, '.'); i >= 0 {
+			if width := parseWidthName(preset[i+1:]); width > 0 {
+				return url + preset[:i] + "&w=" + strconv.Itoa(width)
+			}
+		}
+		return url + preset
+	}
+	if width := parseWidthName(name); width > 0 {
+		return "/img/" + filepath.ToSlash(relPath) + "?w=" + strconv.Itoa(width)
+	}
+	if rel := relToUploadDir(path); rel != "" {
+		return "/uploads/" + rel
+	}
+	return ""
+}
+
+// formatOf 返回文件的实际格式，无法通过文件头识别时使用扩展名
+func formatOf(path string) string {
+	if format := sniffImageType(path); format != "" {
+		return format
+	}
+	return strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+}
+
+// relToUploadDir 计算文件相对于上传根目录的路径，用于 /uploads 静态访问地址
+func relToUploadDir(path string) string {
+	rel, err := filepath.Rel(config.UploadDir, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return ""
+	}
+	return filepath.ToSlash(rel)
+}
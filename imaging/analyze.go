@@ -0,0 +1,116 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+)
+
+// 图片内容分类
+const (
+	ClassPhoto      = "photo"      // 照片：颜色丰富、过渡平滑
+	ClassScreenshot = "screenshot" // 截图/文字：大面积纯色背景和锐利边缘
+	ClassGraphic    = "graphic"    // 扁平图形：颜色很少，如图标、图表、Logo
+)
+
+// 分析时最多采样的边长，超过时按步长抽样
+const analyzeSampleSize = 256
+
+// 扁平图形允许的最大颜色数
+const graphicMaxColors = 256
+
+// Analysis 图片内容分析结果
+type Analysis struct {
+	Class     string  // 内容分类
+	Colors    int     // 采样得到的不同颜色数，超过上限时为上限值
+	HasAlpha  bool    // 是否存在半透明或透明像素
+	FlatRatio float64 // 与相邻像素几乎无差别的采样点比例
+	EdgeRatio float64 // 与相邻像素差异很大的采样点比例
+}
+
+// Analyze 根据颜色数量、透明度和边缘统计对图片内容进行分类
+// 大面积平坦的透明图片归为扁平图形；颜色丰富的透明图片仍归为照片，由调用方对透明通道使用有损压缩
+func Analyze(img image.Image) Analysis {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w == 0 || h == 0 {
+		return Analysis{Class: ClassPhoto}
+	}
+
+	step := 1
+	if max(w, h) > analyzeSampleSize {
+		step = max(w, h) / analyzeSampleSize
+	}
+
+	colors := make(map[uint32]struct{}, graphicMaxColors+1)
+	var samples, flat, edges int
+	var hasAlpha bool
+
+	for y := b.Min.Y; y < b.Max.Y; y += step {
+		for x := b.Min.X; x < b.Max.X; x += step {
+			r, g, bl, a := img.At(x, y).RGBA()
+			if a < 0xffff {
+				hasAlpha = true
+			}
+			if len(colors) <= graphicMaxColors*16 {
+				colors[(r>>8)<<24|(g>>8)<<16|(bl>>8)<<8|(a>>8)] = struct{}{}
+			}
+
+			// 与右侧和下方的真实相邻像素比较亮度，而不是与下一个采样点比较
+			if x+1 >= b.Max.X || y+1 >= b.Max.Y {
+				continue
+			}
+			lum := luminance(r, g, bl)
+			grad := absDiff(lum, luminanceOf(img.At(x+1, y))) +
+				absDiff(lum, luminanceOf(img.At(x, y+1)))
+
+			samples++
+			switch {
+			case grad <= 2:
+				flat++
+			case grad >= 64:
+				edges++
+			}
+		}
+	}
+
+	result := Analysis{
+		Colors:   len(colors),
+		HasAlpha: hasAlpha,
+	}
+	if samples > 0 {
+		result.FlatRatio = float64(flat) / float64(samples)
+		result.EdgeRatio = float64(edges) / float64(samples)
+	}
+
+	switch {
+	case result.Colors <= graphicMaxColors:
+		result.Class = ClassGraphic
+	case result.HasAlpha && result.FlatRatio >= 0.5:
+		// 透明背景的图标、Logo边缘抗锯齿会产生大量半透明颜色，颜色数超过上限，仍按扁平图形无损压缩
+		result.Class = ClassGraphic
+	case result.FlatRatio >= 0.5 && result.EdgeRatio >= 0.01:
+		result.Class = ClassScreenshot
+	default:
+		result.Class = ClassPhoto
+	}
+	return result
+}
+
+// luminance 计算 0-255 范围的近似亮度
+func luminance(r, g, b uint32) int {
+	return int((299*(r>>8) + 587*(g>>8) + 114*(b>>8)) / 1000)
+}
+
+// luminanceOf 计算颜色的近似亮度
+func luminanceOf(c color.Color) int {
+	r, g, b, _ := c.RGBA()
+	return luminance(r, g, b)
+}
+
+// absDiff 返回两个整数差的绝对值
+func absDiff(a, b int) int {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
@@ -0,0 +1,47 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestAnalyzeAlpha(t *testing.T) {
+	// 透明背景上的实心圆，边缘抗锯齿产生大量不同透明度的颜色
+	logo := image.NewNRGBA(image.Rect(0, 0, 200, 200))
+	for y := 0; y < 200; y++ {
+		for x := 0; x < 200; x++ {
+			d := (x-100)*(x-100) + (y-100)*(y-100)
+			if d < 80*80 {
+				a := min(255, (80*80-d)/2)
+				logo.SetNRGBA(x, y, color.NRGBA{R: uint8(x), G: 40, B: 200, A: uint8(a)})
+			}
+		}
+	}
+
+	// 颜色丰富的半透明照片
+	photo := image.NewNRGBA(image.Rect(0, 0, 200, 200))
+	seed := uint32(1)
+	for i := range photo.Pix {
+		seed = seed*1664525 + 1013904223
+		photo.Pix[i] = uint8(seed >> 24)
+	}
+
+	tests := []struct {
+		name  string
+		img   image.Image
+		class string
+	}{
+		{"transparent logo", logo, ClassGraphic},
+		{"photo with alpha", photo, ClassPhoto},
+	}
+	for _, tt := range tests {
+		a := Analyze(tt.img)
+		if !a.HasAlpha {
+			t.Errorf("%s: 未检测到透明通道", tt.name)
+		}
+		if a.Class != tt.class {
+			t.Errorf("%s: 分类为 %s，应为 %s (颜色数=%d, 平坦比例=%.2f)", tt.name, a.Class, tt.class, a.Colors, a.FlatRatio)
+		}
+	}
+}
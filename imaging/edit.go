@@ -0,0 +1,43 @@
+package imaging
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+)
+
+// 保存编辑结果时JPEG使用的质量，尽量减少再次压缩的损失
+const editJPEGQuality = 95
+
+// Crop 裁剪图片中的指定区域，区域坐标以图片左上角为原点
+func Crop(img image.Image, r image.Rectangle) (*image.NRGBA, error) {
+	b := img.Bounds()
+	r = r.Add(b.Min)
+	if r.Empty() || !r.In(b) {
+		return nil, fmt.Errorf("裁剪区域超出图片范围")
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, r.Min, draw.Src)
+	return dst, nil
+}
+
+// Encode 按格式编码图片，支持 jpeg/png/bmp/tiff
+func Encode(w io.Writer, img image.Image, format string) error {
+	switch format {
+	case "jpeg":
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: editJPEGQuality})
+	case "png":
+		return png.Encode(w, img)
+	case "bmp":
+		return bmp.Encode(w, img)
+	case "tiff":
+		return tiff.Encode(w, img, &tiff.Options{Compression: tiff.Deflate})
+	}
+	return fmt.Errorf("不支持编码为%s格式", format)
+}
@@ -0,0 +1,243 @@
+package imaging
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// EXIF 标签
+const (
+	tagMake             = 0x010f
+	tagModel            = 0x0110
+	tagOrientation      = 0x0112
+	tagDateTime         = 0x0132
+	tagExifIFD          = 0x8769
+	tagGPSIFD           = 0x8825
+	tagExposureTime     = 0x829a
+	tagFNumber          = 0x829d
+	tagISO              = 0x8827
+	tagDateTimeOriginal = 0x9003
+	tagFocalLength      = 0x920a
+	tagLensMake         = 0xa433
+	tagLensModel        = 0xa434
+)
+
+// EXIF 解析后的常用EXIF字段
+type EXIF struct {
+	Make         string  `json:"make,omitempty"`          // 相机厂商
+	Model        string  `json:"model,omitempty"`         // 相机型号
+	LensMake     string  `json:"lens_make,omitempty"`     // 镜头厂商
+	LensModel    string  `json:"lens_model,omitempty"`    // 镜头型号
+	DateTime     string  `json:"date_time,omitempty"`     // 拍摄时间，优先使用 DateTimeOriginal
+	Orientation  int     `json:"orientation,omitempty"`   // 方向 (1-8)
+	ExposureTime string  `json:"exposure_time,omitempty"` // 曝光时间，例如 1/125
+	FNumber      float64 `json:"f_number,omitempty"`      // 光圈值
+	ISO          int     `json:"iso,omitempty"`           // 感光度
+	FocalLength  float64 `json:"focal_length,omitempty"`  // 焦距（毫米）
+	HasGPS       bool    `json:"has_gps"`                 // 是否包含GPS位置信息
+}
+
+// tiffReader 按TIFF头部声明的字节序读取EXIF数据
+type tiffReader struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+// newTIFFReader 校验TIFF头部并返回读取器和第一个IFD的偏移
+func newTIFFReader(data []byte) (*tiffReader, uint32, error) {
+	if len(data) < 8 {
+		return nil, 0, errors.New("EXIF数据过短")
+	}
+
+	var order binary.ByteOrder
+	switch string(data[:4]) {
+	case "II*\x00":
+		order = binary.LittleEndian
+	case "MM\x00*":
+		order = binary.BigEndian
+	default:
+		return nil, 0, errors.New("无效的TIFF头部")
+	}
+	return &tiffReader{data: data, order: order}, order.Uint32(data[4:8]), nil
+}
+
+// ifdEntry IFD中的一个条目
+type ifdEntry struct {
+	tag    uint16
+	typ    uint16
+	count  uint32
+	offset int // 条目在数据中的起始位置
+}
+
+// entries 读取指定偏移处IFD的所有条目
+func (r *tiffReader) entries(offset uint32) ([]ifdEntry, error) {
+	if int(offset)+2 > len(r.data) {
+		return nil, fmt.Errorf("IFD偏移越界: %d", offset)
+	}
+	n := int(r.order.Uint16(r.data[offset:]))
+	start := int(offset) + 2
+	if start+n*12 > len(r.data) {
+		return nil, errors.New("IFD条目越界")
+	}
+
+	entries := make([]ifdEntry, 0, n)
+	for i := 0; i < n; i++ {
+		pos := start + i*12
+		entries = append(entries, ifdEntry{
+			tag:    r.order.Uint16(r.data[pos:]),
+			typ:    r.order.Uint16(r.data[pos+2:]),
+			count:  r.order.Uint32(r.data[pos+4:]),
+			offset: pos,
+		})
+	}
+	return entries, nil
+}
+
+// valueBytes 返回条目值的原始字节，不超过4字节时值直接存放在条目中
+func (r *tiffReader) valueBytes(e ifdEntry) []byte {
+	sizes := map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 7: 1, 9: 4, 10: 8}
+	size, ok := sizes[e.typ]
+	if !ok || e.count > uint32(len(r.data)) {
+		return nil
+	}
+	total := size * int(e.count)
+	if total <= 4 {
+		return r.data[e.offset+8 : e.offset+8+total]
+	}
+	off := int(r.order.Uint32(r.data[e.offset+8:]))
+	if off < 0 || off+total > len(r.data) {
+		return nil
+	}
+	return r.data[off : off+total]
+}
+
+func (r *tiffReader) str(e ifdEntry) string {
+	return strings.TrimSpace(strings.TrimRight(string(r.valueBytes(e)), "\x00"))
+}
+
+func (r *tiffReader) uint(e ifdEntry) uint32 {
+	b := r.valueBytes(e)
+	switch {
+	case e.typ == 3 && len(b) >= 2:
+		return uint32(r.order.Uint16(b))
+	case e.typ == 4 && len(b) >= 4:
+		return r.order.Uint32(b)
+	}
+	return 0
+}
+
+// rational 读取RATIONAL值，返回分子和分母
+func (r *tiffReader) rational(e ifdEntry) (uint32, uint32) {
+	b := r.valueBytes(e)
+	if e.typ != 5 || len(b) < 8 {
+		return 0, 0
+	}
+	return r.order.Uint32(b), r.order.Uint32(b[4:])
+}
+
+// ParseEXIF 解析TIFF格式的EXIF数据（不包含 "Exif\0\0" 前缀）
+func ParseEXIF(data []byte) (*EXIF, error) {
+	r, ifd0, err := newTIFFReader(data)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := r.entries(ifd0)
+	if err != nil {
+		return nil, err
+	}
+
+	exif := &EXIF{}
+	var exifIFD uint32
+	for _, e := range entries {
+		switch e.tag {
+		case tagMake:
+			exif.Make = r.str(e)
+		case tagModel:
+			exif.Model = r.str(e)
+		case tagOrientation:
+			exif.Orientation = int(r.uint(e))
+		case tagDateTime:
+			exif.DateTime = r.str(e)
+		case tagExifIFD:
+			exifIFD = r.uint(e)
+		case tagGPSIFD:
+			exif.HasGPS = true
+		}
+	}
+
+	if exifIFD != 0 {
+		// EXIF子IFD损坏时保留IFD0中已解析的字段
+		if sub, err := r.entries(exifIFD); err == nil {
+			for _, e := range sub {
+				switch e.tag {
+				case tagDateTimeOriginal:
+					if v := r.str(e); v != "" {
+						exif.DateTime = v
+					}
+				case tagExposureTime:
+					if num, den := r.rational(e); den != 0 {
+						if num == 1 || num == 0 {
+							exif.ExposureTime = fmt.Sprintf("%d/%d", num, den)
+						} else {
+							exif.ExposureTime = fmt.Sprintf("%g", float64(num)/float64(den))
+						}
+					}
+				case tagFNumber:
+					if num, den := r.rational(e); den != 0 {
+						exif.FNumber = math.Round(float64(num)/float64(den)*10) / 10
+					}
+				case tagISO:
+					exif.ISO = int(r.uint(e))
+				case tagFocalLength:
+					if num, den := r.rational(e); den != 0 {
+						exif.FocalLength = math.Round(float64(num)/float64(den)*10) / 10
+					}
+				case tagLensMake:
+					exif.LensMake = r.str(e)
+				case tagLensModel:
+					exif.LensModel = r.str(e)
+				}
+			}
+		}
+	}
+
+	return exif, nil
+}
+
+// ResetOrientation 返回将方向标签改为1（正常）后的EXIF数据副本
+// 用于像素已经按方向旋转后保留其余EXIF信息，避免查看器再次旋转
+func ResetOrientation(data []byte) []byte {
+	out := append([]byte(nil), data...)
+	r, ifd0, err := newTIFFReader(out)
+	if err != nil {
+		return out
+	}
+	entries, err := r.entries(ifd0)
+	if err != nil {
+		return out
+	}
+	for _, e := range entries {
+		if e.tag == tagOrientation && e.typ == 3 {
+			r.order.PutUint16(out[e.offset+8:], 1)
+		}
+	}
+	return out
+}
+
+// OrientationEXIF 生成只包含方向标签的最小EXIF数据（大端TIFF格式）
+func OrientationEXIF(orientation int) []byte {
+	data := make([]byte, 26)
+	copy(data, "MM\x00*")
+	binary.BigEndian.PutUint32(data[4:], 8) // IFD0 偏移
+	binary.BigEndian.PutUint16(data[8:], 1) // 条目数
+	binary.BigEndian.PutUint16(data[10:], tagOrientation)
+	binary.BigEndian.PutUint16(data[12:], 3) // SHORT
+	binary.BigEndian.PutUint32(data[14:], 1) // 数量
+	binary.BigEndian.PutUint16(data[18:], uint16(orientation))
+	binary.BigEndian.PutUint32(data[22:], 0) // 没有下一个IFD
+	return data
+}
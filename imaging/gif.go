@@ -0,0 +1,58 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+)
+
+// ErrFrameOutOfRange 请求的帧序号超出动画的帧数
+var ErrFrameOutOfRange = errors.New("帧序号超出范围")
+
+// GIFFrame 解码GIF并合成第index帧（从0开始）显示时的完整画面
+// 按各帧的处置方式依次叠加，结果与浏览器播放到该帧时看到的画面一致
+func GIFFrame(data []byte, index int) (image.Image, error) {
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("解码GIF失败: %w", err)
+	}
+	if index < 0 || index >= len(g.Image) {
+		return nil, fmt.Errorf("%w: 共%d帧", ErrFrameOutOfRange, len(g.Image))
+	}
+
+	bounds := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	if bounds.Empty() {
+		bounds = g.Image[0].Bounds()
+	}
+	canvas := image.NewNRGBA(bounds)
+
+	for i := 0; i <= index; i++ {
+		frame := g.Image[i]
+		disposal := byte(0)
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+
+		var previous []byte
+		if disposal == gif.DisposalPrevious && i < index {
+			previous = append([]byte(nil), canvas.Pix...)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		if i == index {
+			break
+		}
+
+		switch disposal {
+		case gif.DisposalBackground:
+			// 浏览器将背景处置为透明，而不是全局背景色
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			copy(canvas.Pix, previous)
+		}
+	}
+	return canvas, nil
+}
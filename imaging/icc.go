@@ -0,0 +1,312 @@
+package imaging
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"strings"
+	"unicode/utf16"
+)
+
+// sRGB三原色在D50白点下的XYZ坐标（列依次为R、G、B），与ICC标准sRGB配置文件一致
+var srgbToXYZD50 = [3][3]float64{
+	{0.4360747, 0.3850649, 0.1430804},
+	{0.2225045, 0.7168786, 0.0606169},
+	{0.0139322, 0.0971045, 0.7141733},
+}
+
+// ICCProfile 解析后的ICC颜色配置文件
+type ICCProfile struct {
+	ColorSpace  string // 颜色空间，例如 RGB、GRAY、CMYK
+	Description string // 配置文件描述，例如 Display P3
+
+	// 矩阵/曲线型RGB配置文件的转换参数，其他类型为空
+	matrix *[3][3]float64
+	curves [3][]float64 // 0-255 输入对应的线性值
+}
+
+// ParseICC 解析ICC配置文件头部、描述以及矩阵/曲线转换参数
+func ParseICC(data []byte) (*ICCProfile, error) {
+	if len(data) < 132 || string(data[36:40]) != "acsp" {
+		return nil, errors.New("无效的ICC配置文件")
+	}
+
+	profile := &ICCProfile{ColorSpace: strings.TrimSpace(string(data[16:20]))}
+
+	tags := make(map[string][]byte)
+	count := int(binary.BigEndian.Uint32(data[128:]))
+	for i := 0; i < count; i++ {
+		pos := 132 + i*12
+		if pos+12 > len(data) {
+			break
+		}
+		offset := int(binary.BigEndian.Uint32(data[pos+4:]))
+		size := int(binary.BigEndian.Uint32(data[pos+8:]))
+		if offset < 0 || size < 0 || offset+size > len(data) {
+			continue
+		}
+		tags[string(data[pos:pos+4])] = data[offset : offset+size]
+	}
+
+	profile.Description = parseICCText(tags["desc"])
+
+	if profile.ColorSpace == "RGB" {
+		profile.parseMatrixShaper(tags)
+	}
+	return profile, nil
+}
+
+// parseMatrixShaper 读取三原色坐标和色调曲线，缺少任何一项时视为不支持转换
+func (p *ICCProfile) parseMatrixShaper(tags map[string][]byte) {
+	var m [3][3]float64
+	for col, name := range []string{"rXYZ", "gXYZ", "bXYZ"} {
+		tag := tags[name]
+		if len(tag) < 20 || string(tag[:4]) != "XYZ " {
+			return
+		}
+		for row := 0; row < 3; row++ {
+			m[row][col] = s15Fixed16(tag[8+row*4:])
+		}
+	}
+
+	var curves [3][]float64
+	for i, name := range []string{"rTRC", "gTRC", "bTRC"} {
+		curve, err := parseICCCurve(tags[name])
+		if err != nil {
+			return
+		}
+		curves[i] = curve
+	}
+
+	p.matrix = &m
+	p.curves = curves
+}
+
+// IsSRGB 根据描述判断是否为sRGB配置文件
+func (p *ICCProfile) IsSRGB() bool {
+	return p.ColorSpace == "RGB" && strings.Contains(strings.ToLower(p.Description), "srgb")
+}
+
+// CanConvert 是否支持将该配置文件的像素转换为sRGB
+func (p *ICCProfile) CanConvert() bool {
+	return p.matrix != nil
+}
+
+// ConvertToSRGB 将使用该配置文件编码的图片像素转换为sRGB
+func (p *ICCProfile) ConvertToSRGB(img image.Image) (*image.NRGBA, error) {
+	if !p.CanConvert() {
+		return nil, fmt.Errorf("不支持转换该类型的ICC配置文件: %s %s", p.ColorSpace, p.Description)
+	}
+
+	inv, ok := invert3(srgbToXYZD50)
+	if !ok {
+		return nil, errors.New("sRGB矩阵不可逆")
+	}
+	// 合并为 源线性RGB -> sRGB线性RGB 的单个矩阵
+	var m [3][3]float64
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				m[i][j] += inv[i][k] * p.matrix[k][j]
+			}
+		}
+	}
+
+	// sRGB编码曲线查找表，输入为 0-4095 的线性值
+	const encodeSteps = 4096
+	var encode [encodeSteps]uint8
+	for i := range encode {
+		v := float64(i) / (encodeSteps - 1)
+		if v <= 0.0031308 {
+			v *= 12.92
+		} else {
+			v = 1.055*math.Pow(v, 1/2.4) - 0.055
+		}
+		encode[i] = uint8(math.Round(v * 255))
+	}
+
+	src := toNRGBA(img)
+	dst := image.NewNRGBA(src.Bounds())
+	for i := 0; i+3 < len(src.Pix); i += 4 {
+		r := p.curves[0][src.Pix[i]]
+		g := p.curves[1][src.Pix[i+1]]
+		b := p.curves[2][src.Pix[i+2]]
+		for c := 0; c < 3; c++ {
+			v := m[c][0]*r + m[c][1]*g + m[c][2]*b
+			// math.Max和math.Min对NaN返回NaN，用比较的方式限制，保证下标在查找表范围内
+			if !(v > 0) {
+				v = 0
+			} else if v > 1 {
+				v = 1
+			}
+			dst.Pix[i+c] = encode[int(v*(encodeSteps-1)+0.5)]
+		}
+		dst.Pix[i+3] = src.Pix[i+3]
+	}
+	return dst, nil
+}
+
+// s15Fixed16 读取ICC的有符号16.16定点数
+func s15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
+
+// parseICCCurve 将curv或para类型的色调曲线展开为256项的线性值查找表
+func parseICCCurve(tag []byte) ([]float64, error) {
+	if len(tag) < 12 {
+		return nil, errors.New("色调曲线数据过短")
+	}
+
+	var fn func(x float64) float64
+	switch string(tag[:4]) {
+	case "curv":
+		n := int(binary.BigEndian.Uint32(tag[8:]))
+		switch {
+		case n == 0:
+			fn = func(x float64) float64 { return x }
+		case n == 1:
+			if len(tag) < 14 {
+				return nil, errors.New("色调曲线数据过短")
+			}
+			gamma := float64(binary.BigEndian.Uint16(tag[12:])) / 256
+			fn = func(x float64) float64 { return math.Pow(x, gamma) }
+		default:
+			if len(tag) < 12+n*2 {
+				return nil, errors.New("色调曲线数据过短")
+			}
+			table := make([]float64, n)
+			for i := range table {
+				table[i] = float64(binary.BigEndian.Uint16(tag[12+i*2:])) / 65535
+			}
+			fn = func(x float64) float64 {
+				pos := x * float64(n-1)
+				i := int(pos)
+				if i >= n-1 {
+					return table[n-1]
+				}
+				frac := pos - float64(i)
+				return table[i]*(1-frac) + table[i+1]*frac
+			}
+		}
+	case "para":
+		// 参数曲线类型0-4分别需要1、3、4、5、7个参数
+		paramCount := map[uint16]int{0: 1, 1: 3, 2: 4, 3: 5, 4: 7}
+		fnType := binary.BigEndian.Uint16(tag[8:])
+		n, ok := paramCount[fnType]
+		if !ok || len(tag) < 12+n*4 {
+			return nil, errors.New("不支持的参数曲线")
+		}
+		var params [7]float64
+		for i := 0; i < n; i++ {
+			params[i] = s15Fixed16(tag[12+i*4:])
+		}
+		g, a, b, c, d, e, f := params[0], params[1], params[2], params[3], params[4], params[5], params[6]
+		switch fnType {
+		case 0:
+			fn = func(x float64) float64 { return math.Pow(x, g) }
+		case 1:
+			fn = func(x float64) float64 {
+				if x >= -b/a {
+					return math.Pow(a*x+b, g)
+				}
+				return 0
+			}
+		case 2:
+			fn = func(x float64) float64 {
+				if x >= -b/a {
+					return math.Pow(a*x+b, g) + c
+				}
+				return c
+			}
+		case 3:
+			fn = func(x float64) float64 {
+				if x >= d {
+					return math.Pow(a*x+b, g)
+				}
+				return c * x
+			}
+		case 4:
+			fn = func(x float64) float64 {
+				if x >= d {
+					return math.Pow(a*x+b, g) + e
+				}
+				return c*x + f
+			}
+		}
+	default:
+		return nil, fmt.Errorf("不支持的色调曲线类型: %q", tag[:4])
+	}
+
+	// 配置文件来自上传的图片，参数异常时曲线可能得到NaN或无穷大（例如负数开平方），限制到0-1
+	lut := make([]float64, 256)
+	for i := range lut {
+		v := fn(float64(i) / 255)
+		switch {
+		case math.IsNaN(v) || v < 0:
+			v = 0
+		case v > 1:
+			v = 1
+		}
+		lut[i] = v
+	}
+	return lut, nil
+}
+
+// parseICCText 读取desc(ICC v2)或mluc(ICC v4)类型的文本
+func parseICCText(tag []byte) string {
+	if len(tag) < 12 {
+		return ""
+	}
+
+	switch string(tag[:4]) {
+	case "desc":
+		n := int(binary.BigEndian.Uint32(tag[8:]))
+		if 12+n > len(tag) {
+			return ""
+		}
+		return strings.TrimRight(string(tag[12:12+n]), "\x00")
+	case "mluc":
+		records := int(binary.BigEndian.Uint32(tag[8:]))
+		if records < 1 || len(tag) < 28 {
+			return ""
+		}
+		// 使用第一条记录
+		length := int(binary.BigEndian.Uint32(tag[20:]))
+		offset := int(binary.BigEndian.Uint32(tag[24:]))
+		if offset+length > len(tag) {
+			return ""
+		}
+		units := make([]uint16, length/2)
+		for i := range units {
+			units[i] = binary.BigEndian.Uint16(tag[offset+i*2:])
+		}
+		return strings.TrimRight(string(utf16.Decode(units)), "\x00")
+	case "text":
+		return strings.TrimRight(string(tag[8:]), "\x00")
+	}
+	return ""
+}
+
+// invert3 计算3x3矩阵的逆矩阵
+func invert3(m [3][3]float64) ([3][3]float64, bool) {
+	det := m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+	if math.Abs(det) < 1e-12 {
+		return [3][3]float64{}, false
+	}
+
+	var inv [3][3]float64
+	inv[0][0] = (m[1][1]*m[2][2] - m[1][2]*m[2][1]) / det
+	inv[0][1] = (m[0][2]*m[2][1] - m[0][1]*m[2][2]) / det
+	inv[0][2] = (m[0][1]*m[1][2] - m[0][2]*m[1][1]) / det
+	inv[1][0] = (m[1][2]*m[2][0] - m[1][0]*m[2][2]) / det
+	inv[1][1] = (m[0][0]*m[2][2] - m[0][2]*m[2][0]) / det
+	inv[1][2] = (m[0][2]*m[1][0] - m[0][0]*m[1][2]) / det
+	inv[2][0] = (m[1][0]*m[2][1] - m[1][1]*m[2][0]) / det
+	inv[2][1] = (m[0][1]*m[2][0] - m[0][0]*m[2][1]) / det
+	inv[2][2] = (m[0][0]*m[1][1] - m[0][1]*m[1][0]) / det
+	return inv, true
+}
@@ -0,0 +1,94 @@
+package imaging
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+// buildICC 生成只包含三原色坐标和色调曲线的RGB配置文件
+func buildICC(xyz [3][3]float64, curve []byte) []byte {
+	s15 := func(v float64) []byte {
+		b := make([]byte, 4)
+		binary.BigEndian.PutUint32(b, uint32(int32(v*65536)))
+		return b
+	}
+
+	tags := map[string][]byte{}
+	for col, name := range []string{"rXYZ", "gXYZ", "bXYZ"} {
+		tag := []byte("XYZ \x00\x00\x00\x00")
+		for row := 0; row < 3; row++ {
+			tag = append(tag, s15(xyz[row][col])...)
+		}
+		tags[name] = tag
+	}
+	for _, name := range []string{"rTRC", "gTRC", "bTRC"} {
+		tags[name] = curve
+	}
+
+	names := []string{"rXYZ", "gXYZ", "bXYZ", "rTRC", "gTRC", "bTRC"}
+	data := make([]byte, 132+len(names)*12)
+	copy(data[16:], "RGB ")
+	copy(data[36:], "acsp")
+	binary.BigEndian.PutUint32(data[128:], uint32(len(names)))
+	for i, name := range names {
+		pos := 132 + i*12
+		copy(data[pos:], name)
+		binary.BigEndian.PutUint32(data[pos+4:], uint32(len(data)))
+		binary.BigEndian.PutUint32(data[pos+8:], uint32(len(tags[name])))
+		data = append(data, tags[name]...)
+	}
+	return data
+}
+
+// paraCurve 生成参数曲线，params 按 g、a、b、c、d、e、f 的顺序
+func paraCurve(fnType uint16, params ...float64) []byte {
+	tag := []byte("para\x00\x00\x00\x00\x00\x00\x00\x00")
+	binary.BigEndian.PutUint16(tag[8:], fnType)
+	for _, p := range params {
+		b := make([]byte, 4)
+		binary.BigEndian.PutUint32(b, uint32(int32(p*65536)))
+		tag = append(tag, b...)
+	}
+	return tag
+}
+
+func TestConvertToSRGBInvalidCurves(t *testing.T) {
+	srgbXYZ := [3][3]float64{{0.4361, 0.3851, 0.1431}, {0.2225, 0.7169, 0.0606}, {0.0139, 0.0971, 0.7141}}
+	huge := [3][3]float64{{30000, -30000, 30000}, {-30000, 30000, -30000}, {30000, 30000, -30000}}
+	tests := []struct {
+		name  string
+		xyz   [3][3]float64
+		curve []byte
+	}{
+		// a*x+b 小于0时负数开平方得到NaN
+		{"para3 negative base", srgbXYZ, paraCurve(3, 0.5, 1, -0.5, 0, 0)},
+		{"para4 negative base", srgbXYZ, paraCurve(4, 0.5, 1, -0.5, 0, 0, 0, 0)},
+		{"para1 zero a", srgbXYZ, paraCurve(1, 1, 0, 0)},
+		{"para0 huge gamma", huge, paraCurve(0, -30000)},
+	}
+
+	src := image.NewNRGBA(image.Rect(0, 0, 256, 1))
+	for x := 0; x < 256; x++ {
+		src.Set(x, 0, color.NRGBA{uint8(x), uint8(255 - x), uint8(x / 2), 255})
+	}
+
+	for _, tt := range tests {
+		profile, err := ParseICC(buildICC(tt.xyz, tt.curve))
+		if err != nil || !profile.CanConvert() {
+			t.Fatalf("%s: 配置文件解析失败: %v", tt.name, err)
+		}
+		for i, curve := range profile.curves {
+			for j, v := range curve {
+				if math.IsNaN(v) || v < 0 || v > 1 {
+					t.Fatalf("%s: 曲线 %d 第 %d 项超出范围: %v", tt.name, i, j, v)
+				}
+			}
+		}
+		if _, err := profile.ConvertToSRGB(src); err != nil {
+			t.Errorf("%s: 转换失败: %v", tt.name, err)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io"
+	"os"
+)
+
+// Info 从文件内容中读取的图片基本信息
+type Info struct {
+	Format     string // 格式: jpeg/png/gif/webp，无法识别时为空
+	Width      int    // 宽度（像素）
+	Height     int    // 高度（像素）
+	Frames     int    // 帧数，静态图片为1
+	DurationMs int    // 动画总时长（毫秒），静态图片为0
+	ColorModel string // 颜色模型: RGB/Gray/CMYK/Paletted
+}
+
+// Inspect 读取图片尺寸、帧数和动画时长，只解析头部和数据块结构，不解码像素
+func Inspect(data []byte) Info {
+	switch {
+	case bytes.HasPrefix(data, []byte("GIF")):
+		return inspectGIF(data)
+	case isWebP(data):
+		return inspectWebP(data)
+	}
+
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return Info{}
+	}
+	return Info{
+		Format:     format,
+		Width:      cfg.Width,
+		Height:     cfg.Height,
+		Frames:     1,
+		ColorModel: colorModelName(cfg.ColorModel),
+	}
+}
+
+// colorModelName 返回颜色模型的简短名称
+func colorModelName(m color.Model) string {
+	switch m {
+	case color.GrayModel, color.Gray16Model:
+		return "Gray"
+	case color.CMYKModel:
+		return "CMYK"
+	}
+	if _, ok := m.(color.Palette); ok {
+		return "Paletted"
+	}
+	return "RGB"
+}
+
+// inspectGIF 遍历GIF数据块，统计帧数并累加图形控制扩展中的帧延迟
+func inspectGIF(data []byte) Info {
+	info := Info{Format: "gif", ColorModel: "Paletted"}
+	if len(data) < 13 {
+		return info
+	}
+	info.Width = int(binary.LittleEndian.Uint16(data[6:]))
+	info.Height = int(binary.LittleEndian.Uint16(data[8:]))
+
+	pos := 13
+	if data[10]&0x80 != 0 {
+		pos += 3 * (1 << (int(data[10]&0x07) + 1))
+	}
+
+	// skipSubBlocks 跳过数据子块序列，返回结束后的位置
+	skipSubBlocks := func(p int) int {
+		for p < len(data) {
+			size := int(data[p])
+			p++
+			if size == 0 {
+				return p
+			}
+			p += size
+		}
+		return len(data)
+	}
+
+	for pos < len(data) {
+		switch data[pos] {
+		case 0x2c: // 图像描述符
+			if pos+10 > len(data) {
+				return info
+			}
+			info.Frames++
+			flags := data[pos+9]
+			pos += 10
+			if flags&0x80 != 0 {
+				pos += 3 * (1 << (int(flags&0x07) + 1))
+			}
+			pos = skipSubBlocks(pos + 1) // 跳过LZW最小码长
+		case 0x21: // 扩展块
+			if pos+2 > len(data) {
+				return info
+			}
+			// 图形控制扩展：块大小(4) + 标志 + 延迟(1/100秒)
+			if data[pos+1] == 0xf9 && pos+6 <= len(data) {
+				info.DurationMs += int(binary.LittleEndian.Uint16(data[pos+4:])) * 10
+			}
+			pos = skipSubBlocks(pos + 2)
+		default: // 0x3b 结束符
+			if info.Frames <= 1 {
+				info.DurationMs = 0
+			}
+			return info
+		}
+	}
+	if info.Frames <= 1 {
+		info.DurationMs = 0
+	}
+	return info
+}
+
+// inspectWebP 读取VP8X/VP8/VP8L头部中的尺寸，并统计ANMF帧数和时长
+func inspectWebP(data []byte) Info {
+	info := Info{Format: "webp", ColorModel: "RGB"}
+	for _, chunk := range webpChunks(data) {
+		info.addWebPChunk(chunk.fourCC, chunk.payload(data))
+	}
+	if info.Frames == 0 {
+		info.Frames = 1
+	}
+	return info
+}
+
+// webpInspectBytes 读取尺寸和帧信息需要的数据块开头字节数（ANMF的帧延迟位于第12-14字节）
+const webpInspectBytes = 16
+
+// addWebPChunk 根据一个数据块开头的内容更新尺寸、帧数和时长，p 只需包含前 webpInspectBytes 个字节
+func (info *Info) addWebPChunk(fourCC string, p []byte) {
+	switch fourCC {
+	case "VP8X":
+		if len(p) >= 10 {
+			info.Width = int(uint24(p[4:])) + 1
+			info.Height = int(uint24(p[7:])) + 1
+		}
+	case "VP8 ":
+		// 帧标签(3字节) + 起始码 9d 01 2a + 宽高（各14位）
+		if info.Width == 0 && len(p) >= 10 {
+			info.Width = int(binary.LittleEndian.Uint16(p[6:]) & 0x3fff)
+			info.Height = int(binary.LittleEndian.Uint16(p[8:]) & 0x3fff)
+		}
+	case "VP8L":
+		// 签名 0x2f + 宽减一(14位) + 高减一(14位)
+		if info.Width == 0 && len(p) >= 5 {
+			bits := binary.LittleEndian.Uint32(p[1:])
+			info.Width = int(bits&0x3fff) + 1
+			info.Height = int((bits>>14)&0x3fff) + 1
+		}
+	case "ANMF":
+		if len(p) >= 16 {
+			info.Frames++
+			info.DurationMs += int(uint24(p[12:]))
+		}
+	}
+}
+
+// InspectFile 与 Inspect 相同，但WebP只读取各数据块的头部，跳过图像数据，不需要读取整个文件
+func InspectFile(path string) (Info, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Info{}, err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return Info{}, err
+	}
+
+	header := make([]byte, 12)
+	n, _ := io.ReadFull(f, header)
+	if !isWebP(header[:n]) {
+		data, err := io.ReadAll(io.MultiReader(bytes.NewReader(header[:n]), f))
+		if err != nil {
+			return Info{}, err
+		}
+		return Inspect(data), nil
+	}
+
+	info := Info{Format: "webp", ColorModel: "RGB"}
+	var chunkHeader [8]byte
+	head := make([]byte, webpInspectBytes)
+	for pos := int64(12); pos+8 <= stat.Size(); {
+		if _, err := f.ReadAt(chunkHeader[:], pos); err != nil {
+			return Info{}, err
+		}
+		size := int64(binary.LittleEndian.Uint32(chunkHeader[4:]))
+		// 与 webpChunks 相同，数据块超出文件末尾时停止
+		if pos+8+size > stat.Size() {
+			break
+		}
+		p := head[:min(size, webpInspectBytes)]
+		if _, err := f.ReadAt(p, pos+8); err != nil {
+			return Info{}, err
+		}
+		info.addWebPChunk(string(chunkHeader[:4]), p)
+		pos += 8 + size + size%2
+	}
+	if info.Frames == 0 {
+		info.Frames = 1
+	}
+	return info, nil
+}
+
+// uint24 读取3字节小端整数
+func uint24(b []byte) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16
+}
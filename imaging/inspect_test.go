@@ -0,0 +1,45 @@
+package imaging
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInspectFileMatchesInspect(t *testing.T) {
+	anmf := func(duration byte, data string) string {
+		// 偏移(6字节) + 宽高减一(6字节) + 时长(3字节) + 标志(1字节) + 帧数据
+		return webpChunkData("ANMF", uint32(16+len(data)), "\x00\x00\x00\x00\x00\x00\x09\x00\x00\x09\x00\x00"+string([]byte{duration, 0, 0, 0})+data)
+	}
+	files := map[string][]byte{
+		"vp8l": riff(webpChunkData("VP8L", 5, "\x2f\x63\xc0\x18\x00\x00")),
+		"vp8":  riff(webpChunkData("VP8 ", 10, "\x00\x00\x00\x9d\x01\x2a\x40\x01\xf0\x00")),
+		"animated": riff(
+			webpChunkData("VP8X", 10, "\x12\x00\x00\x00\x09\x00\x00\x09\x00\x00"),
+			webpChunkData("ANIM", 6, "\x00\x00\x00\x00\x00\x00"),
+			anmf(100, "frame1"),
+			anmf(50, "frame2x"),
+		),
+		"truncated": riff(webpChunkData("VP8L", 100, "\x2f\x63\xc0\x18\x00")),
+		"gif":       []byte("GIF89a\x01\x00\x01\x00\x80\x00\x00\x00\x00\x00\xff\xff\xff!\xf9\x04\x01\x00\x00\x00\x00,\x00\x00\x00\x00\x01\x00\x01\x00\x00\x02\x02D\x01\x00;"),
+	}
+
+	dir := t.TempDir()
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := InspectFile(path)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if want := Inspect(data); got != want {
+			t.Errorf("%s: InspectFile = %+v, Inspect = %+v", name, got, want)
+		}
+	}
+
+	if info, _ := InspectFile(filepath.Join(dir, "animated")); info.Frames != 2 || info.DurationMs != 150 || info.Width != 10 {
+		t.Errorf("动画WebP的信息不正确: %+v", info)
+	}
+}
@@ -0,0 +1,29 @@
+package imaging
+
+import (
+	"fmt"
+	"image"
+	_ "image/gif"  // 注册GIF解码器
+	_ "image/jpeg" // 注册JPEG解码器
+	_ "image/png"  // 注册PNG解码器
+	"os"
+
+	_ "golang.org/x/image/bmp"  // 注册BMP解码器
+	_ "golang.org/x/image/tiff" // 注册TIFF解码器
+	_ "golang.org/x/image/webp" // 注册WebP解码器，不支持动画WebP
+)
+
+// Load 解码图片文件，返回图片和格式名称
+func Load(path string) (image.Image, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("打开图片失败: %w", err)
+	}
+	defer f.Close()
+
+	img, format, err := image.Decode(f)
+	if err != nil {
+		return nil, "", fmt.Errorf("解码图片失败: %w", err)
+	}
+	return img, format, nil
+}
@@ -0,0 +1,517 @@
+package imaging
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"os"
+	"slices"
+	"sort"
+)
+
+// 各容器格式中元数据段的标识
+var (
+	jpegEXIFHeader = []byte("Exif\x00\x00")
+	jpegXMPHeader  = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	jpegICCHeader  = []byte("ICC_PROFILE\x00")
+	pngSignature   = []byte("\x89PNG\r\n\x1a\n")
+)
+
+// Embedded 图片文件中嵌入的元数据
+type Embedded struct {
+	EXIF []byte // TIFF格式的EXIF数据
+	ICC  []byte // ICC颜色配置文件
+	XMP  []byte // XMP数据包
+}
+
+// ReadEmbedded 从JPEG、PNG或WebP文件内容中提取嵌入的元数据，不支持的格式返回空结果
+func ReadEmbedded(data []byte) *Embedded {
+	switch {
+	case len(data) > 3 && data[0] == 0xff && data[1] == 0xd8:
+		return readJPEGEmbedded(data)
+	case bytes.HasPrefix(data, pngSignature):
+		return readPNGEmbedded(data)
+	case isWebP(data):
+		return readWebPEmbedded(data)
+	}
+	return &Embedded{}
+}
+
+// Orientation 返回EXIF中记录的方向，没有记录时返回1
+func (e *Embedded) Orientation() int {
+	if len(e.EXIF) == 0 {
+		return 1
+	}
+	exif, err := ParseEXIF(e.EXIF)
+	if err != nil || exif.Orientation < 1 || exif.Orientation > 8 {
+		return 1
+	}
+	return exif.Orientation
+}
+
+// jpegSegment JPEG文件中扫描数据之前的一个标记段
+type jpegSegment struct {
+	marker byte
+	start  int // 包含 0xFF 标记的起始位置
+	end    int // 段结束位置（不含）
+}
+
+// payload 返回段内容（不含标记和长度字段）
+func (s jpegSegment) payload(data []byte) []byte {
+	return data[s.start+4 : s.end]
+}
+
+// jpegSegments 遍历JPEG扫描数据之前的所有标记段，返回各段及扫描数据的起始位置
+func jpegSegments(data []byte) ([]jpegSegment, int) {
+	var segments []jpegSegment
+	pos := 2 // 跳过SOI
+	for pos+4 <= len(data) {
+		if data[pos] != 0xff {
+			break
+		}
+		marker := data[pos+1]
+		if marker == 0xff { // 填充字节
+			pos++
+			continue
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			break
+		}
+		segments = append(segments, jpegSegment{marker: marker, start: pos, end: end})
+		pos = end
+		if marker == 0xda { // SOS之后是压缩数据
+			break
+		}
+	}
+	return segments, pos
+}
+
+func readJPEGEmbedded(data []byte) *Embedded {
+	emb := &Embedded{}
+	type iccChunk struct {
+		seq  byte
+		data []byte
+	}
+	var iccChunks []iccChunk
+
+	segments, _ := jpegSegments(data)
+	for _, seg := range segments {
+		p := seg.payload(data)
+		switch {
+		case seg.marker == 0xe1 && bytes.HasPrefix(p, jpegEXIFHeader) && emb.EXIF == nil:
+			emb.EXIF = p[len(jpegEXIFHeader):]
+		case seg.marker == 0xe1 && bytes.HasPrefix(p, jpegXMPHeader) && emb.XMP == nil:
+			emb.XMP = p[len(jpegXMPHeader):]
+		case seg.marker == 0xe2 && bytes.HasPrefix(p, jpegICCHeader) && len(p) > len(jpegICCHeader)+2:
+			// ICC配置文件可能被拆分到多个APP2段中，按序号拼接
+			iccChunks = append(iccChunks, iccChunk{seq: p[len(jpegICCHeader)], data: p[len(jpegICCHeader)+2:]})
+		}
+	}
+
+	if len(iccChunks) > 0 {
+		sort.SliceStable(iccChunks, func(i, j int) bool { return iccChunks[i].seq < iccChunks[j].seq })
+		for _, c := range iccChunks {
+			emb.ICC = append(emb.ICC, c.data...)
+		}
+	}
+	return emb
+}
+
+// pngChunk PNG文件中的一个数据块
+type pngChunk struct {
+	typ   string
+	start int // 包含长度字段的起始位置
+	end   int // 包含CRC的结束位置（不含）
+}
+
+func (c pngChunk) payload(data []byte) []byte {
+	return data[c.start+8 : c.end-4]
+}
+
+// pngChunks 遍历PNG文件中的所有数据块
+func pngChunks(data []byte) []pngChunk {
+	var chunks []pngChunk
+	pos := len(pngSignature)
+	for pos+12 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		end := pos + 12 + length
+		if length < 0 || end > len(data) {
+			break
+		}
+		chunks = append(chunks, pngChunk{typ: string(data[pos+4 : pos+8]), start: pos, end: end})
+		pos = end
+	}
+	return chunks
+}
+
+func readPNGEmbedded(data []byte) *Embedded {
+	emb := &Embedded{}
+	for _, chunk := range pngChunks(data) {
+		p := chunk.payload(data)
+		switch chunk.typ {
+		case "eXIf":
+			emb.EXIF = p
+		case "iCCP":
+			// 配置文件名称 + \0 + 压缩方式(1字节) + zlib压缩的配置文件
+			if i := bytes.IndexByte(p, 0); i >= 0 && i+2 <= len(p) {
+				if zr, err := zlib.NewReader(bytes.NewReader(p[i+2:])); err == nil {
+					emb.ICC, _ = io.ReadAll(zr)
+					zr.Close()
+				}
+			}
+		case "iTXt":
+			if bytes.HasPrefix(p, []byte("XML:com.adobe.xmp\x00")) {
+				// 关键字 + \0 + 压缩标志 + 压缩方式 + 语言 + \0 + 翻译关键字 + \0 + 文本
+				rest := p[len("XML:com.adobe.xmp\x00"):]
+				if len(rest) > 2 && rest[0] == 0 {
+					rest = rest[2:]
+					if i := bytes.IndexByte(rest, 0); i >= 0 {
+						rest = rest[i+1:]
+						if i := bytes.IndexByte(rest, 0); i >= 0 {
+							emb.XMP = rest[i+1:]
+						}
+					}
+				}
+			}
+		}
+	}
+	return emb
+}
+
+// isWebP 检查数据是否为WebP容器
+func isWebP(data []byte) bool {
+	return len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP"
+}
+
+// webpChunk WebP容器中的一个数据块
+type webpChunk struct {
+	fourCC string
+	start  int // 包含FourCC的起始位置
+	end    int // 包含填充字节的结束位置（不含）
+}
+
+func (c webpChunk) payload(data []byte) []byte {
+	size := int(binary.LittleEndian.Uint32(data[c.start+4:]))
+	return data[c.start+8 : c.start+8+size]
+}
+
+// webpChunks 遍历WebP容器中的所有数据块
+func webpChunks(data []byte) []webpChunk {
+	var chunks []webpChunk
+	pos := 12
+	for pos+8 <= len(data) {
+		size := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		end := pos + 8 + size + size%2
+		if size < 0 || pos+8+size > len(data) {
+			break
+		}
+		// VP8X固定为10字节，更短的是损坏的文件，之后的数据不再解析
+		if string(data[pos:pos+4]) == "VP8X" && size < 10 {
+			break
+		}
+		if end > len(data) {
+			end = len(data)
+		}
+		chunks = append(chunks, webpChunk{fourCC: string(data[pos : pos+4]), start: pos, end: end})
+		pos = end
+	}
+	return chunks
+}
+
+func readWebPEmbedded(data []byte) *Embedded {
+	emb := &Embedded{}
+	for _, chunk := range webpChunks(data) {
+		switch chunk.fourCC {
+		case "EXIF":
+			emb.EXIF = bytes.TrimPrefix(chunk.payload(data), jpegEXIFHeader)
+		case "ICCP":
+			emb.ICC = chunk.payload(data)
+		case "XMP ":
+			emb.XMP = chunk.payload(data)
+		}
+	}
+	return emb
+}
+
+// embeddedHeaderBytes ReadEmbeddedFile 最多读取的文件开头字节数，也是单个WebP元数据块的大小上限
+// JPEG的元数据段和PNG的iCCP位于图像数据之前，超过这个范围的元数据视为不存在
+const embeddedHeaderBytes = 1 << 20
+
+// ReadEmbeddedFile 与 ReadEmbedded 相同，但不读取整个文件
+// JPEG和PNG只读取文件开头；WebP的EXIF和XMP位于文件末尾，只读取各数据块的头部和元数据块的内容
+func ReadEmbeddedFile(path string) (*Embedded, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, min(stat.Size(), embeddedHeaderBytes))
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	header = header[:n]
+	if !isWebP(header) {
+		return ReadEmbedded(header), nil
+	}
+
+	emb := &Embedded{}
+	var chunkHeader [8]byte
+	for pos := int64(12); pos+8 <= stat.Size(); {
+		if _, err := f.ReadAt(chunkHeader[:], pos); err != nil {
+			return nil, err
+		}
+		size := int64(binary.LittleEndian.Uint32(chunkHeader[4:]))
+		// 与 webpChunks 相同，数据块超出文件末尾时停止
+		if pos+8+size > stat.Size() {
+			break
+		}
+		var target *[]byte
+		switch string(chunkHeader[:4]) {
+		case "EXIF":
+			target = &emb.EXIF
+		case "ICCP":
+			target = &emb.ICC
+		case "XMP ":
+			target = &emb.XMP
+		}
+		if target != nil && size <= embeddedHeaderBytes {
+			p := make([]byte, size)
+			if _, err := f.ReadAt(p, pos+8); err != nil {
+				return nil, err
+			}
+			*target = p
+		}
+		pos += 8 + size + size%2
+	}
+	emb.EXIF = bytes.TrimPrefix(emb.EXIF, jpegEXIFHeader)
+	return emb, nil
+}
+
+// JPEG标记段的最大内容长度（长度字段本身占2字节）
+const jpegMaxSegment = 0xffff - 2
+
+// Embed 将元数据写入不含元数据的JPEG或PNG文件内容（例如Go编码器的输出），不支持的格式返回 false
+// JPEG中超过单个标记段长度的EXIF和XMP无法写入，会被忽略
+func Embed(data []byte, emb *Embedded) ([]byte, bool) {
+	switch {
+	case len(data) > 3 && data[0] == 0xff && data[1] == 0xd8:
+		return embedJPEG(data, emb), true
+	case bytes.HasPrefix(data, pngSignature):
+		return embedPNG(data, emb), true
+	}
+	return data, false
+}
+
+func embedJPEG(data []byte, emb *Embedded) []byte {
+	segments, _ := jpegSegments(data)
+	insertAt := 2
+	if len(segments) > 0 && segments[0].marker == 0xe0 {
+		insertAt = segments[0].end // 写在JFIF段之后
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)+len(emb.EXIF)+len(emb.XMP)+len(emb.ICC)+64))
+	out.Write(data[:insertAt])
+	if len(emb.EXIF) > 0 && len(jpegEXIFHeader)+len(emb.EXIF) <= jpegMaxSegment {
+		writeJPEGSegment(out, 0xe1, append(append([]byte(nil), jpegEXIFHeader...), emb.EXIF...))
+	}
+	if len(emb.XMP) > 0 && len(jpegXMPHeader)+len(emb.XMP) <= jpegMaxSegment {
+		writeJPEGSegment(out, 0xe1, append(append([]byte(nil), jpegXMPHeader...), emb.XMP...))
+	}
+	// ICC配置文件按最大段长度拆分到多个APP2段中，每段带有序号和总数
+	chunkSize := jpegMaxSegment - len(jpegICCHeader) - 2
+	count := (len(emb.ICC) + chunkSize - 1) / chunkSize
+	if count <= 255 {
+		for i := 0; i < count; i++ {
+			chunk := emb.ICC[i*chunkSize : min((i+1)*chunkSize, len(emb.ICC))]
+			payload := append(append([]byte(nil), jpegICCHeader...), byte(i+1), byte(count))
+			writeJPEGSegment(out, 0xe2, append(payload, chunk...))
+		}
+	}
+	out.Write(data[insertAt:])
+	return out.Bytes()
+}
+
+func embedPNG(data []byte, emb *Embedded) []byte {
+	chunks := pngChunks(data)
+	if len(chunks) == 0 || chunks[0].typ != "IHDR" {
+		return data
+	}
+
+	// 元数据块写在IHDR之后，满足iCCP和eXIf必须位于PLTE和IDAT之前的要求
+	out := bytes.NewBuffer(make([]byte, 0, len(data)+len(emb.EXIF)+len(emb.XMP)+len(emb.ICC)+64))
+	out.Write(data[:chunks[0].end])
+	if len(emb.ICC) > 0 {
+		var compressed bytes.Buffer
+		zw := zlib.NewWriter(&compressed)
+		zw.Write(emb.ICC)
+		zw.Close()
+		// 配置文件名称 + \0 + 压缩方式0
+		writePNGChunk(out, "iCCP", append([]byte("ICC Profile\x00\x00"), compressed.Bytes()...))
+	}
+	if len(emb.EXIF) > 0 {
+		writePNGChunk(out, "eXIf", emb.EXIF)
+	}
+	if len(emb.XMP) > 0 {
+		// 关键字 + \0 + 不压缩 + 压缩方式 + 空语言 + \0 + 空翻译关键字 + \0 + 文本
+		writePNGChunk(out, "iTXt", append([]byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00"), emb.XMP...))
+	}
+	out.Write(data[chunks[0].end:])
+	return out.Bytes()
+}
+
+// Sanitize 移除JPEG、PNG或WebP文件中的元数据，返回清理后的内容
+// keepICC 为 true 时保留ICC颜色配置文件；EXIF方向不为1时写入只含方向的最小EXIF，避免图片显示方向错误
+// 不支持的格式，或文件结构损坏、无法完整遍历（清理结果会丢失图像数据）时返回原内容和 false
+func Sanitize(data []byte, keepICC bool) ([]byte, bool) {
+	var out []byte
+	var ok bool
+	switch {
+	case len(data) > 3 && data[0] == 0xff && data[1] == 0xd8:
+		out, ok = sanitizeJPEG(data, keepICC)
+	case bytes.HasPrefix(data, pngSignature):
+		out, ok = sanitizePNG(data, keepICC)
+	case isWebP(data):
+		out, ok = sanitizeWebP(data, keepICC)
+	}
+	if !ok {
+		return data, false
+	}
+	return out, true
+}
+
+// sanitizeJPEG 只有遍历到扫描数据（SOS）时才返回 true
+func sanitizeJPEG(data []byte, keepICC bool) ([]byte, bool) {
+	segments, scanStart := jpegSegments(data)
+	if len(segments) == 0 || segments[len(segments)-1].marker != 0xda {
+		return nil, false
+	}
+	orientation := readJPEGEmbedded(data).Orientation()
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:2]) // SOI
+
+	wroteOrientation := orientation == 1
+	for _, seg := range segments {
+		keep := true
+		switch {
+		case seg.marker == 0xe0 || seg.marker == 0xee:
+			// JFIF和Adobe段影响颜色解码，必须保留
+		case seg.marker == 0xe2 && bytes.HasPrefix(seg.payload(data), jpegICCHeader):
+			keep = keepICC
+		case seg.marker >= 0xe1 && seg.marker <= 0xef, seg.marker == 0xfe:
+			// 其余APP段（EXIF、XMP、IPTC等）和注释全部移除
+			keep = false
+		}
+
+		// 在第一个非APP0段之前写入只包含方向的EXIF
+		if !wroteOrientation && seg.marker != 0xe0 {
+			writeJPEGSegment(out, 0xe1, append(append([]byte(nil), jpegEXIFHeader...), OrientationEXIF(orientation)...))
+			wroteOrientation = true
+		}
+		if keep {
+			out.Write(data[seg.start:seg.end])
+		}
+	}
+	out.Write(data[scanStart:])
+	return out.Bytes(), true
+}
+
+func writeJPEGSegment(w *bytes.Buffer, marker byte, payload []byte) {
+	w.Write([]byte{0xff, marker})
+	binary.Write(w, binary.BigEndian, uint16(len(payload)+2))
+	w.Write(payload)
+}
+
+// sanitizePNG 只有遍历到IEND时才返回 true，IEND之后的数据丢弃
+func sanitizePNG(data []byte, keepICC bool) ([]byte, bool) {
+	chunks := pngChunks(data)
+	end := slices.IndexFunc(chunks, func(c pngChunk) bool { return c.typ == "IEND" })
+	if end < 0 {
+		return nil, false
+	}
+	chunks = chunks[:end+1]
+	orientation := readPNGEmbedded(data).Orientation()
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(pngSignature)
+	for _, chunk := range chunks {
+		switch chunk.typ {
+		case "eXIf", "tEXt", "zTXt", "iTXt", "tIME":
+			continue
+		case "iCCP":
+			if !keepICC {
+				continue
+			}
+		case "IDAT":
+			// eXIf 必须位于IDAT之前
+			if orientation != 1 {
+				writePNGChunk(out, "eXIf", OrientationEXIF(orientation))
+				orientation = 1
+			}
+		}
+		out.Write(data[chunk.start:chunk.end])
+	}
+	return out.Bytes(), true
+}
+
+func writePNGChunk(w *bytes.Buffer, typ string, payload []byte) {
+	binary.Write(w, binary.BigEndian, uint32(len(payload)))
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(typ))
+	crc.Write(payload)
+	w.WriteString(typ)
+	w.Write(payload)
+	binary.Write(w, binary.BigEndian, crc.Sum32())
+}
+
+// sanitizeWebP 只有遍历到文件末尾时才返回 true
+func sanitizeWebP(data []byte, keepICC bool) ([]byte, bool) {
+	// VP8X标志位
+	const (
+		flagICC  = 0x20
+		flagEXIF = 0x08
+		flagXMP  = 0x04
+	)
+
+	chunks := webpChunks(data)
+	if len(chunks) == 0 || chunks[len(chunks)-1].end != len(data) {
+		return nil, false
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:12])
+	for _, chunk := range chunks {
+		switch chunk.fourCC {
+		case "EXIF", "XMP ":
+			continue
+		case "ICCP":
+			if !keepICC {
+				continue
+			}
+		case "VP8X":
+			// 清除已移除元数据对应的标志位
+			vp8x := append([]byte(nil), data[chunk.start:chunk.end]...)
+			vp8x[8] &^= flagEXIF | flagXMP
+			if !keepICC {
+				vp8x[8] &^= flagICC
+			}
+			out.Write(vp8x)
+			continue
+		}
+		out.Write(data[chunk.start:chunk.end])
+	}
+
+	result := out.Bytes()
+	binary.LittleEndian.PutUint32(result[4:], uint32(len(result)-8))
+	return result, true
+}
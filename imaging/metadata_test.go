@@ -0,0 +1,210 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// riff 拼接WebP数据块并写入正确的RIFF长度
+func riff(chunks ...string) []byte {
+	data := []byte("RIFF\x00\x00\x00\x00WEBP" + join(chunks))
+	binary.LittleEndian.PutUint32(data[4:], uint32(len(data)-8))
+	return data
+}
+
+// webpChunkData 生成一个WebP数据块，size 为写入的长度字段，可以与内容长度不一致
+func webpChunkData(fourCC string, size uint32, payload string) string {
+	var header [8]byte
+	copy(header[:], fourCC)
+	binary.LittleEndian.PutUint32(header[4:], size)
+	return string(header[:]) + payload
+}
+
+// pngChunkData 生成一个PNG数据块，length 为写入的长度字段，CRC不做校验
+func pngChunkData(typ string, length uint32, payload string) string {
+	var header [8]byte
+	binary.BigEndian.PutUint32(header[:], length)
+	copy(header[4:], typ)
+	return string(header[:]) + payload + "\x00\x00\x00\x00"
+}
+
+// jpegSegmentData 生成一个JPEG标记段，length 为写入的长度字段
+func jpegSegmentData(marker byte, length uint16, payload string) string {
+	return string([]byte{0xff, marker, byte(length >> 8), byte(length)}) + payload
+}
+
+func join(parts []string) string {
+	var b bytes.Buffer
+	for _, p := range parts {
+		b.WriteString(p)
+	}
+	return b.String()
+}
+
+func TestSanitizeMalformed(t *testing.T) {
+	png := string(pngSignature)
+	sos := jpegSegmentData(0xda, 2, "") + "SCANDATA\xff\xd9"
+	idat := pngChunkData("IDAT", 9, "IMAGEDATA")
+	iend := pngChunkData("IEND", 0, "")
+	vp8l := webpChunkData("VP8L", 9, "IMAGEDATA\x00")
+	// ok 为 false 的文件无法完整遍历，清理结果会丢失图像数据，必须原样返回
+	tests := []struct {
+		name    string
+		data    []byte
+		ok      bool
+		payload string // 清理后必须保留的图像数据
+	}{
+		{"jpeg/soi only", []byte("\xff\xd8\xff\xd9"), false, ""},
+		{"jpeg/truncated segment", []byte("\xff\xd8" + jpegSegmentData(0xe1, 100, "Exif\x00\x00MM")), false, ""},
+		{"jpeg/zero length", []byte("\xff\xd8" + jpegSegmentData(0xe1, 0, "") + sos), false, ""},
+		{"jpeg/no scan", []byte("\xff\xd8" + jpegSegmentData(0xe1, 2, "")), false, ""},
+		{"jpeg/minimal length", []byte("\xff\xd8" + jpegSegmentData(0xe1, 2, "") + sos), true, "SCANDATA"},
+		{"jpeg/odd length", []byte("\xff\xd8" + jpegSegmentData(0xe2, 5, "ICC") + sos), true, "SCANDATA"},
+		{"jpeg/truncated exif", []byte("\xff\xd8" + jpegSegmentData(0xe1, 12, "Exif\x00\x00MM\x00*") + sos), true, "SCANDATA"},
+		{"jpeg/exif bad ifd offset", []byte("\xff\xd8" + jpegSegmentData(0xe1, 16, "Exif\x00\x00MM\x00*\xff\xff\xff\xff") + sos), true, "SCANDATA"},
+
+		{"png/signature only", []byte(png), false, ""},
+		{"png/truncated chunk", []byte(png + pngChunkData("IHDR", 13, "abc")[:10]), false, ""},
+		{"png/length beyond data", []byte(png + pngChunkData("eXIf", 1000, "MM")), false, ""},
+		{"png/truncated after idat", []byte(png + idat + pngChunkData("IDAT", 100, "IMAGE")), false, ""},
+		{"png/zero length", []byte(png + pngChunkData("eXIf", 0, "") + pngChunkData("iCCP", 0, "") + pngChunkData("iTXt", 0, "") + idat + iend), true, "IMAGEDATA"},
+		{"png/odd length", []byte(png + pngChunkData("tEXt", 3, "a\x00b") + idat + iend), true, "IMAGEDATA"},
+		{"png/truncated exif", []byte(png + pngChunkData("eXIf", 4, "MM\x00*") + idat + iend), true, "IMAGEDATA"},
+		{"png/truncated iccp", []byte(png + pngChunkData("iCCP", 2, "a\x00") + idat + iend), true, "IMAGEDATA"},
+		{"png/truncated xmp", []byte(png + pngChunkData("iTXt", 18, "XML:com.adobe.xmp\x00") + idat + iend), true, "IMAGEDATA"},
+
+		{"webp/header only", []byte("RIFF\x04\x00\x00\x00WEBP"), false, ""},
+		{"webp/vp8x zero size", []byte("RIFF\x0c\x00\x00\x00WEBPVP8X\x00\x00\x00\x00" + vp8l), false, ""},
+		{"webp/vp8x short", riff(webpChunkData("VP8X", 4, "\x08\x00\x00\x00"), vp8l), false, ""},
+		{"webp/vp8x odd short", riff(webpChunkData("VP8X", 1, "\x08\x00"), vp8l), false, ""},
+		{"webp/truncated chunk", []byte("RIFF\xff\x00\x00\x00WEBP" + vp8l + webpChunkData("EXIF", 100, "MM\x00*")), false, ""},
+		{"webp/chunk header truncated", []byte("RIFF\x00\x00\x00\x00WEBP" + vp8l + "VP8X\x0a\x00"), false, ""},
+		{"webp/zero size chunks", riff(webpChunkData("EXIF", 0, ""), webpChunkData("ICCP", 0, ""), vp8l), true, "IMAGEDATA"},
+		{"webp/odd size without padding", []byte("RIFF\x00\x00\x00\x00WEBP" + vp8l + webpChunkData("XMP ", 3, "abc")), true, "IMAGEDATA"},
+	}
+
+	for _, tt := range tests {
+		for _, keepICC := range []bool{true, false} {
+			out, ok := Sanitize(tt.data, keepICC)
+			if ok != tt.ok {
+				t.Errorf("%s: ok = %v, 期望 %v", tt.name, ok, tt.ok)
+				continue
+			}
+			if !ok {
+				if !bytes.Equal(out, tt.data) {
+					t.Errorf("%s: 无法清理时应原样返回", tt.name)
+				}
+				continue
+			}
+			if !bytes.Equal(out[:2], tt.data[:2]) {
+				t.Errorf("%s: 清理后的文件头不正确: % x", tt.name, out)
+			}
+			if !bytes.Contains(out, []byte(tt.payload)) {
+				t.Errorf("%s: 清理后丢失了图像数据: % x", tt.name, out)
+			}
+			if isWebP(out) {
+				if size := binary.LittleEndian.Uint32(out[4:]); int(size) != len(out)-8 {
+					t.Errorf("%s: RIFF长度 %d 与内容长度 %d 不一致", tt.name, size, len(out)-8)
+				}
+			}
+		}
+	}
+}
+
+func TestSanitizeWebPRemovesMetadata(t *testing.T) {
+	vp8x := "\x2c\x00\x00\x00" + "\x00\x00\x00" + "\x00\x00\x00" // ICC、EXIF、XMP标志
+	data := riff(
+		webpChunkData("VP8X", 10, vp8x),
+		webpChunkData("ICCP", 4, "icc!"),
+		webpChunkData("VP8L", 5, "\x2f\x00\x00\x00\x00\x00"), // 奇数长度带填充字节
+		webpChunkData("EXIF", 4, "MM\x00*"),
+		webpChunkData("XMP ", 3, "xmp\x00"),
+	)
+
+	out, ok := Sanitize(data, false)
+	if !ok {
+		t.Fatal("未识别为WebP")
+	}
+	chunks := webpChunks(out)
+	var fourCCs []string
+	for _, c := range chunks {
+		fourCCs = append(fourCCs, c.fourCC)
+	}
+	if got := join(fourCCs); got != "VP8XVP8L" {
+		t.Fatalf("清理后的数据块为 %q，应为 VP8X 和 VP8L", got)
+	}
+	if flags := chunks[0].payload(out)[0]; flags != 0 {
+		t.Errorf("VP8X标志位应全部清除，实际为 %#x", flags)
+	}
+
+	out, _ = Sanitize(data, true)
+	chunks = webpChunks(out)
+	if len(chunks) != 3 || chunks[1].fourCC != "ICCP" {
+		t.Fatalf("保留ICC时应剩余 VP8X、ICCP 和 VP8L，实际 %d 个数据块", len(chunks))
+	}
+	if flags := chunks[0].payload(out)[0]; flags != 0x20 {
+		t.Errorf("保留ICC时只应保留ICC标志位，实际为 %#x", flags)
+	}
+}
+
+func TestEmbedRoundTrip(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	emb := &Embedded{
+		EXIF: OrientationEXIF(6),
+		ICC:  bytes.Repeat([]byte("icc!"), 20000), // 超过单个APP2段，需要拆分
+		XMP:  []byte("<x:xmpmeta/>"),
+	}
+
+	for _, format := range []string{"jpeg", "png"} {
+		var buf bytes.Buffer
+		if err := Encode(&buf, img, format); err != nil {
+			t.Fatal(err)
+		}
+		out, ok := Embed(buf.Bytes(), emb)
+		if !ok {
+			t.Fatalf("%s: 未识别格式", format)
+		}
+		got := ReadEmbedded(out)
+		if !bytes.Equal(got.ICC, emb.ICC) || !bytes.Equal(got.XMP, emb.XMP) || got.Orientation() != 6 {
+			t.Errorf("%s: 读回的元数据不一致: ICC %d 字节, XMP %q, 方向 %d", format, len(got.ICC), got.XMP, got.Orientation())
+		}
+		if _, _, err := image.Decode(bytes.NewReader(out)); err != nil {
+			t.Errorf("%s: 写入元数据后无法解码: %v", format, err)
+		}
+	}
+}
+
+func TestReadEmbeddedFile(t *testing.T) {
+	dir := t.TempDir()
+	exif := string(OrientationEXIF(6))
+	// 图像数据超过 embeddedHeaderBytes，位于末尾的EXIF和XMP仍要能读到
+	pixels := strings.Repeat("\x00", embeddedHeaderBytes+1)
+	files := map[string][]byte{
+		"a.webp": riff(webpChunkData("ICCP", 4, "icc!"), webpChunkData("VP8L", uint32(len(pixels)), pixels+"\x00"),
+			webpChunkData("EXIF", uint32(len(exif)), exif), webpChunkData("XMP ", 4, "xmp!")),
+		"a.jpg": []byte("\xff\xd8" + jpegSegmentData(0xe1, uint16(len(exif)+8), "Exif\x00\x00"+exif) +
+			jpegSegmentData(0xda, 2, "") + pixels),
+	}
+
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := ReadEmbeddedFile(path)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		want := ReadEmbedded(data)
+		if got.Orientation() != 6 || !bytes.Equal(got.ICC, want.ICC) || !bytes.Equal(got.XMP, want.XMP) {
+			t.Errorf("%s: 读取结果与 ReadEmbedded 不一致: 方向 %d, ICC %q, XMP %q", name, got.Orientation(), got.ICC, got.XMP)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package imaging
+
+import (
+	"image"
+	"image/draw"
+)
+
+// toNRGBA 将图片转换为以 (0,0) 为原点的NRGBA格式
+func toNRGBA(img image.Image) *image.NRGBA {
+	if n, ok := img.(*image.NRGBA); ok && n.Bounds().Min == (image.Point{}) {
+		return n
+	}
+	b := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+	return dst
+}
+
+// transform 按坐标映射函数生成新图片，mapping 将目标坐标映射为源坐标
+func transform(img image.Image, w, h int, mapping func(x, y int) (int, int)) *image.NRGBA {
+	src := toNRGBA(img)
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			sx, sy := mapping(x, y)
+			si := src.PixOffset(sx, sy)
+			di := dst.PixOffset(x, y)
+			copy(dst.Pix[di:di+4], src.Pix[si:si+4])
+		}
+	}
+	return dst
+}
+
+// Rotate90 顺时针旋转90度
+func Rotate90(img image.Image) *image.NRGBA {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	return transform(img, h, w, func(x, y int) (int, int) { return y, h - 1 - x })
+}
+
+// Rotate180 旋转180度
+func Rotate180(img image.Image) *image.NRGBA {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	return transform(img, w, h, func(x, y int) (int, int) { return w - 1 - x, h - 1 - y })
+}
+
+// Rotate270 顺时针旋转270度（即逆时针旋转90度）
+func Rotate270(img image.Image) *image.NRGBA {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	return transform(img, h, w, func(x, y int) (int, int) { return w - 1 - y, x })
+}
+
+// FlipH 水平翻转
+func FlipH(img image.Image) *image.NRGBA {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	return transform(img, w, h, func(x, y int) (int, int) { return w - 1 - x, y })
+}
+
+// FlipV 垂直翻转
+func FlipV(img image.Image) *image.NRGBA {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	return transform(img, w, h, func(x, y int) (int, int) { return x, h - 1 - y })
+}
+
+// Orient 按EXIF方向值(1-8)旋转或翻转图片，使其以正常方向显示
+func Orient(img image.Image, orientation int) image.Image {
+	switch orientation {
+	case 2:
+		return FlipH(img)
+	case 3:
+		return Rotate180(img)
+	case 4:
+		return FlipV(img)
+	case 5:
+		return FlipH(Rotate90(img))
+	case 6:
+		return Rotate90(img)
+	case 7:
+		return FlipH(Rotate270(img))
+	case 8:
+		return Rotate270(img)
+	}
+	return img
+}
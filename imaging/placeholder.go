@@ -0,0 +1,267 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"strings"
+)
+
+// 计算BlurHash和主色调时缩小到的最大边长
+const placeholderSampleSize = 32
+
+// LQIP（低质量占位图）的最大边长
+const lqipSize = 16
+
+// 缩小时每个目标像素在每个方向上最多采样的源像素数
+const shrinkMaxSamples = 4
+
+// BlurHash 的最大分量数
+const blurHashMaxComponents = 4
+
+// Placeholder 图片加载前用于占位的信息
+type Placeholder struct {
+	BlurHash      string // BlurHash字符串
+	LQIP          string // 低质量占位图的 data: URL
+	DominantColor string // 主色调，形如 #rrggbb
+}
+
+// NewPlaceholder 计算图片的BlurHash、LQIP和主色调，透明区域按白色背景处理
+func NewPlaceholder(img image.Image) (*Placeholder, error) {
+	b := img.Bounds()
+	if b.Dx() == 0 || b.Dy() == 0 {
+		return nil, fmt.Errorf("图片尺寸为0")
+	}
+
+	small := shrink(img, placeholderSampleSize)
+	lqip, err := encodeLQIP(shrink(small, lqipSize))
+	if err != nil {
+		return nil, err
+	}
+	return &Placeholder{
+		BlurHash:      blurHash(small),
+		LQIP:          lqip,
+		DominantColor: dominantColor(small),
+	}, nil
+}
+
+// shrink 按区域平均将图片缩小到最大边长不超过 size，较大的区域只均匀采样部分像素
+func shrink(img image.Image, size int) *image.NRGBA {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	tw, th := w, h
+	if w > size || h > size {
+		if w >= h {
+			tw, th = size, max(1, h*size/w)
+		} else {
+			tw, th = max(1, w*size/h), size
+		}
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, tw, th))
+	for y := 0; y < th; y++ {
+		y0, y1 := y*h/th, max((y+1)*h/th, y*h/th+1)
+		for x := 0; x < tw; x++ {
+			x0, x1 := x*w/tw, max((x+1)*w/tw, x*w/tw+1)
+			stepX := max(1, (x1-x0)/shrinkMaxSamples)
+			stepY := max(1, (y1-y0)/shrinkMaxSamples)
+
+			// 按预乘透明度累加，避免透明像素的颜色影响结果
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy += stepY {
+				for sx := x0; sx < x1; sx += stepX {
+					cr, cg, cb, ca := img.At(b.Min.X+sx, b.Min.Y+sy).RGBA()
+					r, g, bl, a = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+
+			i := dst.PixOffset(x, y)
+			if a > 0 {
+				dst.Pix[i] = uint8(r * 0xff / a)
+				dst.Pix[i+1] = uint8(g * 0xff / a)
+				dst.Pix[i+2] = uint8(bl * 0xff / a)
+				dst.Pix[i+3] = uint8(a / n >> 8)
+			}
+		}
+	}
+	return dst
+}
+
+// onWhite 返回像素叠加在白色背景上的颜色
+func onWhite(c color.NRGBA) (r, g, b uint8) {
+	blend := func(v uint8) uint8 {
+		return uint8((int(v)*int(c.A) + 255*(255-int(c.A)) + 127) / 255)
+	}
+	return blend(c.R), blend(c.G), blend(c.B)
+}
+
+// isOpaque 检查图片是否所有像素都不透明
+func isOpaque(img *image.NRGBA) bool {
+	for i := 3; i < len(img.Pix); i += 4 {
+		if img.Pix[i] != 0xff {
+			return false
+		}
+	}
+	return true
+}
+
+// encodeLQIP 将缩小后的图片编码为 data: URL
+// 不透明的图片同时尝试JPEG，使用体积较小的一种；缩小后的图片很小，JPEG的量化表常常比像素数据还大
+func encodeLQIP(img *image.NRGBA) (string, error) {
+	var buf bytes.Buffer
+	encoder := png.Encoder{CompressionLevel: png.BestCompression}
+	if err := encoder.Encode(&buf, img); err != nil {
+		return "", fmt.Errorf("编码LQIP失败: %w", err)
+	}
+	data, mime := buf.Bytes(), "image/png"
+
+	if isOpaque(img) {
+		var jpegBuf bytes.Buffer
+		if err := jpeg.Encode(&jpegBuf, img, &jpeg.Options{Quality: 60}); err == nil && jpegBuf.Len() < len(data) {
+			data, mime = jpegBuf.Bytes(), "image/jpeg"
+		}
+	}
+	return "data:" + mime + ";base64," + base64.StdEncoding.EncodeToString(data), nil
+}
+
+// dominantColor 将颜色按每通道4位量化后统计，返回像素最多的颜色桶的平均颜色
+// 透明度低于一半的像素不参与统计，全部透明时返回白色
+func dominantColor(img *image.NRGBA) string {
+	type bucket struct {
+		r, g, b, n int
+	}
+	buckets := make(map[int]*bucket)
+	var best *bucket
+	for i := 0; i < len(img.Pix); i += 4 {
+		if img.Pix[i+3] < 0x80 {
+			continue
+		}
+		r, g, b := onWhite(color.NRGBA{img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3]})
+		key := int(r>>4)<<8 | int(g>>4)<<4 | int(b>>4)
+		bk := buckets[key]
+		if bk == nil {
+			bk = &bucket{}
+			buckets[key] = bk
+		}
+		bk.r, bk.g, bk.b, bk.n = bk.r+int(r), bk.g+int(g), bk.b+int(b), bk.n+1
+		if best == nil || bk.n > best.n {
+			best = bk
+		}
+	}
+	if best == nil {
+		return "#ffffff"
+	}
+	return fmt.Sprintf("#%02x%02x%02x", best.r/best.n, best.g/best.n, best.b/best.n)
+}
+
+// base83Chars BlurHash使用的base83字符集
+const base83Chars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// blurHash 按BlurHash算法计算图片的DCT分量并编码，长边使用4个分量，短边按比例减少
+func blurHash(img *image.NRGBA) string {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	cx, cy := blurHashMaxComponents, blurHashMaxComponents
+	if w > h {
+		cy = max(1, min(blurHashMaxComponents, (blurHashMaxComponents*h+w/2)/w))
+	} else if h > w {
+		cx = max(1, min(blurHashMaxComponents, (blurHashMaxComponents*w+h/2)/h))
+	}
+
+	// 预先将像素转换为线性RGB
+	linear := make([][3]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := img.PixOffset(x, y)
+			r, g, b := onWhite(color.NRGBA{img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3]})
+			linear[y*w+x] = [3]float64{srgbToLinear(r), srgbToLinear(g), srgbToLinear(b)}
+		}
+	}
+
+	factors := make([][3]float64, 0, cx*cy)
+	for j := 0; j < cy; j++ {
+		for i := 0; i < cx; i++ {
+			normalisation := 2.0
+			if i == 0 && j == 0 {
+				normalisation = 1
+			}
+			var f [3]float64
+			for y := 0; y < h; y++ {
+				for x := 0; x < w; x++ {
+					basis := math.Cos(math.Pi*float64(i*x)/float64(w)) * math.Cos(math.Pi*float64(j*y)/float64(h))
+					p := linear[y*w+x]
+					f[0] += basis * p[0]
+					f[1] += basis * p[1]
+					f[2] += basis * p[2]
+				}
+			}
+			scale := normalisation / float64(w*h)
+			factors = append(factors, [3]float64{f[0] * scale, f[1] * scale, f[2] * scale})
+		}
+	}
+
+	var sb strings.Builder
+	writeBase83(&sb, (cx-1)+(cy-1)*9, 1)
+
+	maximumValue := 1.0
+	if len(factors) > 1 {
+		actualMax := 0.0
+		for _, f := range factors[1:] {
+			actualMax = max(actualMax, math.Abs(f[0]), math.Abs(f[1]), math.Abs(f[2]))
+		}
+		quantisedMax := int(max(0, min(82, math.Floor(actualMax*166-0.5))))
+		maximumValue = float64(quantisedMax+1) / 166
+		writeBase83(&sb, quantisedMax, 1)
+	} else {
+		writeBase83(&sb, 0, 1)
+	}
+
+	dc := factors[0]
+	writeBase83(&sb, linearToSRGB(dc[0])<<16|linearToSRGB(dc[1])<<8|linearToSRGB(dc[2]), 4)
+	for _, f := range factors[1:] {
+		quant := func(v float64) int {
+			return int(max(0, min(18, math.Floor(signPow(v/maximumValue, 0.5)*9+9.5))))
+		}
+		writeBase83(&sb, quant(f[0])*19*19+quant(f[1])*19+quant(f[2]), 2)
+	}
+	return sb.String()
+}
+
+// writeBase83 将数值编码为指定长度的base83字符串
+func writeBase83(sb *strings.Builder, value, length int) {
+	for i := 1; i <= length; i++ {
+		digit := value
+		for j := 0; j < length-i; j++ {
+			digit /= 83
+		}
+		sb.WriteByte(base83Chars[digit%83])
+	}
+}
+
+// srgbToLinear 将 0-255 的sRGB分量转换为 0-1 的线性值
+func srgbToLinear(v uint8) float64 {
+	c := float64(v) / 255
+	if c <= 0.04045 {
+		return c / 12.92
+	}
+	return math.Pow((c+0.055)/1.055, 2.4)
+}
+
+// linearToSRGB 将 0-1 的线性值转换为 0-255 的sRGB分量
+func linearToSRGB(v float64) int {
+	c := max(0, min(1, v))
+	if c <= 0.0031308 {
+		return int(c*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(c, 1/2.4)-0.055)*255 + 0.5)
+}
+
+// signPow 保留符号的幂运算
+func signPow(v, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(v), exp), v)
+}
@@ -0,0 +1,87 @@
+package imaging
+
+import (
+	"fmt"
+	"image"
+)
+
+// 计算SSIM时亮度平面的最大边长，超过时先按整数倍缩小
+const ssimMaxSize = 1024
+
+// SSIM 计算两张相同尺寸图片亮度通道的平均结构相似度，取值范围 (-1, 1]，1 表示完全相同
+func SSIM(a, b image.Image) (float64, error) {
+	if a.Bounds().Dx() != b.Bounds().Dx() || a.Bounds().Dy() != b.Bounds().Dy() {
+		return 0, fmt.Errorf("图片尺寸不一致: %v 和 %v", a.Bounds().Size(), b.Bounds().Size())
+	}
+
+	la, w, h := lumaPlane(a)
+	lb, _, _ := lumaPlane(b)
+
+	const (
+		window = 8
+		stride = 4
+		c1     = (0.01 * 255) * (0.01 * 255)
+		c2     = (0.03 * 255) * (0.03 * 255)
+	)
+
+	// 图片比窗口还小时直接把整张图当作一个窗口
+	winW, winH := min(window, w), min(window, h)
+	n := float64(winW * winH)
+
+	var total float64
+	var count int
+	for y := 0; y+winH <= h; y += stride {
+		for x := 0; x+winW <= w; x += stride {
+			var sumA, sumB, sumAA, sumBB, sumAB float64
+			for wy := y; wy < y+winH; wy++ {
+				row := wy * w
+				for wx := x; wx < x+winW; wx++ {
+					va, vb := la[row+wx], lb[row+wx]
+					sumA += va
+					sumB += vb
+					sumAA += va * va
+					sumBB += vb * vb
+					sumAB += va * vb
+				}
+			}
+			meanA, meanB := sumA/n, sumB/n
+			varA := sumAA/n - meanA*meanA
+			varB := sumBB/n - meanB*meanB
+			cov := sumAB/n - meanA*meanB
+
+			total += ((2*meanA*meanB + c1) * (2*cov + c2)) /
+				((meanA*meanA + meanB*meanB + c1) * (varA + varB + c2))
+			count++
+		}
+	}
+
+	if count == 0 {
+		return 1, nil
+	}
+	return total / float64(count), nil
+}
+
+// lumaPlane 提取图片的亮度平面，较大的图片按整数倍平均缩小以控制计算量
+func lumaPlane(img image.Image) ([]float64, int, int) {
+	b := img.Bounds()
+	factor := 1
+	for max(b.Dx(), b.Dy())/factor > ssimMaxSize {
+		factor++
+	}
+
+	w, h := b.Dx()/factor, b.Dy()/factor
+	plane := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var sum float64
+			for dy := 0; dy < factor; dy++ {
+				for dx := 0; dx < factor; dx++ {
+					r, g, bl, _ := img.At(b.Min.X+x*factor+dx, b.Min.Y+y*factor+dy).RGBA()
+					sum += 0.299*float64(r>>8) + 0.587*float64(g>>8) + 0.114*float64(bl>>8)
+				}
+			}
+			plane[y*w+x] = sum / float64(factor*factor)
+		}
+	}
+	return plane, w, h
+}
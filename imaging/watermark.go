@@ -0,0 +1,114 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// Resize 使用双线性插值将图片缩放到指定尺寸，按预乘透明度插值避免透明边缘发暗
+func Resize(img image.Image, w, h int) *image.NRGBA {
+	src := toNRGBA(img)
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	if sw == 0 || sh == 0 || w <= 0 || h <= 0 {
+		return dst
+	}
+
+	// 缩小倍数较大时双线性插值会丢失细节，先按区域平均缩小到目标尺寸的两倍左右
+	if sw > 2*w && sh > 2*h {
+		src = shrink(src, 2*max(w, h))
+		sw, sh = src.Bounds().Dx(), src.Bounds().Dy()
+	}
+
+	for y := 0; y < h; y++ {
+		fy := max(0, (float64(y)+0.5)*float64(sh)/float64(h)-0.5)
+		y0 := min(int(fy), sh-1)
+		y1 := min(y0+1, sh-1)
+		wy := fy - float64(y0)
+		for x := 0; x < w; x++ {
+			fx := max(0, (float64(x)+0.5)*float64(sw)/float64(w)-0.5)
+			x0 := min(int(fx), sw-1)
+			x1 := min(x0+1, sw-1)
+			wx := fx - float64(x0)
+
+			var acc [4]float64
+			for _, s := range [4]struct {
+				x, y int
+				w    float64
+			}{
+				{x0, y0, (1 - wx) * (1 - wy)},
+				{x1, y0, wx * (1 - wy)},
+				{x0, y1, (1 - wx) * wy},
+				{x1, y1, wx * wy},
+			} {
+				p := src.Pix[src.PixOffset(s.x, s.y):]
+				a := float64(p[3]) * s.w
+				acc[0] += float64(p[0]) * a
+				acc[1] += float64(p[1]) * a
+				acc[2] += float64(p[2]) * a
+				acc[3] += a
+			}
+
+			if acc[3] > 0 {
+				i := dst.PixOffset(x, y)
+				dst.Pix[i] = uint8(math.Round(acc[0] / acc[3]))
+				dst.Pix[i+1] = uint8(math.Round(acc[1] / acc[3]))
+				dst.Pix[i+2] = uint8(math.Round(acc[2] / acc[3]))
+				dst.Pix[i+3] = uint8(math.Round(acc[3]))
+			}
+		}
+	}
+	return dst
+}
+
+// RenderText 将一行文字绘制到刚好容纳它的透明图片上
+func RenderText(text string, face font.Face, c color.Color) *image.NRGBA {
+	metrics := face.Metrics()
+	width := font.MeasureString(face, text).Ceil()
+	height := (metrics.Ascent + metrics.Descent).Ceil()
+	dst := image.NewNRGBA(image.Rect(0, 0, max(1, width), max(1, height)))
+
+	drawer := &font.Drawer{
+		Dst:  dst,
+		Src:  image.NewUniform(c),
+		Face: face,
+		Dot:  fixed.Point26_6{X: 0, Y: metrics.Ascent},
+	}
+	drawer.DrawString(text)
+	return dst
+}
+
+// Overlay 按位置和不透明度将水印叠加到图片上，返回新图片，原图不变
+// margin 为水印与边缘的像素距离，水印超出图片时会被裁剪
+func Overlay(img image.Image, mark image.Image, position string, opacity float64, margin int) *image.NRGBA {
+	b := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+
+	w, h := dst.Bounds().Dx(), dst.Bounds().Dy()
+	mw, mh := mark.Bounds().Dx(), mark.Bounds().Dy()
+
+	x, y := (w-mw)/2, (h-mh)/2
+	switch position {
+	case "top-left", "left", "bottom-left":
+		x = margin
+	case "top-right", "right", "bottom-right":
+		x = w - mw - margin
+	}
+	switch position {
+	case "top-left", "top", "top-right":
+		y = margin
+	case "bottom-left", "bottom", "bottom-right":
+		y = h - mh - margin
+	}
+
+	alpha := uint8(math.Round(max(0, min(1, opacity)) * 255))
+	rect := image.Rect(x, y, x+mw, y+mh)
+	draw.DrawMask(dst, rect, mark, mark.Bounds().Min, image.NewUniform(color.Alpha{A: alpha}), image.Point{}, draw.Over)
+	return dst
+}
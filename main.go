@@ -1,41 +1,62 @@
 package main
 
 import (
-	"bytes"
+	"bufio"
+	"errors"
 	"fmt"
-	"image/gif"
 	"io"
 	"log"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 
 	// Import our local config package
 	cfg "github.com/suixinio/webp-img/config"
+	"github.com/suixinio/webp-img/imaging"
 	"github.com/suixinio/webp-img/security"
+	"github.com/suixinio/webp-img/storage"
 )
 
 // 全局配置
 var config *cfg.Config
 
+// 图片元数据存储
+var metaStore *storage.Store
+
 func main() {
 	// 加载配置
 	config = cfg.LoadConfig()
+	metaStore = storage.NewStore(config.MetaDir)
+	remoteClient = security.NewRemoteClient(config)
+	conversionSlots = make(chan struct{}, config.UploadWorkers)
+
+	// 子命令（backup、restore、verify）执行完成后直接退出，不启动服务器
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
 
 	// 如果启用了自动转换现有图片功能，则启动转换
 	if config.ConvertExistingImages {
 		go convertExistingImages()
 	}
 
+	// 定期清理过期的可续传上传
+	go cleanExpiredUploads()
+
 	// 设置Gin路由器
 	router := gin.Default()
 
+	// 跨域中间件需在认证之前执行，预检请求不携带Cookie
+	router.Use(security.CORSMiddleware(config))
+
 	// 加载HTML模板，但排除css目录
 	router.LoadHTMLGlob(filepath.Join(config.TemplateDir, "*.html"))
 
@@ -47,13 +68,44 @@ func main() {
 	router.GET("/", security.AuthMiddleware(config), homeHandler)
 	router.GET("/gallery", security.AuthMiddleware(config), galleryHandler)
 	router.GET("/api/images", security.AuthMiddleware(config), listImagesHandler)
+	router.GET("/api/images/*path", security.AuthMiddleware(config), imageAPIGetHandler)
+	router.GET("/api/export", security.AuthMiddleware(config), exportDirHandler)
+	router.POST("/api/export", security.AuthMiddleware(config), exportSelectionHandler)
+	router.POST("/api/images/*path", security.AuthMiddleware(config), imageAPIPostHandler)
 	router.POST("/upload", security.AuthMiddleware(config), uploadHandler)
+	router.POST("/api/upload/url", security.AuthMiddleware(config), uploadURLHandler)
+	router.POST("/api/upload/archive", security.AuthMiddleware(config), archiveUploadHandler)
+	router.GET("/api/admin/verify", security.AuthMiddleware(config), verifyStatusHandler)
+	router.POST("/api/admin/verify", security.AuthMiddleware(config), verifyStartHandler)
+	router.OPTIONS("/api/images", optionsHandler) // 跨域预检由CORS中间件响应
+	router.OPTIONS("/api/export", optionsHandler)
+	router.OPTIONS("/upload", optionsHandler)
+	router.OPTIONS("/api/upload/url", optionsHandler)
+	router.OPTIONS("/api/upload/archive", optionsHandler)
+	router.OPTIONS("/api/admin/verify", optionsHandler)
+
+	// tus 可续传上传，OPTIONS 用于客户端查询服务器支持的协议，无需权限校验
+	router.OPTIONS("/api/upload/tus", tusVersionMiddleware, tusOptionsHandler)
+	router.OPTIONS("/api/upload/tus/:id", tusVersionMiddleware, tusOptionsHandler)
+	tus := router.Group("/api/upload/tus", security.AuthMiddleware(config), tusVersionMiddleware)
+	tus.POST("", tusCreateHandler)
+	tus.HEAD("/:id", tusHeadHandler)
+	tus.PATCH("/:id", tusPatchHandler)
+	tus.DELETE("/:id", tusDeleteHandler)
+	tus.GET("/:id", tusStatusHandler) // JSON格式的进度和结果，不属于tus协议
+
 	router.GET("/download/webp/*filename", downloadWebpHandler)  // 下载WebP图片，无需权限校验
-	router.HEAD("/download/webp/*filename", downloadWebpHandler) // 支持HEAD请求，处理预检请求
+	router.HEAD("/download/webp/*filename", downloadWebpHandler) // 支持HEAD请求，用于获取文件信息而不下载内容
 	router.GET("/img/*filename", imageHandler)                   // 保留原有的/img/路径用于向后兼容
+	router.HEAD("/img/*filename", imageHandler)
+
+	// 动画GIF的视频版本，无需权限校验
+	router.GET("/video/*filename", videoHandler)
+	router.HEAD("/video/*filename", videoHandler)
 
-	// 设置静态文件服务
-	router.Static("/uploads", config.UploadDir)
+	// 设置静态文件服务，元数据目录和可续传上传的临时目录不对外公开
+	uploads := router.Group("/uploads", hidePrivateDirsMiddleware, security.SVGHeadersMiddleware)
+	uploads.Static("/", config.UploadDir)
 
 	// 设置CSS静态文件服务
 	router.Static("/css", filepath.Join(config.TemplateDir, "css"))
@@ -146,8 +198,30 @@ func loginHandler(c *gin.Context) {
 	})
 }
 
+// hidePrivateDirsMiddleware 阻止通过静态文件服务访问元数据目录、历史版本和未完成的可续传上传
+func hidePrivateDirsMiddleware(c *gin.Context) {
+	requested := filepath.Join(config.UploadDir, filepath.Clean("/"+c.Param("filepath")))
+	for _, dir := range []string{config.MetaDir, config.VersionDir, config.ChunkDir} {
+		if rel, err := filepath.Rel(dir, requested); err == nil && rel != ".." && !strings.HasPrefix(rel, "../") {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+	}
+	c.Next()
+}
+
+// optionsHandler 响应非预检的OPTIONS请求，返回路由支持的方法
+func optionsHandler(c *gin.Context) {
+	c.Header("Allow", strings.Join(config.CORSAllowedMethods, ", "))
+	c.Status(http.StatusNoContent)
+}
+
 func homeHandler(c *gin.Context) {
-	c.HTML(http.StatusOK, "index.html", nil)
+	// 网页按上传限制拆分批量上传的请求
+	c.HTML(http.StatusOK, "index.html", gin.H{
+		"uploadMaxFiles": config.UploadMaxFiles,
+		"uploadMaxBody":  config.UploadMaxBody,
+	})
 }
 
 // galleryHandler 处理画廊页面的请求
@@ -157,11 +231,26 @@ func galleryHandler(c *gin.Context) {
 
 // ImageInfo 存储图片信息的结构体
 type ImageInfo struct {
-	URL          string `json:"url"`          // 图片URL
-	ThumbnailURL string `json:"thumbnailUrl"` // 缩略图URL (这里使用同一URL)
-	OriginalName string `json:"originalName"` // 原始文件名
-	UploadDate   string `json:"uploadDate"`   // 上传日期
-	Directory    string `json:"directory"`    // 目录路径
+	URL           string `json:"url"`                     // 图片URL
+	ThumbnailURL  string `json:"thumbnailUrl"`            // 缩略图URL (这里使用同一URL)
+	OriginalName  string `json:"originalName"`            // 原始文件名
+	UploadDate    string `json:"uploadDate"`              // 上传日期
+	Directory     string `json:"directory"`               // 目录路径
+	MetaURL       string `json:"metaUrl"`                 // 图片元数据接口地址
+	Srcset        string `json:"srcset,omitempty"`        // 各宽度版本的srcset属性
+	PictureHTML   string `json:"pictureHtml,omitempty"`   // 可直接粘贴的<picture>代码
+	BlurHash      string `json:"blurhash,omitempty"`      // 加载前占位用的BlurHash
+	LQIP          string `json:"lqip,omitempty"`          // 低质量占位图的 data: URL
+	DominantColor string `json:"dominantColor,omitempty"` // 主色调
+}
+
+// setPlaceholder 从元数据中读取图片的占位信息
+func setPlaceholder(info *ImageInfo, relPath string) {
+	if meta, err := metaStore.Load(relPath); err == nil {
+		info.BlurHash = meta.BlurHash
+		info.LQIP = meta.LQIP
+		info.DominantColor = meta.DominantColor
+	}
 }
 
 // DirectoryInfo 存储目录信息的结构体
@@ -248,14 +337,49 @@ func listImagesHandler(c *gin.Context) {
 					// 从文件名中提取上传日期（假设文件名格式为 timestamp.webp）
 					UploadDate: formatTimestampFromFilename(file.Name()),
 					Directory:  dir,
+					MetaURL:    fmt.Sprintf("/api/images/%s/meta", filepath.ToSlash(imagePath)),
 				}
 
+				if responsive := buildResponsive(imagePath, filepath.Join(baseDir, file.Name())); responsive != nil {
+					imgInfo.Srcset = responsive.Srcset
+					imgInfo.PictureHTML = responsive.HTML
+				}
+				setPlaceholder(&imgInfo, imagePath)
+
 				// 添加到当前目录的图片列表
 				currentDir.Images = append(currentDir.Images, imgInfo)
 			}
 		}
 	}
 
+	// 未栅格化的SVG没有WebP版本，直接列出清理后的原图
+	if picsFiles, err := os.ReadDir(filepath.Join(config.PicsDir, filepath.Clean("/"+dir))); err == nil {
+		for _, file := range picsFiles {
+			ext := strings.ToLower(filepath.Ext(file.Name()))
+			if file.IsDir() || ext != ".svg" {
+				continue
+			}
+			webpName := strings.TrimSuffix(file.Name(), filepath.Ext(file.Name())) + ".webp"
+			if isRegularFile(filepath.Join(baseDir, webpName)) {
+				continue
+			}
+
+			imagePath := file.Name()
+			if dir != "" {
+				imagePath = filepath.Join(dir, file.Name())
+			}
+			imgURL := fmt.Sprintf("/img/%s", imagePath)
+			currentDir.Images = append(currentDir.Images, ImageInfo{
+				URL:          imgURL,
+				ThumbnailURL: imgURL,
+				OriginalName: file.Name(),
+				UploadDate:   formatTimestampFromFilename(file.Name()),
+				Directory:    dir,
+				MetaURL:      fmt.Sprintf("/api/images/%s/meta", filepath.ToSlash(imagePath)),
+			})
+		}
+	}
+
 	// 如果请求的是根目录，则返回所有子目录和根目录下的图片
 	if dir == "" {
 		c.JSON(http.StatusOK, gin.H{
@@ -297,92 +421,235 @@ func formatTimestampFromFilename(filename string) string {
 }
 
 func uploadHandler(c *gin.Context) {
-	// 从表单获取文件
-	file, header, err := c.Request.FormFile("image")
+	// 先在限制大小和文件数的情况下解析表单，读取编码参数时也会解析表单
+	var form *multipart.Form
+	if c.ContentType() == "multipart/form-data" {
+		var err error
+		if form, err = parseUploadForm(c); err != nil {
+			respondUploadError(c, err)
+			return
+		}
+	}
+
+	// 读取本次上传的编码参数，未指定时使用默认配置
+	encodeOpts, err := parseEncodeOptions(c)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"status":  "error",
-			"message": "获取文件错误",
+			"message": err.Error(),
 		})
 		return
 	}
-	defer file.Close()
 
+	// 除表单字段 image 外，也接受原始图片请求体和JSON中base64编码的图片
+	var src *uploadSource
+	switch {
+	case isFormRequest(c):
+		// 从表单获取文件，包含多个文件或要求逐个返回结果时按批量上传处理
+		var headers []*multipart.FileHeader
+		if form != nil {
+			headers = form.File["image"]
+		}
+		if len(headers) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"status":  "error",
+				"message": "获取文件错误",
+			})
+			return
+		}
+		if len(headers) > 1 || wantsBatchResponse(c) {
+			batchUploadHandler(c, headers, encodeOpts)
+			return
+		}
+
+		file, err := headers[0].Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"status":  "error",
+				"message": "获取文件错误",
+			})
+			return
+		}
+		defer file.Close()
+		src = &uploadSource{
+			Reader:      file,
+			Filename:    headers[0].Filename,
+			ContentType: headers[0].Header.Get("Content-Type"),
+		}
+	case c.ContentType() == "application/json":
+		if src, err = base64UploadSource(c); err != nil {
+			respondUploadError(c, err)
+			return
+		}
+	default:
+		if src, err = rawUploadSource(c); err != nil {
+			respondUploadError(c, err)
+			return
+		}
+	}
+
+	response, err := processUpload(src, encodeOpts)
+	if err != nil {
+		respondUploadError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// uploadSource 待保存的上传内容，来自表单文件、远程URL等
+type uploadSource struct {
+	Reader      io.Reader
+	Filename    string // 原始文件名，可以为空
+	ContentType string // 客户端声明的内容类型，可以为空
+	SourceURL   string // 从远程URL获取时的地址
+	Dir         string // 日期目录下的子目录，为空表示直接保存在日期目录中
+}
+
+// uploadError 上传失败的原因和返回给客户端的状态码
+type uploadError struct {
+	Status  int
+	Message string
+}
+
+func (e *uploadError) Error() string {
+	return e.Message
+}
+
+// respondUploadError 按上传接口的格式返回错误
+func respondUploadError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	var uploadErr *uploadError
+	if errors.As(err, &uploadErr) {
+		status = uploadErr.Status
+	}
+	c.JSON(status, gin.H{
+		"status":  "error",
+		"message": err.Error(),
+	})
+}
+
+// processUpload 保存上传的图片并转换为WebP，返回上传接口的响应内容
+// 失败时返回 *uploadError
+func processUpload(src *uploadSource, encodeOpts *EncodeOptions) (gin.H, error) {
 	// 验证文件类型
-	contentType := header.Header.Get("Content-Type")
+	// 部分浏览器不认识HEIC/JPEG XL等格式，会以 application/octet-stream 上传，此时按扩展名判断
+	// 扩展名也无法判断时（例如原始请求体、剪贴板数据）根据文件头识别
+	reader := src.Reader
+	contentType := src.ContentType
+	fileExt := filepath.Ext(src.Filename)
 	if !strings.HasPrefix(contentType, "image/") {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"status":  "error",
-			"message": "文件不是图片",
-		})
-		return
+		if isSupportedImage(fileExt) {
+			contentType = contentTypeByExt(fileExt)
+		} else {
+			buffered := bufio.NewReader(reader)
+			header, _ := buffered.Peek(12)
+			format := sniffImageHeader(header)
+			if format == "" {
+				return nil, &uploadError{http.StatusBadRequest, "文件不是图片"}
+			}
+			reader = buffered
+			contentType = "image/" + format
+		}
 	}
 
-	// 获取文件扩展名
-	fileExt := filepath.Ext(header.Filename)
+	// 获取文件扩展名，文件名中的扩展名不是图片格式时根据内容类型推断
+	if !isSupportedImage(fileExt) {
+		fileExt = ""
+	}
 	if fileExt == "" {
 		// 如果文件名没有扩展名，根据内容类型推断
-		switch contentType {
-		case "image/jpeg":
-			fileExt = ".jpg"
-		case "image/png":
-			fileExt = ".png"
-		case "image/gif":
-			fileExt = ".gif"
-		default:
+		fileExt = extByContentType(contentType)
+		if fileExt == "" {
 			fileExt = ".jpg" // 默认扩展名
 		}
 	}
 
 	// 生成文件路径：按YY/MM/DD目录结构，使用时间戳命名
-	originalPath, webpPath, relativePath, err := generatePaths(fileExt)
+	originalPath, webpPath, relativePath, err := generatePathsIn(src.Dir, fileExt)
 	if err != nil {
 		log.Printf("生成文件路径失败: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"status":  "error",
-			"message": "生成文件路径失败",
-		})
-		return
+		return nil, &uploadError{http.StatusInternalServerError, "生成文件路径失败"}
 	}
 
 	// 保存原始文件
 	dst, err := os.Create(originalPath)
 	if err != nil {
 		log.Printf("创建目标文件失败: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"status":  "error",
-			"message": "创建目标文件失败",
-		})
-		return
+		return nil, &uploadError{http.StatusInternalServerError, "创建目标文件失败"}
 	}
-	defer dst.Close()
 
-	// 复制文件内容
-	if _, err = io.Copy(dst, file); err != nil {
+	// 复制文件内容，读取时的错误（例如远程文件超过大小限制）原样返回
+	_, err = io.Copy(dst, reader)
+	dst.Close()
+	if err != nil {
 		log.Printf("保存文件失败: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"status":  "error",
-			"message": "保存文件失败",
-		})
-		return
+		os.Remove(originalPath)
+		var uploadErr *uploadError
+		if errors.As(err, &uploadErr) {
+			return nil, uploadErr
+		}
+		return nil, &uploadError{http.StatusInternalServerError, "保存文件失败"}
+	}
+
+	// SVG可能包含脚本和外部引用，并且会从同源直接提供，必须先清理
+	if strings.EqualFold(fileExt, ".svg") {
+		if err := sanitizeSVGFile(originalPath); err != nil {
+			log.Printf("清理SVG失败: %v", err)
+			os.Remove(originalPath)
+			return nil, &uploadError{http.StatusBadRequest, "SVG文件无效"}
+		}
+	}
+
+	// 按元数据策略清理原图中的位置等隐私信息，原图可通过 /uploads 直接访问
+	if config.SanitizeOriginals {
+		if err := sanitizeFile(originalPath); err != nil {
+			log.Printf("清理原始图片元数据失败: %v", err)
+		}
 	}
 
 	// 获取原始文件大小
 	originalInfo, err := os.Stat(originalPath)
 	if err != nil {
 		log.Printf("获取原始文件信息失败: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"status":  "error",
-			"message": "获取文件信息失败",
-		})
-		return
+		return nil, &uploadError{http.StatusInternalServerError, "获取文件信息失败"}
 	}
 	originalSize := originalInfo.Size()
+	checksum, err := fileSHA256(originalPath)
+	if err != nil {
+		log.Printf("计算原始文件校验和失败: %v", err)
+	}
 
-	// 转换为WebP并保存
-	if err := convertToWebP(originalPath, webpPath); err != nil {
-		log.Printf("转换为WebP失败: %v", err)
-		// 即使WebP转换失败，我们也会继续处理
+	// 记录原始文件名等元数据，供下载时使用；没有文件名时使用生成的文件名
+	originalName := filepath.Base(src.Filename)
+	if src.Filename == "" {
+		originalName = filepath.Base(relativePath)
+	}
+	if err := metaStore.Save(relativePath, &storage.Metadata{
+		OriginalName: originalName,
+		ContentType:  contentType,
+		OriginalSize: originalSize,
+		UploadedAt:   time.Now(),
+		SourceURL:    src.SourceURL,
+		SHA256:       checksum,
+	}); err != nil {
+		log.Printf("保存图片元数据失败: %v", err)
+	}
+
+	// 转换为WebP并保存，通过转换池限制同时进行的转换数量
+	var convertResult *ConvertResult
+	withConversionSlot(func() {
+		convertResult, err = convertToWebP(originalPath, webpPath, encodeOpts)
+		if err != nil {
+			log.Printf("转换为WebP失败: %v", err)
+			// 即使WebP转换失败，我们也会继续处理
+		}
+		recordConversion(relativePath, convertResult)
+		// 按配置预先生成响应式宽度版本
+		generateResponsive(originalPath, webpPath, convertResult)
+	})
+	// 动画GIF的视频版本在后台生成，不占用上传的转换时间
+	if isAnimatedGIF(originalPath) {
+		scheduleVideos(originalPath, webpPath)
 	}
 
 	// 获取WebP文件大小
@@ -417,7 +684,7 @@ func uploadHandler(c *gin.Context) {
 	}
 
 	// 返回所有URL和Markdown格式给客户端
-	c.JSON(http.StatusOK, gin.H{
+	response := gin.H{
 		"status":             "success",
 		"url":                imgURL,                       // 传统URL (向后兼容)
 		"original_size":      originalSize,                 // 原始图片大小（字节）
@@ -426,7 +693,39 @@ func uploadHandler(c *gin.Context) {
 		"webp_size_text":     formatFileSize(webpSize),     // WebP图片大小（人类可读格式）
 		"compression_ratio":  compressionRatio,             // 压缩比例（百分比）
 		"message":            "图片已成功上传并转换",
-	})
+	}
+	if convertResult != nil {
+		response["quality"] = convertResult.Encode.Quality       // 实际使用的压缩质量
+		response["lossless"] = convertResult.Encode.isLossless() // 是否无损或近无损压缩
+		if convertResult.Searched {
+			response["target_met"] = convertResult.TargetMet // 是否达到目标SSIM或体积上限
+			if convertResult.Encode.TargetSSIM > 0 {
+				response["ssim"] = convertResult.SSIM // 质量搜索测得的SSIM
+			}
+		}
+	}
+	// 返回加载前使用的占位信息
+	if meta, err := metaStore.Load(relativePath); err == nil && meta.BlurHash != "" {
+		response["blurhash"] = meta.BlurHash
+		response["lqip"] = meta.LQIP
+		response["dominant_color"] = meta.DominantColor
+	}
+	// 静态图片返回可直接粘贴的srcset和<picture>代码
+	if responsive := buildResponsive(relativePath, webpPath); responsive != nil {
+		response["srcset"] = responsive.Srcset
+		response["sizes"] = responsive.Sizes
+		response["width"] = responsive.Width
+		response["height"] = responsive.Height
+		response["picture_html"] = responsive.HTML
+	}
+	// 动画GIF额外返回静态海报帧和视频版本的地址，供聊天预览、RSS阅读器、<video>标签等使用
+	if isAnimatedGIF(originalPath) {
+		response["poster_url"] = imgURL + "?animated=false"
+		if urls := scheduledVideoURLs(webpPath); len(urls) > 0 {
+			response["video_urls"] = urls
+		}
+	}
+	return response, nil
 }
 
 func imageHandler(c *gin.Context) {
@@ -452,6 +751,17 @@ func imageHandler(c *gin.Context) {
 
 	log.Printf("请求路径: %s, 原始文件路径: %s, WebP文件路径: %s", filePath, originalPath, webpPath)
 
+	// v 指定版本号时提供该历史版本，不会即时生成任何文件
+	// 历史版本可能包含编辑时裁剪掉的内容，与 /api/images 相同需要登录
+	if v := c.Query("v"); v != "" {
+		if !security.Authenticated(c, config) {
+			c.Redirect(http.StatusSeeOther, "/login")
+			return
+		}
+		serveVersion(c, webpPath, v, false)
+		return
+	}
+
 	// 检查WebP是否存在
 	webpExists := false
 	if _, err := os.Stat(webpPath); err == nil {
@@ -461,14 +771,8 @@ func imageHandler(c *gin.Context) {
 	// 检查原始文件是否为GIF且是否为动画
 	isAnimatedGif := false
 	if ext == ".gif" {
-		if _, err := os.Stat(originalPath); err == nil {
-			// 检查是否为动画GIF
-			if gifData, err := os.ReadFile(originalPath); err == nil {
-				if gifImg, err := gif.DecodeAll(bytes.NewReader(gifData)); err == nil {
-					isAnimatedGif = len(gifImg.Image) > 1
-				}
-			}
-		}
+		// 只扫描数据块头部，不将整个文件读入内存
+		isAnimatedGif = isAnimatedGIF(originalPath)
 	}
 
 	// 如果WebP不存在但原始文件存在，则即时生成WebP
@@ -480,40 +784,115 @@ func imageHandler(c *gin.Context) {
 			} else {
 				// 原始文件存在，生成WebP版本
 				log.Printf("未找到 %s 的WebP版本，正在即时生成", filePath)
-				if err := convertToWebP(originalPath, webpPath); err != nil {
+				if result, err := convertToWebP(originalPath, webpPath, nil); err != nil {
 					log.Printf("即时生成WebP失败: %v", err)
 					// 即使WebP转换失败，我们也会继续提供原始图片
 				} else {
-					webpExists = true // WebP已成功创建
+					// 未启用栅格化的SVG不会生成WebP
+					webpExists = isRegularFile(webpPath)
+					recordConversion(filePath, result)
+				}
+			}
+		}
+	}
+
+	// wm 指定水印预设时提供加了该水印的版本，不存在时即时生成；可与配置中的响应式宽度 w 组合
+	// 动画和未栅格化的SVG不支持水印，按普通请求处理
+	if name := c.Query("wm"); name != "" {
+		if _, ok := config.WatermarkPresets[name]; !ok {
+			c.String(http.StatusBadRequest, "水印预设不存在")
+			return
+		}
+		files, ok := resolveImage(filePath)
+		if !ok {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		width, _ := strconv.Atoi(c.Query("w"))
+		if !isResponsiveWidth(width) {
+			width = 0
+		}
+		path, err := ensureWatermarked(files, webpPath, name, width)
+		if err == nil {
+			serveFile(c, path, "image/webp", "")
+			return
+		}
+		if !errors.Is(err, errWatermarkUnsupported) {
+			log.Printf("生成水印版本失败: %v", err)
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// 请求指定宽度的衍生版本（栅格化的SVG或响应式版本）时，存在则直接提供
+	// 配置中的响应式宽度不存在时即时生成，宽度不小于原图时提供默认版本
+	if w := c.Query("w"); w != "" {
+		if width, err := strconv.Atoi(w); err == nil && width > 0 {
+			path := variantPath(webpPath, widthName(width))
+			if !isRegularFile(path) && webpExists && isResponsiveWidth(width) {
+				if files, ok := resolveImage(filePath); ok && files.OriginalPath != "" && !strings.EqualFold(filepath.Ext(files.OriginalPath), ".svg") {
+					if info, ok := inspectFile(webpPath); ok && info.Frames == 1 && width < info.Width {
+						if _, err := ensureResponsive(files.OriginalPath, webpPath, width, nil); err != nil {
+							log.Printf("即时生成响应式版本失败: %v", err)
+						}
+					}
+				}
+			}
+			if isRegularFile(path) {
+				serveFile(c, path, "image/webp", "")
+				return
+			}
+		}
+	}
+
+	// animated=false 时为动画图片提供第1帧作为静态海报；静态图片忽略该参数
+	// 公开地址只提供第1帧，其他帧需要登录后通过 /api/images/*path/poster 获取，避免匿名请求为任意帧生成文件
+	if c.Query("animated") == "false" {
+		if files, ok := resolveImage(filePath); ok {
+			if frame, err := parseFrame(c.Query("frame")); err != nil || frame != 1 {
+				c.String(http.StatusBadRequest, "公开地址只提供第1帧海报，其他帧请登录后通过 /api/images/*path/poster 获取")
+				return
+			}
+			if poster, err := ensurePoster(files, 1); err == nil {
+				servePoster(c, poster)
+				return
+			} else if !errors.Is(err, errNotAnimated) {
+				log.Printf("生成海报帧失败: %v", err)
+				if errors.Is(err, imaging.ErrFrameOutOfRange) {
+					c.Status(http.StatusNotFound)
+					return
 				}
 			}
 		}
 	}
 
+	// 客户端在Accept中明确接受视频时，优先提供体积更小的视频版本
+	if video, contentType, ok := negotiateVideo(c, webpPath); ok {
+		log.Printf("提供视频版本: %s", video)
+		serveFile(c, video, contentType, "")
+		return
+	}
+
 	// 对于尚未转换为动画WebP的动画GIF，提供原始文件以确保动画效果正常工作
 	if isAnimatedGif && !webpExists {
 		log.Printf("提供动画GIF: %s (WebP版本不可用)", originalPath)
-		c.Header("Content-Type", "image/gif")
-		c.File(originalPath)
+		serveFile(c, originalPath, "image/gif", "")
 		return
 	}
 
 	// 如果WebP存在（无论是预先存在的还是刚刚创建的）则提供WebP
 	if webpExists {
 		// 检查WebP是否实际上是一个复制的GIF文件（为了向后兼容）
-		webpData, err := os.ReadFile(webpPath)
-		if err == nil && len(webpData) >= 3 && string(webpData[0:3]) == "GIF" {
+		if sniffImageType(webpPath) == "gif" {
 			// 这实际上是一个带有.webp扩展名的GIF文件
 			log.Printf("检测到带有.webp扩展名的GIF文件，以GIF格式提供")
-			c.Header("Content-Type", "image/gif")
-			c.File(webpPath)
+			serveFile(c, webpPath, "image/gif", "")
 			return
 		}
 
 		// 这是一个真正的WebP文件
 		log.Printf("提供WebP图片: %s", webpPath)
-		c.Header("Content-Type", "image/webp")
-		c.File(webpPath)
+		serveFile(c, webpPath, "image/webp", "")
 		return
 	}
 
@@ -521,19 +900,8 @@ func imageHandler(c *gin.Context) {
 	if _, err := os.Stat(originalPath); err == nil {
 		log.Printf("提供原始图片: %s", originalPath)
 		// 根据扩展名确定内容类型
-		contentType := "image/jpeg" // 默认
-		switch ext {
-		case ".png":
-			contentType = "image/png"
-		case ".gif":
-			contentType = "image/gif"
-		case ".svg":
-			contentType = "image/svg+xml"
-		case ".jpg", ".jpeg":
-			contentType = "image/jpeg"
-		}
-		c.Header("Content-Type", contentType)
-		c.File(originalPath)
+		contentType := contentTypeByExt(ext)
+		serveFile(c, originalPath, contentType, "")
 		return
 	}
 
@@ -542,28 +910,55 @@ func imageHandler(c *gin.Context) {
 	c.Status(http.StatusNotFound)
 }
 
-// convertToWebP 将任何类型的图片转换为WebP格式
-func convertToWebP(srcPath, dstPath string) error {
+// convertToWebP 将任何类型的图片转换为WebP格式，返回实际使用的编码参数
+// opts 为 nil 时根据图片内容自动选择编码参数
+func convertToWebP(srcPath, dstPath string, opts *EncodeOptions) (*ConvertResult, error) {
 	// 检查源文件是否已经是WebP格式
+	// 生成缩放版本或需要加水印时由cwebp重新编码，动画WebP无法重新编码，只能直接复制
 	ext := strings.ToLower(filepath.Ext(srcPath))
-	if ext == ".webp" {
+	if ext == ".webp" && (!mustEncode(opts) || isAnimatedWebP(srcPath)) {
 		log.Printf("源文件已经是WebP格式，直接复制: %s", srcPath)
-		return copyFile(srcPath, dstPath)
+		return &ConvertResult{}, copyWithPolicy(srcPath, dstPath)
+	}
+
+	// SVG需要先栅格化，cwebp无法直接读取
+	if ext == ".svg" {
+		return convertSVG(srcPath, dstPath, opts)
+	}
+
+	// cwebp无法读取的格式先解码为临时PNG，后续编码和备用复制都使用解码结果
+	// 浏览器通常无法显示HEIC等格式，转换失败时复制PNG比复制原文件更有用
+	if needsDecoding(ext) {
+		decodedPath, err := decodeToPNG(srcPath, filepath.Dir(dstPath))
+		if err != nil {
+			return nil, fmt.Errorf("解码%s图片失败: %w", ext, err)
+		}
+		defer os.Remove(decodedPath)
+		srcPath = decodedPath
 	}
 
 	// 检测图片类型
 	imgType, _, err := detectImageType(srcPath)
 	if err != nil {
-		return fmt.Errorf("检测图片类型失败: %w", err)
+		return nil, fmt.Errorf("检测图片类型失败: %w", err)
+	}
+
+	if opts == nil {
+		if imgType == "gif" {
+			// 动画GIF的编码模式由gif2webp处理，不做内容分析
+			opts = defaultEncodeOptions()
+		} else {
+			opts = autoEncodeOptions(srcPath)
+		}
 	}
 
 	// 根据图片类型选择合适的转换方法
 	if imgType == "gif" {
-		// 动画GIF需要特殊处理
-		return convertAnimatedGif(srcPath, dstPath)
+		// 动画GIF需要特殊处理，视频版本由调用方通过 scheduleVideos 在后台生成
+		return convertAnimatedGif(srcPath, dstPath, opts)
 	} else {
 		// 所有其他图片(包括静态GIF)使用cwebp
-		return convertWithCwebp(srcPath, dstPath)
+		return convertWithCwebp(srcPath, dstPath, opts)
 	}
 }
 
@@ -580,18 +975,8 @@ func detectImageType(filePath string) (imgType string, isAnimated bool, err erro
 
 	// 针对GIF做特殊处理检查是否为动画
 	if imgType == "gif" {
-		file, err := os.Open(filePath)
-		if err != nil {
-			return imgType, false, nil // 无法打开文件，假设是静态图片
-		}
-		defer file.Close()
-
-		gifImg, err := gif.DecodeAll(file)
-		if err != nil {
-			return imgType, false, nil // 解码失败，假设是静态图片
-		}
-
-		isAnimated = len(gifImg.Image) > 1
+		// 无法读取或解析时视为静态图片
+		isAnimated = isAnimatedGIF(filePath)
 		log.Printf("检测到GIF图片: %s, 是否动画: %v", filePath, isAnimated)
 	}
 
@@ -599,23 +984,33 @@ func detectImageType(filePath string) (imgType string, isAnimated bool, err erro
 }
 
 // convertAnimatedGif 转换动画GIF为WebP格式
-func convertAnimatedGif(srcPath, dstPath string) error {
+func convertAnimatedGif(srcPath, dstPath string, opts *EncodeOptions) (*ConvertResult, error) {
 	log.Printf("处理动画GIF: %s", srcPath)
+	result := &ConvertResult{Encode: *opts}
+	if opts.Watermark != nil {
+		log.Printf("动画GIF不支持添加水印，跳过: %s", srcPath)
+	}
 
 	// 获取原始文件大小
 	srcInfo, err := os.Stat(srcPath)
 	if err != nil {
-		return fmt.Errorf("获取源文件信息失败: %w", err)
+		return nil, fmt.Errorf("获取源文件信息失败: %w", err)
 	}
 	srcSize := srcInfo.Size()
 
 	// 检查gif2webp是否可用
 	if _, err := exec.LookPath("gif2webp"); err == nil {
-		// 使用gif2webp转换，质量从配置获取
-		// 注意: gif2webp需要参数和值分开传递
-		cmd := exec.Command("gif2webp", "-q", fmt.Sprintf("%d", config.WebPQuality), "-m", "6", srcPath, "-mt", "-min_size", "-o", dstPath)
+		// 使用gif2webp转换，质量、帧压缩方式和关键帧间隔从编码参数获取
+		cmd := exec.Command("gif2webp", gif2webpArgs(opts, srcPath, dstPath)...)
 		output, err := cmd.CombinedOutput()
 		if err == nil {
+			// gif2webp沿用GIF中的循环次数，指定了循环次数时再写入
+			if opts.Loop >= 0 {
+				if err := setWebPLoop(dstPath, opts.Loop); err != nil {
+					log.Printf("设置动画循环次数失败: %v", err)
+				}
+			}
+
 			// 检查转换后的文件大小
 			dstInfo, err := os.Stat(dstPath)
 			if err == nil {
@@ -627,16 +1022,16 @@ func convertAnimatedGif(srcPath, dstPath string) error {
 					// 删除较大的WebP文件
 					os.Remove(dstPath)
 					// 复制原始文件到目标位置
-					return copyFile(srcPath, dstPath)
+					return result, copyWithPolicy(srcPath, dstPath)
 				}
 
 				compressionRatio := 100 - (float64(dstSize) / float64(srcSize) * 100)
 				log.Printf("成功将动画GIF转换为WebP: %s (原始: %d字节, WebP: %d字节, 压缩率: %.1f%%)",
 					dstPath, srcSize, dstSize, compressionRatio)
-				return nil
+				return result, nil
 			}
 			log.Printf("成功将动画GIF转换为WebP: %s", dstPath)
-			return nil
+			return result, nil
 		}
 		log.Printf("使用gif2webp转换失败: %v, 输出: %s", err, output)
 	} else {
@@ -644,17 +1039,18 @@ func convertAnimatedGif(srcPath, dstPath string) error {
 	}
 
 	// 如果转换失败则复制原文件
-	return copyFile(srcPath, dstPath)
+	return result, copyWithPolicy(srcPath, dstPath)
 }
 
 // convertWithCwebp 使用cwebp转换各种图片格式为WebP
-func convertWithCwebp(srcPath, dstPath string) error {
+func convertWithCwebp(srcPath, dstPath string, opts *EncodeOptions) (*ConvertResult, error) {
 	log.Printf("使用cwebp转换图片: %s", srcPath)
+	result := &ConvertResult{Encode: *opts}
 
 	// 获取原始文件大小
 	srcInfo, err := os.Stat(srcPath)
 	if err != nil {
-		return fmt.Errorf("获取源文件信息失败: %w", err)
+		return nil, fmt.Errorf("获取源文件信息失败: %w", err)
 	}
 	srcSize := srcInfo.Size()
 
@@ -665,42 +1061,93 @@ func convertWithCwebp(srcPath, dstPath string) error {
 	// 检查cwebp是否可用
 	_, err = exec.LookPath("cwebp")
 	if err != nil {
+		// 缩放或加水印的版本无法用原图代替
+		if mustEncode(opts) {
+			return nil, fmt.Errorf("cwebp工具不可用: %w", err)
+		}
 		log.Printf("cwebp工具不可用: %v, 将使用文件复制作为备用方案", err)
-		return copyFile(srcPath, dstPath)
+		return result, copyWithPolicy(srcPath, dstPath)
 	}
 
-	// 根据图片类型和大小设置不同的转换参数
-	var cmd *exec.Cmd
+	// 按EXIF方向预先旋转像素，并按配置处理ICC颜色配置文件
+	prepared, err := prepareSource(srcPath, filepath.Dir(dstPath))
+	if err != nil {
+		log.Printf("预处理图片失败，使用原图转换: %v", err)
+	}
+	if prepared.temp {
+		defer os.Remove(prepared.path)
+	}
+	encodeSrc := prepared.path
+	result.ColorProfile = prepared.colorProfile
+	result.ColorHandling = prepared.colorHandling
 
-	cmd = exec.Command("cwebp", "-q", fmt.Sprintf("%d", config.WebPQuality), "-z", "9", srcPath, "-o", dstPath)
+	// 水印加在旋转后的像素上，原图保持不变
+	if opts.Watermark != nil {
+		marked, err := applyWatermark(encodeSrc, filepath.Dir(dstPath), opts.Watermark)
+		if err != nil {
+			return nil, fmt.Errorf("添加水印失败: %w", err)
+		}
+		defer os.Remove(marked)
+		encodeSrc = marked
+	}
 
-	// 执行转换
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		log.Printf("cwebp转换失败: %v, 输出: %s", err, output)
-		return copyFile(srcPath, dstPath)
+	// 指定了目标SSIM或体积上限时搜索合适的质量，搜索失败则按原参数转换
+	searched := false
+	if opts.TargetSSIM > 0 || opts.MaxSize > 0 {
+		if searchResult, err := searchQuality(encodeSrc, dstPath, opts); err != nil {
+			log.Printf("质量搜索失败，使用指定参数转换: %v", err)
+		} else {
+			searchResult.ColorProfile = result.ColorProfile
+			searchResult.ColorHandling = result.ColorHandling
+			result = searchResult
+			searched = true
+		}
+	}
+
+	if !searched {
+		// 根据编码参数设置转换参数
+		cmd := exec.Command("cwebp", cwebpArgs(opts, encodeSrc, dstPath)...)
+
+		// 执行转换
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			if mustEncode(opts) {
+				return nil, fmt.Errorf("cwebp转换失败: %v, 输出: %s", err, output)
+			}
+			log.Printf("cwebp转换失败: %v, 输出: %s", err, output)
+			return result, copyWithPolicy(srcPath, dstPath)
+		}
 	}
 
 	// 检查转换后的文件大小
 	dstInfo, err := os.Stat(dstPath)
 	if err != nil {
-		return fmt.Errorf("获取目标文件信息失败: %w", err)
+		return nil, fmt.Errorf("获取目标文件信息失败: %w", err)
 	}
 	dstSize := dstInfo.Size()
 
-	// 如果WebP文件比原始文件大，使用原始文件替代
-	if dstSize > srcSize {
+	// 如果WebP文件比原始文件大，使用原始文件替代；缩放或加水印的版本内容不同，不能替代
+	if dstSize > srcSize && !mustEncode(opts) {
 		log.Printf("WebP转换后文件变大 (%d -> %d 字节)，保留原始格式", srcSize, dstSize)
 		// 删除较大的WebP文件
 		os.Remove(dstPath)
+		// 保存的是原图，质量搜索的结果不再适用，返回与其他复制原图分支一致的结果
+		result = &ConvertResult{Encode: *opts, ColorProfile: prepared.colorProfile, ColorHandling: prepared.colorHandling}
 		// 复制原始文件到目标位置
-		return copyFile(srcPath, dstPath)
+		return result, copyWithPolicy(srcPath, dstPath)
+	}
+
+	// 旋转后的临时文件不含元数据，按策略将原图的元数据写回
+	if prepared.embedded != nil {
+		if err := injectWebPMetadata(dstPath, prepared.embedded); err != nil {
+			log.Printf("写入WebP元数据失败: %v", err)
+		}
 	}
 
 	compressionRatio := 100 - (float64(dstSize) / float64(srcSize) * 100)
 	log.Printf("成功转换为WebP格式: %s (原始: %d字节, WebP: %d字节, 压缩率: %.1f%%)",
 		dstPath, srcSize, dstSize, compressionRatio)
-	return nil
+	return result, nil
 }
 
 // copyFile 在转换失败时复制原始文件
@@ -746,9 +1193,23 @@ func getDateFolderPath(baseDir string) (string, error) {
 	return datePath, nil
 }
 
+// 上一个生成的文件名使用的时间，保证并发上传时文件名不重复
+var (
+	fileNameMu   sync.Mutex
+	lastFileTime time.Time
+)
+
 // generateTimestampFileName 生成基于时间戳的文件名
+// 同一毫秒内的多次调用依次使用后面的毫秒数，避免批量上传时互相覆盖
 func generateTimestampFileName(ext string) string {
-	now := time.Now()
+	fileNameMu.Lock()
+	now := time.Now().Truncate(time.Millisecond)
+	if !now.After(lastFileTime) {
+		now = lastFileTime.Add(time.Millisecond)
+	}
+	lastFileTime = now
+	fileNameMu.Unlock()
+
 	// 使用时间戳作为文件名: unixtime-milliseconds
 	timestamp := fmt.Sprintf("%d-%03d", now.Unix(), now.Nanosecond()/1000000)
 	return timestamp + ext
@@ -756,6 +1217,12 @@ func generateTimestampFileName(ext string) string {
 
 // generatePaths 为原始图片和WebP图片生成存储路径
 func generatePaths(originalExt string) (originalPath, webpPath, relativePath string, err error) {
+	return generatePathsIn("", originalExt)
+}
+
+// generatePathsIn 与 generatePaths 相同，但保存在日期目录下的子目录中（形如 YY/MM/DD/subdir/filename.ext）
+// subdir 必须是已经检查过的相对路径，用于导入压缩包时保留原来的目录结构
+func generatePathsIn(subdir, originalExt string) (originalPath, webpPath, relativePath string, err error) {
 	// 获取原始图片的目录路径
 	picsDirPath, err := getDateFolderPath(config.PicsDir)
 	if err != nil {
@@ -768,19 +1235,35 @@ func generatePaths(originalExt string) (originalPath, webpPath, relativePath str
 		return "", "", "", err
 	}
 
-	// 生成基于时间戳的文件名
-	filename := generateTimestampFileName(originalExt)
-	webpFilename := strings.TrimSuffix(filename, filepath.Ext(filename)) + ".webp"
+	if subdir != "" {
+		picsDirPath = filepath.Join(picsDirPath, subdir)
+		webpDirPath = filepath.Join(webpDirPath, subdir)
+		for _, dir := range []string{picsDirPath, webpDirPath} {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return "", "", "", fmt.Errorf("创建子目录失败: %w", err)
+			}
+		}
+	}
 
-	// 构建完整的文件路径
-	originalPath = filepath.Join(picsDirPath, filename)
-	webpPath = filepath.Join(webpDirPath, webpFilename)
+	// 生成基于时间戳的文件名，跳过已经存在的文件（例如服务重启前生成的文件）
+	var filename string
+	for {
+		filename = generateTimestampFileName(originalExt)
+		webpFilename := strings.TrimSuffix(filename, filepath.Ext(filename)) + ".webp"
+
+		// 构建完整的文件路径
+		originalPath = filepath.Join(picsDirPath, filename)
+		webpPath = filepath.Join(webpDirPath, webpFilename)
+		if !isRegularFile(originalPath) && !isRegularFile(webpPath) {
+			break
+		}
+	}
 
 	// 计算相对路径，用于URL（形如 YY/MM/DD/filename.ext）
 	currentYear := fmt.Sprintf("%02d", time.Now().Year()%100)
 	currentMonth := fmt.Sprintf("%02d", time.Now().Month())
 	currentDay := fmt.Sprintf("%02d", time.Now().Day())
-	relativePath = filepath.Join(currentYear, currentMonth, currentDay, filename)
+	relativePath = filepath.Join(currentYear, currentMonth, currentDay, subdir, filename)
 
 	return originalPath, webpPath, relativePath, nil
 }
@@ -809,11 +1292,20 @@ func downloadWebpHandler(c *gin.Context) {
 		return
 	}
 
+	// 转换失败时保存的是原始GIF的副本，按实际格式提供
+	contentType, downloadExt := "image/webp", ".webp"
+	if sniffImageType(webpPath) == "gif" {
+		contentType, downloadExt = "image/gif", ".gif"
+	}
+
+	// 下载文件名优先使用用户上传时的原始文件名，没有记录时使用时间戳文件名
+	downloadBase := baseNameWithoutExt
+	if meta, err := metaStore.Load(filePath); err == nil && meta.OriginalName != "" {
+		downloadBase = strings.TrimSuffix(meta.OriginalName, filepath.Ext(meta.OriginalName))
+	}
+
 	// 设置Content-Disposition头，使浏览器下载文件而不是在浏览器中打开
-	fileName := baseNameWithoutExt + ".webp"
-	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", fileName))
-	c.Header("Content-Type", "image/webp")
-	c.File(webpPath)
+	serveFile(c, webpPath, contentType, downloadBase+downloadExt)
 }
 
 // convertExistingImages 扫描所有原始图片目录并转换缺少对应WebP版本的图片
@@ -840,7 +1332,7 @@ func convertExistingImages() {
 
 		// 仅处理图片文件
 		ext := strings.ToLower(filepath.Ext(path))
-		if ext != ".png" && ext != ".jpg" && ext != ".jpeg" && ext != ".gif" && ext != ".webp" {
+		if !isSupportedImage(ext) {
 			return nil
 		}
 
@@ -879,12 +1371,33 @@ func convertExistingImages() {
 				return nil
 			}
 
+			// 强制重新生成前保存当前的WebP，质量参数调整后效果变差时可以回滚
+			var saved *imageVersion
+			if webpExists {
+				files := &imageFiles{RelPath: relPath, OriginalPath: path, WebpPath: webpPath}
+				if saved, err = saveVersion(files, webpPath, "regenerate"); err != nil {
+					log.Printf("保存历史版本失败 %s: %v", path, err)
+					errorImages++
+					return nil
+				}
+			}
+
 			// 调用转换函数
-			if err := convertToWebP(path, webpPath); err != nil {
+			if result, err := convertToWebP(path, webpPath, nil); err != nil {
 				log.Printf("转换失败 %s: %v", path, err)
 				errorImages++
 			} else {
 				convertedImages++
+				recordConversion(relPath, result)
+			}
+
+			// 结果与之前完全相同时不需要保留历史版本，避免每次启动都增加一个版本
+			if saved != nil {
+				if saved.WebP && sameFile(saved.webpPath(), webpPath) {
+					discardVersion(saved)
+				} else {
+					pruneVersions(webpPath)
+				}
 			}
 		}
 
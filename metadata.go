@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/suixinio/webp-img/imaging"
+	"github.com/suixinio/webp-img/storage"
+)
+
+// 元数据策略
+const (
+	MetadataStrip = "strip" // 移除所有元数据
+	MetadataICC   = "icc"   // 只保留ICC颜色配置文件
+	MetadataAll   = "all"   // 保留所有元数据
+)
+
+// ICC颜色配置文件处理方式
+const (
+	ICCKeep   = "keep"   // 在WebP中保留ICC配置文件
+	ICCSRGB   = "srgb"   // 将像素转换为sRGB，不保留配置文件
+	ICCIgnore = "ignore" // 不做特殊处理，完全由元数据策略决定
+)
+
+// 记录在元数据中的颜色处理结果
+const (
+	ColorNoProfile = "none"      // 图片没有嵌入ICC配置文件
+	ColorKept      = "kept"      // ICC配置文件已保留在WebP中
+	ColorConverted = "converted" // 像素已转换为sRGB
+	ColorStripped  = "stripped"  // ICC配置文件按元数据策略被移除
+)
+
+// keepICC 判断输出的WebP是否应当包含ICC配置文件
+func keepICC() bool {
+	switch config.ICCMode {
+	case ICCKeep:
+		return true
+	case ICCSRGB:
+		return false
+	}
+	return config.MetadataPolicy != MetadataStrip
+}
+
+// cwebpMetadataFlag 将元数据策略和ICC处理方式转换为cwebp/gif2webp的 -metadata 参数值
+func cwebpMetadataFlag() string {
+	if config.MetadataPolicy == MetadataAll {
+		if keepICC() {
+			return "all"
+		}
+		return "exif,xmp"
+	}
+	if keepICC() {
+		return "icc"
+	}
+	return "none"
+}
+
+// preparedSource 编码前预处理得到的源文件
+type preparedSource struct {
+	path          string            // 实际用于编码的文件路径
+	temp          bool              // path 是否为需要删除的临时文件
+	embedded      *imaging.Embedded // 原文件中嵌入的元数据，临时文件编码后需要按策略写回
+	colorProfile  string            // ICC配置文件描述
+	colorHandling string            // 颜色处理结果
+}
+
+// prepareSource 在编码前按EXIF方向旋转像素，并按配置将带ICC配置文件的像素转换为sRGB
+// 需要处理时将结果写入临时PNG文件，否则直接使用原文件
+func prepareSource(srcPath, tmpDir string) (*preparedSource, error) {
+	prepared := &preparedSource{path: srcPath, colorHandling: ColorNoProfile}
+
+	data, err := os.ReadFile(srcPath)
+	if err != nil {
+		return prepared, fmt.Errorf("读取源文件失败: %w", err)
+	}
+	embedded := imaging.ReadEmbedded(data)
+
+	orientation := 1
+	if config.AutoOrient {
+		orientation = embedded.Orientation()
+	}
+
+	var profile *imaging.ICCProfile
+	if len(embedded.ICC) > 0 {
+		if profile, err = imaging.ParseICC(embedded.ICC); err != nil {
+			log.Printf("解析ICC配置文件失败: %v", err)
+			profile = nil
+		} else {
+			prepared.colorProfile = profile.Description
+		}
+	}
+
+	if len(embedded.ICC) > 0 {
+		if keepICC() {
+			prepared.colorHandling = ColorKept
+		} else {
+			prepared.colorHandling = ColorStripped
+		}
+	}
+
+	convert := config.ICCMode == ICCSRGB && profile != nil && !profile.IsSRGB()
+	if convert && !profile.CanConvert() {
+		log.Printf("不支持将ICC配置文件 %q (%s) 转换为sRGB，保留配置文件", profile.Description, profile.ColorSpace)
+		convert = false
+		// 无法转换时保留配置文件，避免颜色错误
+		prepared.colorHandling = ColorKept
+	}
+
+	if orientation == 1 && !convert {
+		if prepared.colorHandling == ColorKept && config.ICCMode == ICCSRGB {
+			// 需要单独写回ICC配置文件，cwebp参数不会保留它
+			prepared.embedded = &imaging.Embedded{ICC: embedded.ICC}
+		}
+		return prepared, nil
+	}
+
+	img, _, err := imaging.Load(srcPath)
+	if err != nil {
+		return prepared, err
+	}
+
+	if convert {
+		converted, err := profile.ConvertToSRGB(img)
+		if err != nil {
+			return prepared, err
+		}
+		img = converted
+		prepared.colorHandling = ColorConverted
+		log.Printf("已将ICC配置文件 %q 的像素转换为sRGB: %s", profile.Description, srcPath)
+	}
+
+	if orientation != 1 {
+		img = imaging.Orient(img, orientation)
+		log.Printf("按EXIF方向 %d 旋转图片: %s", orientation, srcPath)
+	}
+
+	path, err := writeTempPNG(img, tmpDir, ".prepared-*.png")
+	if err != nil {
+		return prepared, err
+	}
+
+	prepared.path = path
+	prepared.temp = true
+	prepared.embedded = embedded
+	if prepared.colorHandling != ColorKept {
+		// 已转换为sRGB或按策略移除的配置文件不再写回
+		prepared.embedded = &imaging.Embedded{EXIF: embedded.EXIF, XMP: embedded.XMP}
+	}
+	return prepared, nil
+}
+
+// injectWebPMetadata 按元数据策略和ICC处理方式将原文件的元数据写回WebP文件
+// 写回的EXIF方向会被重置为1，因为像素已经旋转过
+func injectWebPMetadata(webpPath string, embedded *imaging.Embedded) error {
+	type item struct {
+		name string
+		data []byte
+	}
+
+	// 需要移除的ICC配置文件在预处理阶段已被排除
+	items := []item{{"icc", embedded.ICC}}
+	if config.MetadataPolicy == MetadataAll {
+		items = append(items, item{"exif", imaging.ResetOrientation(embedded.EXIF)}, item{"xmp", embedded.XMP})
+	}
+
+	if _, err := exec.LookPath("webpmux"); err != nil {
+		return fmt.Errorf("未找到webpmux工具，无法写入元数据")
+	}
+
+	for _, it := range items {
+		if len(it.data) == 0 {
+			continue
+		}
+
+		tmp, err := os.CreateTemp(filepath.Dir(webpPath), ".metadata-*")
+		if err != nil {
+			return fmt.Errorf("创建临时文件失败: %w", err)
+		}
+		tmp.Write(it.data)
+		tmp.Close()
+
+		output, err := exec.Command("webpmux", "-set", it.name, tmp.Name(), webpPath, "-o", webpPath).CombinedOutput()
+		os.Remove(tmp.Name())
+		if err != nil {
+			return fmt.Errorf("写入%s元数据失败: %v, 输出: %s", it.name, err, output)
+		}
+	}
+	return nil
+}
+
+// copyWithPolicy 复制原始文件作为WebP的备用版本，并按元数据策略清理副本
+func copyWithPolicy(src, dst string) error {
+	if err := copyFile(src, dst); err != nil {
+		return err
+	}
+	if err := sanitizeFile(dst); err != nil {
+		log.Printf("清理副本元数据失败: %v", err)
+	}
+	return nil
+}
+
+// sanitizeFile 按元数据策略清理图片中的元数据（如GPS位置），直接替换原文件
+func sanitizeFile(path string) error {
+	if config.MetadataPolicy == MetadataAll {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("读取图片失败: %w", err)
+	}
+
+	// 原图像素不会被修改，只要启用了ICC处理就需要保留配置文件才能正确显示颜色
+	cleaned, ok := imaging.Sanitize(data, config.MetadataPolicy == MetadataICC || config.ICCMode != ICCIgnore)
+	if !ok {
+		log.Printf("不支持该格式或文件结构不完整，未清理元数据，保留原文件: %s", path)
+		return nil
+	}
+	if bytes.Equal(cleaned, data) {
+		return nil
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, cleaned, 0644); err != nil {
+		return fmt.Errorf("写入清理后的图片失败: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("替换图片失败: %w", err)
+	}
+
+	log.Printf("已清理图片元数据: %s (%d -> %d 字节)", path, len(data), len(cleaned))
+	return nil
+}
+
+// recordConversion 将转换结果中的颜色处理方式和占位信息写入图片元数据
+// 通过启动扫描或即时转换生成的图片可能没有元数据记录，此时新建一条
+func recordConversion(relPath string, result *ConvertResult) {
+	if result == nil {
+		return
+	}
+
+	meta, err := metaStore.Load(relPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("读取图片元数据失败: %v", err)
+			return
+		}
+		meta = &storage.Metadata{OriginalName: filepath.Base(relPath)}
+	}
+
+	meta.ColorProfile = result.ColorProfile
+	meta.ColorHandling = result.ColorHandling
+	if placeholder := computePlaceholder(relPath); placeholder != nil {
+		meta.BlurHash = placeholder.BlurHash
+		meta.LQIP = placeholder.LQIP
+		meta.DominantColor = placeholder.DominantColor
+	}
+	if err := metaStore.Save(relPath, meta); err != nil {
+		log.Printf("保存图片元数据失败: %v", err)
+	}
+}
+
+// computePlaceholder 计算图片的BlurHash、LQIP和主色调
+// 优先使用已旋转和转换颜色的WebP，无法解码时（例如动画WebP）退回到原图，都无法解码时返回nil
+func computePlaceholder(relPath string) *imaging.Placeholder {
+	rel := filepath.Clean("/" + relPath)
+	webpPath := filepath.Join(config.WebpDir, strings.TrimSuffix(rel, filepath.Ext(rel))+".webp")
+
+	img, _, err := imaging.Load(webpPath)
+	if err != nil {
+		if img, _, err = imaging.Load(filepath.Join(config.PicsDir, rel)); err != nil {
+			log.Printf("无法解码图片，跳过占位信息: %s", relPath)
+			return nil
+		}
+	}
+
+	placeholder, err := imaging.NewPlaceholder(img)
+	if err != nil {
+		log.Printf("计算占位信息失败: %v", err)
+		return nil
+	}
+	return placeholder
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/suixinio/webp-img/security"
+)
+
+// 下载远程图片使用的HTTP客户端，禁止访问内网地址，在main中按配置创建
+var remoteClient *http.Client
+
+// uploadURLRequest 从远程URL上传的JSON参数
+type uploadURLRequest struct {
+	URL string `json:"url"`
+}
+
+// limitedBody 读取的内容超过限制时返回错误，避免像 io.LimitReader 那样静默截断
+type limitedBody struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (l *limitedBody) Read(p []byte) (int, error) {
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.r.Read(p)
+	if int64(n) > l.remaining {
+		return int(l.remaining), &uploadError{http.StatusRequestEntityTooLarge, remoteTooLargeMessage()}
+	}
+	l.remaining -= int64(n)
+	return n, err
+}
+
+// remoteTooLargeMessage 远程文件超过大小限制时的提示
+func remoteTooLargeMessage() string {
+	return fmt.Sprintf("远程文件超过大小限制 (%d MB)", config.RemoteMaxSize/(1024*1024))
+}
+
+// fetchRemote 下载远程图片，失败时返回 *uploadError
+// 调用方负责关闭返回的响应体
+func fetchRemote(c *gin.Context, u *url.URL) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(c.Request.Context(), http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, &uploadError{http.StatusBadRequest, security.ErrInvalidRemoteURL.Error()}
+	}
+	req.Header.Set("User-Agent", "webp-img")
+	req.Header.Set("Accept", "image/*")
+
+	resp, err := remoteClient.Do(req)
+	if err != nil {
+		log.Printf("下载远程图片失败 %s: %v", u.Redacted(), err)
+		var netErr net.Error
+		switch {
+		case errors.Is(err, security.ErrBlockedAddress):
+			return nil, &uploadError{http.StatusForbidden, security.ErrBlockedAddress.Error()}
+		case errors.Is(err, security.ErrTooManyRedirects), errors.Is(err, security.ErrInvalidRemoteURL):
+			return nil, &uploadError{http.StatusBadGateway, errors.Unwrap(err).Error()}
+		case errors.As(err, &netErr) && netErr.Timeout():
+			return nil, &uploadError{http.StatusGatewayTimeout, "下载远程图片超时"}
+		}
+		return nil, &uploadError{http.StatusBadGateway, "下载远程图片失败"}
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		return nil, &uploadError{http.StatusBadGateway, fmt.Sprintf("远程服务器返回 %d", resp.StatusCode)}
+	}
+	if resp.ContentLength > config.RemoteMaxSize {
+		resp.Body.Close()
+		return nil, &uploadError{http.StatusRequestEntityTooLarge, remoteTooLargeMessage()}
+	}
+	return resp, nil
+}
+
+// remoteFilename 根据URL路径和内容类型确定原始文件名
+// URL中的文件名不是支持的图片扩展名时（例如 image.php?id=1）按内容类型替换扩展名
+func remoteFilename(u *url.URL, contentType string) string {
+	name := path.Base(u.Path)
+	if name == "/" || name == "." {
+		name = ""
+	}
+	ext := path.Ext(name)
+	if !isSupportedImage(ext) {
+		name = strings.TrimSuffix(name, ext)
+		if name == "" {
+			name = "image"
+		}
+		name += extByContentType(contentType)
+	}
+	return name
+}
+
+// uploadURLHandler 从远程URL下载图片，然后按普通上传的流程保存和转换
+// 请求体可以是JSON {"url": "..."}，也可以是表单字段 url；编码参数与上传接口相同
+func uploadURLHandler(c *gin.Context) {
+	encodeOpts, err := parseEncodeOptions(c)
+	if err != nil {
+		respondUploadError(c, &uploadError{http.StatusBadRequest, err.Error()})
+		return
+	}
+
+	rawURL := c.PostForm("url")
+	if c.ContentType() == "application/json" {
+		var req uploadURLRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondUploadError(c, &uploadError{http.StatusBadRequest, "无效的请求参数"})
+			return
+		}
+		rawURL = req.URL
+	}
+
+	u, err := security.ParseRemoteURL(rawURL)
+	if err != nil {
+		respondUploadError(c, &uploadError{http.StatusBadRequest, err.Error()})
+		return
+	}
+
+	resp, err := fetchRemote(c, u)
+	if err != nil {
+		respondUploadError(c, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	// 服务器未声明图片类型时（例如 application/octet-stream）由 processUpload 根据文件头识别
+	contentType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+
+	log.Printf("从远程URL上传图片: %s", u.Redacted())
+	response, err := processUpload(&uploadSource{
+		Reader:      &limitedBody{r: resp.Body, remaining: config.RemoteMaxSize},
+		Filename:    remoteFilename(u, contentType),
+		ContentType: contentType,
+		SourceURL:   u.Redacted(),
+	}, encodeOpts)
+	if err != nil {
+		respondUploadError(c, err)
+		return
+	}
+	response["source_url"] = u.Redacted()
+	c.JSON(http.StatusOK, response)
+}
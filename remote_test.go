@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	cfg "github.com/suixinio/webp-img/config"
+	"github.com/suixinio/webp-img/security"
+	"github.com/suixinio/webp-img/storage"
+)
+
+// setupRemoteTest 使用临时目录加载配置并初始化全局状态，configure 可以修改远程下载相关的配置
+func setupRemoteTest(t *testing.T, configure func(*cfg.Config)) {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("WEBP_UPLOAD_DIR", dir)
+	for env, sub := range map[string]string{
+		"WEBP_PICS_DIR":    "pics",
+		"WEBP_WEBP_DIR":    "webp",
+		"WEBP_META_DIR":    "meta",
+		"WEBP_VARIANT_DIR": "variants",
+		"WEBP_VERSION_DIR": "versions",
+		"WEBP_CHUNK_DIR":   "chunks",
+	} {
+		t.Setenv(env, filepath.Join(dir, sub))
+	}
+
+	config = cfg.LoadConfig()
+	if configure != nil {
+		configure(config)
+	}
+	metaStore = storage.NewStore(config.MetaDir)
+	remoteClient = security.NewRemoteClient(config)
+	conversionSlots = make(chan struct{}, 1)
+}
+
+// postUploadURL 调用 uploadURLHandler 上传 rawURL，返回状态码和响应内容
+func postUploadURL(t *testing.T, rawURL string) (int, gin.H) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	body, _ := json.Marshal(uploadURLRequest{URL: rawURL})
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/upload/url", bytes.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	uploadURLHandler(c)
+
+	var resp gin.H
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("解析响应失败: %v: %s", err, w.Body.String())
+	}
+	return w.Code, resp
+}
+
+// testPNG 生成一张小的PNG图片
+func testPNG(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// localhostURL 把测试服务器地址中的 127.0.0.1 换成 localhost，用于按主机名放行第一跳
+func localhostURL(t *testing.T, raw string) string {
+	t.Helper()
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.Host = "localhost:" + u.Port()
+	return u.String()
+}
+
+func TestUploadURLLoopback(t *testing.T) {
+	data := testPNG(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	setupRemoteTest(t, nil)
+	if code, resp := postUploadURL(t, srv.URL+"/a.png"); code != http.StatusForbidden {
+		t.Fatalf("默认应禁止访问本机地址, 得到 %d: %v", code, resp)
+	}
+
+	setupRemoteTest(t, func(c *cfg.Config) { c.RemoteAllowedHosts = []string{"127.0.0.1"} })
+	if code, resp := postUploadURL(t, srv.URL+"/a.png"); code != http.StatusOK {
+		t.Fatalf("允许列表中的地址应可以访问, 得到 %d: %v", code, resp)
+	}
+}
+
+func TestUploadURLRedirectToLoopback(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(testPNG(t))
+	}))
+	defer target.Close()
+	redirector := httptest.NewServer(http.RedirectHandler(target.URL+"/a.png", http.StatusFound))
+	defer redirector.Close()
+
+	// 第一跳按主机名放行，重定向后的 127.0.0.1 仍然需要检查
+	setupRemoteTest(t, func(c *cfg.Config) { c.RemoteAllowedHosts = []string{"localhost"} })
+	code, resp := postUploadURL(t, localhostURL(t, redirector.URL))
+	if code != http.StatusForbidden {
+		t.Fatalf("重定向到本机地址应被禁止, 得到 %d: %v", code, resp)
+	}
+}
+
+func TestUploadURLMaxRedirects(t *testing.T) {
+	data := testPNG(t)
+	// /hop/N 重定向 N 次后返回图片
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n int
+		if _, err := fmt.Sscanf(r.URL.Path, "/hop/%d", &n); err == nil && n > 0 {
+			http.Redirect(w, r, fmt.Sprintf("/hop/%d", n-1), http.StatusFound)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	setupRemoteTest(t, func(c *cfg.Config) {
+		c.RemoteAllowedHosts = []string{"127.0.0.1"}
+		c.RemoteMaxRedirects = 2
+	})
+	if code, resp := postUploadURL(t, srv.URL+"/hop/2"); code != http.StatusOK {
+		t.Fatalf("未超过重定向次数限制时应成功, 得到 %d: %v", code, resp)
+	}
+	code, resp := postUploadURL(t, srv.URL+"/hop/3")
+	if code != http.StatusBadGateway || resp["message"] != security.ErrTooManyRedirects.Error() {
+		t.Fatalf("超过重定向次数限制应返回 502, 得到 %d: %v", code, resp)
+	}
+}
+
+func TestUploadURLTooLarge(t *testing.T) {
+	// 分块传输不声明 Content-Length，只能在读取时由 limitedBody 发现超限
+	data := append(testPNG(t), make([]byte, 4096)...)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.(http.Flusher).Flush()
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	setupRemoteTest(t, func(c *cfg.Config) {
+		c.RemoteAllowedHosts = []string{"127.0.0.1"}
+		c.RemoteMaxSize = 1024
+	})
+	code, resp := postUploadURL(t, srv.URL+"/big.png")
+	if code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("超过大小限制应返回 413, 得到 %d: %v", code, resp)
+	}
+	matches, _ := filepath.Glob(filepath.Join(config.PicsDir, "*", "*", "*", "*"))
+	if len(matches) != 0 {
+		t.Fatalf("超过大小限制时不应保留原图: %v", matches)
+	}
+}
+
+func TestUploadURLOctetStream(t *testing.T) {
+	data := testPNG(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	setupRemoteTest(t, func(c *cfg.Config) { c.RemoteAllowedHosts = []string{"127.0.0.1"} })
+	code, resp := postUploadURL(t, srv.URL+"/download.php?id=1")
+	if code != http.StatusOK {
+		t.Fatalf("应根据文件头识别图片, 得到 %d: %v", code, resp)
+	}
+	rel := strings.TrimPrefix(resp["url"].(string), "/img/")
+	meta, err := metaStore.Load(rel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.ContentType != "image/png" {
+		t.Errorf("ContentType = %q, 期望 image/png", meta.ContentType)
+	}
+	if filepath.Ext(rel) != ".png" {
+		t.Errorf("原图应以 .png 扩展名保存: %s", rel)
+	}
+	if _, err := os.Stat(filepath.Join(config.PicsDir, rel)); err != nil {
+		t.Errorf("原图未保存: %v", err)
+	}
+}
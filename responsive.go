@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/suixinio/webp-img/imaging"
+)
+
+// widthName 返回指定宽度的衍生版本名称，响应式版本和SVG栅格化版本共用 w<宽度> 的命名
+func widthName(width int) string {
+	return "w" + strconv.Itoa(width)
+}
+
+// parseWidthName 从衍生版本名称中解析宽度，不是宽度版本时返回0
+func parseWidthName(name string) int {
+	rest, found := strings.CutPrefix(name, "w")
+	if !found {
+		return 0
+	}
+	width, err := strconv.Atoi(rest)
+	if err != nil || width <= 0 {
+		return 0
+	}
+	return width
+}
+
+// isResponsiveWidth 判断宽度是否在配置的响应式宽度中，只有这些宽度允许即时生成
+func isResponsiveWidth(width int) bool {
+	for _, w := range config.ResponsiveWidths {
+		if w == width {
+			return true
+		}
+	}
+	return false
+}
+
+// responsiveOptions 根据默认WebP实际使用的编码参数生成缩放版本的参数
+// 质量搜索的结果针对原尺寸，缩放版本直接使用选定的质量，不再重复搜索
+func responsiveOptions(srcPath string, result *ConvertResult) *EncodeOptions {
+	var opts *EncodeOptions
+	if result != nil && result.Encode.Quality > 0 {
+		encode := result.Encode
+		opts = &encode
+	} else {
+		opts = autoEncodeOptions(srcPath)
+	}
+	opts.TargetSSIM = 0
+	opts.MaxSize = 0
+	return opts
+}
+
+// generateResponsive 为静态图片预先生成配置中小于原图宽度的各个版本，失败只记录日志
+// SVG的宽度版本由栅格化时生成，动画图片无法缩放，都会跳过
+func generateResponsive(srcPath, webpPath string, result *ConvertResult) {
+	if len(config.ResponsiveWidths) == 0 || strings.EqualFold(filepath.Ext(srcPath), ".svg") {
+		return
+	}
+
+	info, ok := inspectFile(webpPath)
+	if !ok || info.Frames > 1 {
+		return
+	}
+
+	opts := responsiveOptions(srcPath, result)
+	for _, width := range config.ResponsiveWidths {
+		// 不放大图片，宽度已按从小到大排列
+		if width >= info.Width {
+			break
+		}
+		if _, err := ensureResponsive(srcPath, webpPath, width, opts); err != nil {
+			log.Printf("生成宽度 %d 的响应式版本失败: %v", width, err)
+		}
+	}
+}
+
+// ensureResponsive 返回指定宽度的响应式版本路径，不存在时从原图生成
+// opts 为空时根据原图内容自动选择编码参数
+func ensureResponsive(srcPath, webpPath string, width int, opts *EncodeOptions) (string, error) {
+	if opts == nil {
+		opts = responsiveOptions(srcPath, nil)
+	}
+	resized := *opts
+	resized.Width = width
+	return ensureVariant(srcPath, webpPath, widthName(width), &resized)
+}
+
+// inspectFile 读取图片文件的尺寸和帧数，图片列表会对每张图片调用，WebP只读取数据块头部
+func inspectFile(path string) (imaging.Info, bool) {
+	info, err := imaging.InspectFile(path)
+	if err != nil {
+		return imaging.Info{}, false
+	}
+	return info, info.Width > 0
+}
+
+// responsiveImage 一张图片可直接粘贴使用的响应式代码
+type responsiveImage struct {
+	Srcset string // srcset属性，各宽度版本按从小到大排列
+	Sizes  string // sizes属性
+	Width  int    // 默认版本的宽度
+	Height int    // 默认版本的高度
+	HTML   string // <picture> 代码
+}
+
+// buildResponsive 根据已生成的宽度版本生成srcset和<picture>代码
+// relPath 为原图相对路径，没有WebP或WebP为动画时返回nil
+func buildResponsive(relPath, webpPath string) *responsiveImage {
+	info, ok := inspectFile(webpPath)
+	if !ok || info.Frames > 1 {
+		return nil
+	}
+
+	imgURL := "/img/" + filepath.ToSlash(relPath)
+	type candidate struct {
+		url   string
+		width int
+	}
+	var candidates []candidate
+	for name := range listVariants(webpPath) {
+		// SVG的宽度版本可能大于默认版本
+		if width := parseWidthName(name); width > 0 && width != info.Width {
+			candidates = append(candidates, candidate{imgURL + "?w=" + strconv.Itoa(width), width})
+		}
+	}
+	candidates = append(candidates, candidate{imgURL, info.Width})
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].width < candidates[j].width })
+
+	entries := make([]string, len(candidates))
+	for i, c := range candidates {
+		entries[i] = c.url + " " + strconv.Itoa(c.width) + "w"
+	}
+
+	result := &responsiveImage{
+		Srcset: strings.Join(entries, ", "),
+		Sizes:  config.ResponsiveSizes,
+		Width:  info.Width,
+		Height: info.Height,
+	}
+
+	// 不支持WebP的浏览器使用原图，浏览器无法显示的原图格式（例如HEIC）仍使用WebP地址
+	fallback := imgURL
+	switch strings.ToLower(filepath.Ext(relPath)) {
+	case ".jpg", ".jpeg", ".png", ".gif":
+		if rel := relToUploadDir(filepath.Join(config.PicsDir, relPath)); rel != "" {
+			fallback = "/uploads/" + rel
+		}
+	}
+
+	result.HTML = fmt.Sprintf("<picture>\n"+
+		"  <source type=\"image/webp\" srcset=\"%s\" sizes=\"%s\">\n"+
+		"  <img src=\"%s\" width=\"%d\" height=\"%d\" alt=\"\" loading=\"lazy\" decoding=\"async\">\n"+
+		"</picture>",
+		html.EscapeString(result.Srcset), html.EscapeString(result.Sizes),
+		html.EscapeString(fallback), result.Width, result.Height)
+	return result
+}
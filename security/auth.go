@@ -75,22 +75,28 @@ func CheckPasswordHash(password, hash string) bool {
 	return err == nil
 }
 
+// Authenticated 检查请求是否带有有效的登录令牌，令牌无效时清除Cookie
+func Authenticated(c *gin.Context, cfg *config.Config) bool {
+	// 从Cookie中获取令牌
+	tokenCookie, err := c.Cookie("auth_token")
+	if err != nil {
+		return false
+	}
+
+	// 验证令牌
+	valid, err := ValidateToken(tokenCookie, cfg)
+	if err != nil || !valid {
+		// 清除无效的令牌
+		c.SetCookie("auth_token", "", -1, "/", "", false, true)
+		return false
+	}
+	return true
+}
+
 // AuthMiddleware 验证JWT令牌的中间件
 func AuthMiddleware(cfg *config.Config) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// 从Cookie中获取令牌
-		tokenCookie, err := c.Cookie("auth_token")
-		if err != nil {
-			c.Redirect(http.StatusSeeOther, "/login")
-			c.Abort()
-			return
-		}
-
-		// 验证令牌
-		valid, err := ValidateToken(tokenCookie, cfg)
-		if err != nil || !valid {
-			// 清除无效的令牌
-			c.SetCookie("auth_token", "", -1, "/", "", false, true)
+		if !Authenticated(c, cfg) {
 			c.Redirect(http.StatusSeeOther, "/login")
 			c.Abort()
 			return
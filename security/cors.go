@@ -0,0 +1,107 @@
+package security
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/suixinio/webp-img/config"
+)
+
+// CORSMiddleware 根据配置为跨域请求添加 CORS 响应头，并直接响应预检请求
+func CORSMiddleware(cfg *config.Config) gin.HandlerFunc {
+	allowMethods := strings.Join(cfg.CORSAllowedMethods, ", ")
+	allowHeaders := strings.Join(cfg.CORSAllowedHeaders, ", ")
+	exposeHeaders := strings.Join(cfg.CORSExposedHeaders, ", ")
+	maxAge := strconv.Itoa(int(cfg.CORSMaxAge.Seconds()))
+	echoHeaders := len(cfg.CORSAllowedHeaders) == 1 && cfg.CORSAllowedHeaders[0] == "*"
+
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" {
+			// 非跨域请求，无需处理
+			c.Next()
+			return
+		}
+
+		// 响应内容随来源不同而不同，需告知缓存
+		c.Writer.Header().Add("Vary", "Origin")
+
+		preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+
+		if !isOriginAllowed(origin, cfg.CORSAllowedOrigins) {
+			if preflight {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+			// 不添加任何 CORS 头，由浏览器拦截响应
+			c.Next()
+			return
+		}
+
+		// 允许任意来源时只返回通配符，不回显来源，也不允许携带凭据（配置加载时已关闭）
+		if allowsAnyOrigin(cfg.CORSAllowedOrigins) {
+			c.Header("Access-Control-Allow-Origin", "*")
+		} else {
+			c.Header("Access-Control-Allow-Origin", origin)
+			if cfg.CORSAllowCredentials {
+				c.Header("Access-Control-Allow-Credentials", "true")
+			}
+		}
+
+		if !preflight {
+			if exposeHeaders != "" {
+				c.Header("Access-Control-Expose-Headers", exposeHeaders)
+			}
+			c.Next()
+			return
+		}
+
+		// 预检请求：返回允许的方法和请求头后直接结束
+		c.Writer.Header().Add("Vary", "Access-Control-Request-Method")
+		c.Writer.Header().Add("Vary", "Access-Control-Request-Headers")
+		c.Header("Access-Control-Allow-Methods", allowMethods)
+		if echoHeaders {
+			if requested := c.GetHeader("Access-Control-Request-Headers"); requested != "" {
+				c.Header("Access-Control-Allow-Headers", requested)
+			}
+		} else if allowHeaders != "" {
+			c.Header("Access-Control-Allow-Headers", allowHeaders)
+		}
+		c.Header("Access-Control-Max-Age", maxAge)
+		c.AbortWithStatus(http.StatusNoContent)
+	}
+}
+
+// allowsAnyOrigin 检查是否配置了允许任意来源
+func allowsAnyOrigin(allowed []string) bool {
+	for _, o := range allowed {
+		if o == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// isOriginAllowed 检查来源是否在允许列表中，支持 https://*.example.com 形式的子域名通配
+func isOriginAllowed(origin string, allowed []string) bool {
+	origin = strings.ToLower(origin)
+	for _, o := range allowed {
+		o = strings.ToLower(strings.TrimSuffix(o, "/"))
+		if o == "*" || o == origin {
+			return true
+		}
+
+		// 处理子域名通配：scheme://*.domain
+		if idx := strings.Index(o, "://*."); idx >= 0 {
+			scheme := o[:idx+3]
+			suffix := o[idx+4:] // 保留前导的点
+			if strings.HasPrefix(origin, scheme) && strings.HasSuffix(origin, suffix) &&
+				len(origin) > len(scheme)+len(suffix) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,151 @@
+package security
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+
+	"github.com/suixinio/webp-img/config"
+)
+
+var (
+	// ErrBlockedAddress 目标地址属于内网、本机等禁止访问的范围
+	ErrBlockedAddress = errors.New("不允许访问内网地址")
+	// ErrTooManyRedirects 重定向次数超过限制
+	ErrTooManyRedirects = errors.New("重定向次数过多")
+	// ErrInvalidRemoteURL 地址不是有效的 http/https URL
+	ErrInvalidRemoteURL = errors.New("只支持 http 和 https 地址")
+)
+
+// 除 netip.Addr 方法已识别的私有、回环、链路本地地址之外，默认禁止访问的网段
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),     // 本网络
+	netip.MustParsePrefix("100.64.0.0/10"), // 运营商级NAT
+	netip.MustParsePrefix("192.0.0.0/24"),  // IETF协议分配
+	netip.MustParsePrefix("198.18.0.0/15"), // 基准测试
+	netip.MustParsePrefix("240.0.0.0/4"),   // 保留地址和广播地址
+	netip.MustParsePrefix("64:ff9b::/96"),  // NAT64，可能映射到内网IPv4地址
+}
+
+// IsBlockedAddr 判断地址是否属于默认禁止访问的范围
+func IsBlockedAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() || addr.IsMulticast() {
+		return true
+	}
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteAllowlist 允许访问的内网主机名和网段
+type remoteAllowlist struct {
+	hosts    map[string]bool
+	prefixes []netip.Prefix
+}
+
+// parseRemoteAllowlist 解析允许列表，每项可以是主机名、IP或CIDR网段
+func parseRemoteAllowlist(entries []string) *remoteAllowlist {
+	allow := &remoteAllowlist{hosts: make(map[string]bool)}
+	for _, entry := range entries {
+		entry = strings.TrimSuffix(strings.ToLower(entry), ".")
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			allow.prefixes = append(allow.prefixes, prefix.Masked())
+		} else if addr, err := netip.ParseAddr(entry); err == nil {
+			allow.prefixes = append(allow.prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+		} else if entry != "" {
+			allow.hosts[entry] = true
+		}
+	}
+	return allow
+}
+
+// allowsHost 判断主机名是否在允许列表中
+func (a *remoteAllowlist) allowsHost(host string) bool {
+	return a.hosts[strings.TrimSuffix(strings.ToLower(host), ".")]
+}
+
+// allowsAddr 判断IP是否在允许的网段中
+func (a *remoteAllowlist) allowsAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range a.prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseRemoteURL 检查并解析远程图片地址，只允许 http 和 https
+func ParseRemoteURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return nil, ErrInvalidRemoteURL
+	}
+	return u, nil
+}
+
+// NewRemoteClient 创建下载远程图片使用的HTTP客户端
+// 在建立连接时检查解析后的IP，防止通过DNS重绑定或重定向访问内网地址（SSRF）；
+// 不使用环境变量中的代理，否则无法检查实际访问的地址
+func NewRemoteClient(cfg *config.Config) *http.Client {
+	allow := parseRemoteAllowlist(cfg.RemoteAllowedHosts)
+
+	// 允许列表中的主机名不检查解析结果，其余连接在拨号前检查实际的IP
+	plainDialer := &net.Dialer{Timeout: cfg.RemoteTimeout}
+	checkedDialer := &net.Dialer{
+		Timeout: cfg.RemoteTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return ErrBlockedAddress
+			}
+			addr, err := netip.ParseAddr(host)
+			if err != nil {
+				return ErrBlockedAddress
+			}
+			if IsBlockedAddr(addr) && !allow.allowsAddr(addr) {
+				return fmt.Errorf("%w: %s", ErrBlockedAddress, addr)
+			}
+			return nil
+		},
+	}
+
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+			if host, _, err := net.SplitHostPort(address); err == nil && allow.allowsHost(host) {
+				return plainDialer.DialContext(ctx, network, address)
+			}
+			return checkedDialer.DialContext(ctx, network, address)
+		},
+		TLSHandshakeTimeout:   cfg.RemoteTimeout,
+		ResponseHeaderTimeout: cfg.RemoteTimeout,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       cfg.RemoteTimeout,
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   cfg.RemoteTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > cfg.RemoteMaxRedirects {
+				return ErrTooManyRedirects
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return ErrInvalidRemoteURL
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,223 @@
+package security
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SVGContentSecurityPolicy 直接访问SVG时使用的内容安全策略
+// 禁止脚本和所有外部资源，只允许内联样式和data:图片，并将文档放入沙箱
+const SVGContentSecurityPolicy = "default-src 'none'; style-src 'unsafe-inline'; img-src data:; sandbox"
+
+// svgForbiddenElements 会被整个移除的元素（包括其子元素）
+var svgForbiddenElements = map[string]bool{
+	"script":        true,
+	"foreignobject": true,
+	"iframe":        true,
+	"embed":         true,
+	"object":        true,
+	"audio":         true,
+	"video":         true,
+	"handler":       true,
+	"listener":      true,
+}
+
+// charDataEscaper 转义文本内容，保留换行等空白以免改变排版
+var charDataEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// SetSVGHeaders 为SVG响应添加内容安全策略，防止同源访问时执行嵌入的脚本
+func SetSVGHeaders(c *gin.Context) {
+	c.Header("Content-Security-Policy", SVGContentSecurityPolicy)
+	c.Header("X-Content-Type-Options", "nosniff")
+}
+
+// SVGHeadersMiddleware 为静态文件服务中的SVG文件添加内容安全策略
+func SVGHeadersMiddleware(c *gin.Context) {
+	if strings.EqualFold(filepath.Ext(c.Request.URL.Path), ".svg") {
+		SetSVGHeaders(c)
+	}
+	c.Next()
+}
+
+// SanitizeSVG 移除SVG中的脚本、事件处理属性和外部引用，返回重新序列化的文档
+// 只允许引用文档内部的片段（#id）和data:图片，DOCTYPE、处理指令和注释一律移除
+func SanitizeSVG(data []byte) ([]byte, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	var out bytes.Buffer
+	out.WriteString(xml.Header)
+
+	skipDepth := 0 // 大于0时表示正在跳过被移除元素的子树
+	sawRoot := false
+	var stack []string
+
+	for {
+		// RawToken 不做命名空间转换，保留原始前缀（例如 xlink:href）
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("解析SVG失败: %w", err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			if skipDepth > 0 {
+				skipDepth++
+				continue
+			}
+			if len(stack) == 0 {
+				if sawRoot || !strings.EqualFold(t.Name.Local, "svg") {
+					return nil, errors.New("根元素不是svg")
+				}
+				sawRoot = true
+			}
+			if svgForbiddenElements[strings.ToLower(t.Name.Local)] || animatesReference(t) {
+				skipDepth = 1
+				continue
+			}
+
+			stack = append(stack, rawName(t.Name))
+			out.WriteByte('<')
+			out.WriteString(rawName(t.Name))
+			for _, attr := range t.Attr {
+				if !svgAttrAllowed(attr) {
+					continue
+				}
+				out.WriteByte(' ')
+				out.WriteString(rawName(attr.Name))
+				out.WriteString(`="`)
+				xml.EscapeText(&out, []byte(attr.Value))
+				out.WriteByte('"')
+			}
+			out.WriteByte('>')
+
+		case xml.EndElement:
+			if skipDepth > 0 {
+				skipDepth--
+				continue
+			}
+			if len(stack) == 0 {
+				return nil, errors.New("SVG元素未正确闭合")
+			}
+			out.WriteString("</")
+			out.WriteString(stack[len(stack)-1])
+			out.WriteByte('>')
+			stack = stack[:len(stack)-1]
+
+		case xml.CharData:
+			if skipDepth > 0 || len(stack) == 0 {
+				continue
+			}
+			// style元素中的外部引用无法逐条移除，整段丢弃
+			if strings.EqualFold(localName(stack[len(stack)-1]), "style") && !cssSafe(string(t)) {
+				continue
+			}
+			charDataEscaper.WriteString(&out, string(t))
+
+		default:
+			// 注释、处理指令（例如 xml-stylesheet）和DOCTYPE（可能定义实体）全部丢弃
+		}
+	}
+
+	if !sawRoot || len(stack) != 0 {
+		return nil, errors.New("不是完整的SVG文档")
+	}
+	return out.Bytes(), nil
+}
+
+// svgAttrAllowed 判断属性是否可以保留
+func svgAttrAllowed(attr xml.Attr) bool {
+	name := strings.ToLower(attr.Name.Local)
+	value := strings.TrimSpace(attr.Value)
+
+	// 事件处理属性，例如 onload、onclick
+	if strings.HasPrefix(name, "on") {
+		return false
+	}
+
+	switch name {
+	case "href", "src":
+		return isInternalReference(value)
+	case "style":
+		return cssSafe(value)
+	}
+
+	// 其他属性中的 url(...) 引用，例如 fill="url(#gradient)"
+	if strings.Contains(strings.ToLower(value), "url(") {
+		return cssSafe(value)
+	}
+	return true
+}
+
+// animatesReference 判断动画元素是否会修改链接属性，例如 <set attributeName="href" to="javascript:...">
+func animatesReference(t xml.StartElement) bool {
+	switch strings.ToLower(t.Name.Local) {
+	case "set", "animate":
+	default:
+		return false
+	}
+	for _, attr := range t.Attr {
+		if strings.EqualFold(attr.Name.Local, "attributeName") {
+			target := strings.ToLower(attr.Value)
+			return strings.HasSuffix(target, "href") || strings.HasPrefix(target, "on")
+		}
+	}
+	return false
+}
+
+// isInternalReference 判断链接是否只引用文档内部的片段或内嵌的图片数据
+func isInternalReference(value string) bool {
+	lower := strings.ToLower(strings.Join(strings.Fields(value), ""))
+	if strings.HasPrefix(lower, "#") {
+		return true
+	}
+	for _, prefix := range []string{"data:image/png", "data:image/jpeg", "data:image/gif", "data:image/webp"} {
+		if strings.HasPrefix(lower, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// cssSafe 判断CSS文本中是否只包含内部的 url(#id) 引用，且没有 @import 和表达式
+// 包含反斜杠转义时无法可靠识别关键字，直接视为不安全
+func cssSafe(css string) bool {
+	lower := strings.ToLower(strings.Join(strings.Fields(css), ""))
+	if strings.ContainsRune(lower, '\\') || strings.Contains(lower, "@import") || strings.Contains(lower, "expression(") || strings.Contains(lower, "javascript:") {
+		return false
+	}
+	for rest := lower; ; {
+		i := strings.Index(rest, "url(")
+		if i < 0 {
+			return true
+		}
+		rest = strings.TrimLeft(rest[i+4:], `'"`)
+		if !isInternalReference(rest) {
+			return false
+		}
+	}
+}
+
+// rawName 返回带原始前缀的名称
+func rawName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return name.Space + ":" + name.Local
+}
+
+// localName 去掉名称中的前缀
+func localName(name string) string {
+	if i := strings.IndexByte(name, ':'); i >= 0 {
+		return name[i+1:]
+	}
+	return name
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/suixinio/webp-img/security"
+)
+
+// serveFile 以流式方式提供文件，支持Range/206、条件请求和HEAD
+// downloadName 不为空时以附件形式下载，并使用该文件名
+func serveFile(c *gin.Context, path, contentType, downloadName string) {
+	f, err := os.Open(path)
+	if err != nil {
+		log.Printf("打开文件失败: %v", err)
+		c.Status(http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	// 预先设置内容类型，避免ServeContent读取文件头进行嗅探
+	if contentType != "" {
+		c.Header("Content-Type", contentType)
+	}
+	if contentType == "image/svg+xml" {
+		security.SetSVGHeaders(c)
+	}
+	if downloadName != "" {
+		c.Header("Content-Disposition", attachmentDisposition(downloadName))
+	}
+
+	http.ServeContent(c.Writer, c.Request, filepath.Base(path), info.ModTime(), f)
+}
+
+// attachmentDisposition 生成附件形式的Content-Disposition头
+// 同时提供ASCII回退文件名和RFC 5987编码的UTF-8文件名
+func attachmentDisposition(name string) string {
+	var fallback strings.Builder
+	for _, r := range name {
+		if r < 0x20 || r >= 0x7f || r == '"' || r == '\\' || r == '/' {
+			fallback.WriteByte('_')
+		} else {
+			fallback.WriteRune(r)
+		}
+	}
+	return fmt.Sprintf("attachment; filename=\"%s\"; filename*=UTF-8''%s", fallback.String(), encodeRFC5987(name))
+}
+
+// encodeRFC5987 按RFC 5987的attr-char规则对文件名进行百分号编码
+func encodeRFC5987(s string) string {
+	const attrChars = "!#$&+-.^_`|~"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if ('a' <= ch && ch <= 'z') || ('A' <= ch && ch <= 'Z') || ('0' <= ch && ch <= '9') ||
+			strings.IndexByte(attrChars, ch) >= 0 {
+			b.WriteByte(ch)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", ch)
+		}
+	}
+	return b.String()
+}
+
+// sniffImageType 读取文件头判断真实的图片格式，返回 gif/png/jpeg/webp/heif/jxl/tiff/bmp 或空字符串
+func sniffImageType(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	header := make([]byte, 12)
+	n, _ := io.ReadFull(f, header)
+	return sniffImageHeader(header[:n])
+}
+
+// sniffImageHeader 根据文件开头的字节判断图片格式，至少需要12个字节才能识别所有格式
+func sniffImageHeader(header []byte) string {
+	switch {
+	case len(header) >= 3 && string(header[:3]) == "GIF":
+		return "gif"
+	case len(header) >= 8 && string(header[:8]) == "\x89PNG\r\n\x1a\n":
+		return "png"
+	case len(header) >= 3 && header[0] == 0xff && header[1] == 0xd8 && header[2] == 0xff:
+		return "jpeg"
+	case len(header) >= 12 && string(header[:4]) == "RIFF" && string(header[8:12]) == "WEBP":
+		return "webp"
+	case len(header) >= 12 && string(header[4:8]) == "ftyp":
+		switch string(header[8:12]) {
+		case "heic", "heix", "heim", "heis", "hevc", "hevx", "mif1", "msf1":
+			return "heif"
+		}
+	case len(header) >= 2 && header[0] == 0xff && header[1] == 0x0a,
+		len(header) >= 12 && string(header[4:8]) == "JXL ":
+		return "jxl"
+	case len(header) >= 4 && (string(header[:4]) == "II*\x00" || string(header[:4]) == "MM\x00*"):
+		return "tiff"
+	case len(header) >= 2 && string(header[:2]) == "BM":
+		return "bmp"
+	}
+	return ""
+}
+
+// isAnimatedGIF 流式扫描GIF数据块，发现第二帧即返回，无需解码整个文件
+func isAnimatedGIF(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+
+	// 文件头(6字节) + 逻辑屏幕描述符(7字节)
+	header := make([]byte, 13)
+	if _, err := io.ReadFull(r, header); err != nil || string(header[:3]) != "GIF" {
+		return false
+	}
+	// 跳过全局颜色表
+	if header[10]&0x80 != 0 {
+		if _, err := r.Discard(3 * (1 << (int(header[10]&0x07) + 1))); err != nil {
+			return false
+		}
+	}
+
+	frames := 0
+	for {
+		blockType, err := r.ReadByte()
+		if err != nil {
+			return false
+		}
+
+		switch blockType {
+		case 0x2c: // 图像描述符
+			frames++
+			if frames > 1 {
+				return true
+			}
+			desc := make([]byte, 9)
+			if _, err := io.ReadFull(r, desc); err != nil {
+				return false
+			}
+			// 跳过局部颜色表
+			if desc[8]&0x80 != 0 {
+				if _, err := r.Discard(3 * (1 << (int(desc[8]&0x07) + 1))); err != nil {
+					return false
+				}
+			}
+			// LZW最小码长
+			if _, err := r.ReadByte(); err != nil {
+				return false
+			}
+			if !skipGIFSubBlocks(r) {
+				return false
+			}
+		case 0x21: // 扩展块
+			if _, err := r.ReadByte(); err != nil {
+				return false
+			}
+			if !skipGIFSubBlocks(r) {
+				return false
+			}
+		default: // 0x3b 结束符或无法识别的数据
+			return false
+		}
+	}
+}
+
+// skipGIFSubBlocks 跳过GIF的数据子块序列
+func skipGIFSubBlocks(r *bufio.Reader) bool {
+	for {
+		size, err := r.ReadByte()
+		if err != nil {
+			return false
+		}
+		if size == 0 {
+			return true
+		}
+		if _, err := r.Discard(int(size)); err != nil {
+			return false
+		}
+	}
+}
+
+// isAnimatedWebP 检查WebP扩展头（VP8X）中的动画标志，只读取文件头部
+func isAnimatedWebP(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	// RIFF头(12字节) + VP8X数据块头(8字节) + 标志位(1字节)
+	header := make([]byte, 21)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return false
+	}
+	return string(header[:4]) == "RIFF" && string(header[8:12]) == "WEBP" &&
+		string(header[12:16]) == "VP8X" && header[20]&0x02 != 0
+}
@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Metadata 存储单张图片的元数据
+type Metadata struct {
+	OriginalName string    `json:"original_name"` // 用户上传时的原始文件名
+	ContentType  string    `json:"content_type"`  // 上传时的内容类型
+	OriginalSize int64     `json:"original_size"` // 原始文件大小（字节）
+	UploadedAt   time.Time `json:"uploaded_at"`   // 上传时间
+
+	EditedAt *time.Time `json:"edited_at,omitempty"` // 最近一次编辑的时间，未编辑过时为空
+
+	SourceURL string `json:"source_url,omitempty"` // 从远程URL上传时的地址
+
+	SHA256 string `json:"sha256,omitempty"` // 原图的SHA-256校验和，供完整性校验使用；较早上传的图片没有记录
+
+	ColorProfile  string `json:"color_profile,omitempty"`  // 原图嵌入的ICC配置文件描述
+	ColorHandling string `json:"color_handling,omitempty"` // ICC配置文件的处理结果: none/kept/converted/stripped
+
+	BlurHash      string `json:"blurhash,omitempty"`       // 加载前占位用的BlurHash
+	LQIP          string `json:"lqip,omitempty"`           // 低质量占位图的 data: URL
+	DominantColor string `json:"dominant_color,omitempty"` // 主色调，形如 #rrggbb
+}
+
+// Store 以JSON旁路文件的形式保存图片元数据，目录结构与原始图片目录一致
+type Store struct {
+	dir string
+}
+
+// NewStore 创建元数据存储，dir 为元数据根目录
+func NewStore(dir string) *Store {
+	return &Store{dir: dir}
+}
+
+// Dir 返回元数据根目录
+func (s *Store) Dir() string {
+	return s.dir
+}
+
+// path 根据图片相对路径计算元数据文件路径，忽略扩展名以便原图和WebP共用同一条记录
+func (s *Store) path(relPath string) string {
+	// 以根目录为基准清理路径，防止通过 ../ 访问元数据目录之外的文件
+	relPath = filepath.Clean("/" + relPath)
+	return filepath.Join(s.dir, strings.TrimSuffix(relPath, filepath.Ext(relPath))+".json")
+}
+
+// Load 读取图片元数据，记录不存在时返回 os.ErrNotExist
+func (s *Store) Load(relPath string) (*Metadata, error) {
+	data, err := os.ReadFile(s.path(relPath))
+	if err != nil {
+		return nil, err
+	}
+
+	var meta Metadata
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("解析元数据失败: %w", err)
+	}
+	return &meta, nil
+}
+
+// Save 保存图片元数据，先写入临时文件再重命名，避免读取到写了一半的记录
+func (s *Store) Save(relPath string, meta *Metadata) error {
+	dst := s.path(relPath)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return fmt.Errorf("创建元数据目录失败: %w", err)
+	}
+
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化元数据失败: %w", err)
+	}
+
+	tmp := dst + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("写入元数据失败: %w", err)
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("保存元数据失败: %w", err)
+	}
+	return nil
+}
+
+// Delete 删除图片元数据，记录不存在时不返回错误
+func (s *Store) Delete(relPath string) error {
+	if err := os.Remove(s.path(relPath)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("删除元数据失败: %w", err)
+	}
+	return nil
+}
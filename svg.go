@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+
+	"github.com/suixinio/webp-img/security"
+)
+
+// sanitizeSVGFile 清理SVG中的脚本和外部引用，直接替换原文件
+func sanitizeSVGFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("读取SVG失败: %w", err)
+	}
+
+	cleaned, err := security.SanitizeSVG(data)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, cleaned, 0644); err != nil {
+		return fmt.Errorf("写入清理后的SVG失败: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("替换SVG失败: %w", err)
+	}
+
+	log.Printf("已清理SVG: %s (%d -> %d 字节)", path, len(data), len(cleaned))
+	return nil
+}
+
+// convertSVG 按配置的宽度将SVG栅格化为WebP
+// 第一个宽度写入默认WebP路径，其余宽度作为衍生版本；未启用栅格化时不生成WebP，返回nil结果
+func convertSVG(srcPath, dstPath string, opts *EncodeOptions) (*ConvertResult, error) {
+	if !config.SVGRasterize {
+		log.Printf("未启用SVG栅格化，直接提供清理后的SVG: %s", srcPath)
+		return nil, nil
+	}
+
+	widths := config.SVGRasterWidths
+	if len(widths) == 0 {
+		widths = []int{0} // 使用SVG自身尺寸
+	}
+
+	var result *ConvertResult
+	for i, width := range widths {
+		target := dstPath
+		if i > 0 {
+			target = variantPath(dstPath, widthName(width))
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				log.Printf("创建衍生版本目录失败: %v", err)
+				continue
+			}
+		}
+
+		pngPath, err := rasterizeSVG(srcPath, filepath.Dir(dstPath), width)
+		if err != nil {
+			if i == 0 {
+				return nil, err
+			}
+			log.Printf("栅格化SVG失败 (宽度 %d): %v", width, err)
+			continue
+		}
+
+		converted, err := convertToWebP(pngPath, target, opts)
+		os.Remove(pngPath)
+		if i == 0 {
+			if err != nil {
+				return nil, err
+			}
+			result = converted
+		} else if err != nil {
+			log.Printf("转换SVG衍生版本失败 (宽度 %d): %v", width, err)
+		}
+	}
+	return result, nil
+}
+
+// rasterizeSVG 使用rsvg-convert（或ImageMagick）将SVG渲染为临时PNG文件
+// width 为0时使用SVG自身尺寸，否则按宽度等比缩放
+func rasterizeSVG(srcPath, tmpDir string, width int) (string, error) {
+	f, err := os.CreateTemp(tmpDir, ".raster-*.png")
+	if err != nil {
+		return "", fmt.Errorf("创建临时文件失败: %w", err)
+	}
+	f.Close()
+	dstPath := f.Name()
+
+	var cmd *exec.Cmd
+	if _, err := exec.LookPath("rsvg-convert"); err == nil {
+		args := []string{"-f", "png", "-o", dstPath}
+		if width > 0 {
+			args = append(args, "-w", strconv.Itoa(width))
+		}
+		cmd = exec.Command("rsvg-convert", append(args, srcPath)...)
+	} else if _, err := exec.LookPath("magick"); err == nil {
+		args := []string{"-background", "none", srcPath}
+		if width > 0 {
+			args = append(args, "-resize", strconv.Itoa(width)+"x")
+		}
+		cmd = exec.Command("magick", append(args, "png:"+dstPath)...)
+	} else {
+		os.Remove(dstPath)
+		return "", fmt.Errorf("未找到rsvg-convert或magick工具，无法栅格化SVG")
+	}
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		os.Remove(dstPath)
+		return "", fmt.Errorf("栅格化SVG失败: %v, 输出: %s", err, output)
+	}
+	return dstPath, nil
+}
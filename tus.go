@@ -0,0 +1,447 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// tus 可续传上传协议 (https://tus.io/protocols/resumable-upload) 1.0.0 版本
+// 支持 creation、creation-with-upload、expiration 和 termination 扩展
+const (
+	tusVersion       = "1.0.0"
+	tusExtensions    = "creation,creation-with-upload,expiration,termination"
+	tusContentType   = "application/offset+octet-stream"
+	tusUploadsPrefix = "/api/upload/tus/"
+)
+
+// 上传ID的格式，防止通过ID访问临时目录之外的文件
+var tusIDPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// 每个上传的锁，同一个上传的写入、完成和删除依次进行
+var tusLocks sync.Map // 上传ID -> *sync.Mutex
+
+// tusUpload 一个可续传上传的信息，与数据文件一起保存在临时目录中
+type tusUpload struct {
+	ID        string            `json:"id"`
+	Length    int64             `json:"length"`           // 文件总大小
+	Metadata  map[string]string `json:"metadata"`         // Upload-Metadata 中的键值，例如 filename、filetype 和编码参数
+	CreatedAt time.Time         `json:"created_at"`       // 创建时间
+	ExpiresAt time.Time         `json:"expires_at"`       // 过期时间，过期后删除
+	Result    map[string]any    `json:"result,omitempty"` // 完成后上传接口的返回内容
+}
+
+// tusInfoPath 返回上传信息文件的路径
+func tusInfoPath(id string) string {
+	return filepath.Join(config.ChunkDir, id+".json")
+}
+
+// tusDataPath 返回已接收数据的路径
+func tusDataPath(id string) string {
+	return filepath.Join(config.ChunkDir, id+".part")
+}
+
+// lockTusUpload 锁定一个上传，返回解锁函数
+func lockTusUpload(id string) func() {
+	mu, _ := tusLocks.LoadOrStore(id, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock
+}
+
+// loadTusUpload 读取上传信息，不存在或已过期时返回 os.ErrNotExist
+func loadTusUpload(id string) (*tusUpload, error) {
+	if !tusIDPattern.MatchString(id) {
+		return nil, os.ErrNotExist
+	}
+	data, err := os.ReadFile(tusInfoPath(id))
+	if err != nil {
+		return nil, err
+	}
+	var upload tusUpload
+	if err := json.Unmarshal(data, &upload); err != nil {
+		return nil, fmt.Errorf("解析上传信息失败: %w", err)
+	}
+	if time.Now().After(upload.ExpiresAt) {
+		return nil, os.ErrNotExist
+	}
+	return &upload, nil
+}
+
+// save 保存上传信息，先写入临时文件再重命名
+func (u *tusUpload) save() error {
+	data, err := json.MarshalIndent(u, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := tusInfoPath(u.ID) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, tusInfoPath(u.ID))
+}
+
+// offset 返回已接收的字节数，完成后等于文件总大小
+func (u *tusUpload) offset() int64 {
+	if u.Result != nil {
+		return u.Length
+	}
+	info, err := os.Stat(tusDataPath(u.ID))
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// remove 删除上传的数据和信息
+func (u *tusUpload) remove() {
+	for _, path := range []string{tusDataPath(u.ID), tusInfoPath(u.ID), tusInfoPath(u.ID) + ".tmp"} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Printf("删除可续传上传文件失败: %v", err)
+		}
+	}
+}
+
+// parseTusMetadata 解析 Upload-Metadata 头，格式为逗号分隔的 "键 base64值"，值可以省略
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, encoded, _ := strings.Cut(pair, " ")
+		value, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("Upload-Metadata 中 %s 的值不是有效的base64", key)
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
+
+// tusEncodeOptions 从上传的元数据中读取编码参数，参数名与上传表单相同
+func tusEncodeOptions(metadata map[string]string) (*EncodeOptions, error) {
+	return parseEncodeParams(func(key string) (string, bool) {
+		value, ok := metadata[key]
+		return value, ok
+	})
+}
+
+// tusHeaders 设置所有tus响应都需要的头
+func tusHeaders(c *gin.Context) {
+	c.Header("Tus-Resumable", tusVersion)
+	c.Header("Cache-Control", "no-store")
+}
+
+// tusVersionMiddleware 检查客户端使用的协议版本，OPTIONS 和查询结果的 GET 请求不需要
+func tusVersionMiddleware(c *gin.Context) {
+	tusHeaders(c)
+	method := c.Request.Method
+	if method != http.MethodOptions && method != http.MethodGet && c.GetHeader("Tus-Resumable") != tusVersion {
+		c.Header("Tus-Version", tusVersion)
+		c.AbortWithStatusJSON(http.StatusPreconditionFailed, gin.H{"error": "不支持的tus协议版本"})
+		return
+	}
+	c.Next()
+}
+
+// tusOptionsHandler 返回服务器支持的协议版本、扩展和最大文件大小
+func tusOptionsHandler(c *gin.Context) {
+	c.Header("Tus-Version", tusVersion)
+	c.Header("Tus-Extension", tusExtensions)
+	c.Header("Tus-Max-Size", strconv.FormatInt(config.ChunkMaxSize, 10))
+	c.Status(http.StatusNoContent)
+}
+
+// tusCreateHandler 创建一个可续传上传，返回之后上传数据使用的地址
+// 请求体中带有数据时（creation-with-upload）同时写入第一段数据
+func tusCreateHandler(c *gin.Context) {
+	length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "缺少有效的 Upload-Length"})
+		return
+	}
+	if length > config.ChunkMaxSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("文件超过大小限制 (%d MB)", config.ChunkMaxSize/(1024*1024))})
+		return
+	}
+
+	metadata, err := parseTusMetadata(c.GetHeader("Upload-Metadata"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// 编码参数在创建时检查，避免上传完成后才发现参数错误
+	if _, err := tusEncodeOptions(metadata); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		log.Printf("生成上传ID失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建上传失败"})
+		return
+	}
+	now := time.Now()
+	upload := &tusUpload{
+		ID:        hex.EncodeToString(id),
+		Length:    length,
+		Metadata:  metadata,
+		CreatedAt: now,
+		ExpiresAt: now.Add(config.ChunkExpiry),
+	}
+
+	unlock := lockTusUpload(upload.ID)
+	defer unlock()
+
+	if err := os.WriteFile(tusDataPath(upload.ID), nil, 0644); err != nil {
+		log.Printf("创建上传数据文件失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建上传失败"})
+		return
+	}
+	if err := upload.save(); err != nil {
+		log.Printf("保存上传信息失败: %v", err)
+		upload.remove()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建上传失败"})
+		return
+	}
+	log.Printf("创建可续传上传 %s: %s (%d 字节)", upload.ID, metadata["filename"], length)
+
+	c.Header("Location", tusUploadsPrefix+upload.ID)
+	c.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	if c.ContentType() == tusContentType && c.Request.ContentLength != 0 {
+		tusWrite(c, upload, 0, http.StatusCreated)
+		return
+	}
+	c.Header("Upload-Offset", "0")
+	c.Status(http.StatusCreated)
+}
+
+// tusHeadHandler 返回已接收的字节数，客户端据此从中断的位置继续上传
+func tusHeadHandler(c *gin.Context) {
+	upload, err := loadTusUpload(c.Param("id"))
+	if err != nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	c.Header("Upload-Offset", strconv.FormatInt(upload.offset(), 10))
+	c.Header("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	c.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	c.Status(http.StatusOK)
+}
+
+// tusStatusHandler 以JSON返回上传进度，完成后包含与 /upload 相同的结果
+// 用于最后一次 PATCH 的响应丢失时取回图片地址
+func tusStatusHandler(c *gin.Context) {
+	upload, err := loadTusUpload(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "上传不存在或已过期"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"id":         upload.ID,
+		"offset":     upload.offset(),
+		"length":     upload.Length,
+		"expires_at": upload.ExpiresAt,
+		"completed":  upload.Result != nil,
+		"result":     upload.Result,
+	})
+}
+
+// tusPatchHandler 从指定的偏移位置追加数据，接收完整后按普通上传的流程保存和转换
+func tusPatchHandler(c *gin.Context) {
+	if c.ContentType() != tusContentType {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Content-Type 必须是 " + tusContentType})
+		return
+	}
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "缺少有效的 Upload-Offset"})
+		return
+	}
+
+	id := c.Param("id")
+	if !tusIDPattern.MatchString(id) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "上传不存在或已过期"})
+		return
+	}
+	unlock := lockTusUpload(id)
+	defer unlock()
+
+	upload, err := loadTusUpload(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "上传不存在或已过期"})
+		return
+	}
+	tusWrite(c, upload, offset, http.StatusNoContent)
+}
+
+// tusWrite 将请求体写入上传数据，调用方需持有该上传的锁
+// 连接中断时已接收的数据会保留，客户端通过 HEAD 获取偏移后继续上传
+func tusWrite(c *gin.Context, upload *tusUpload, offset int64, status int) {
+	if upload.Result != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "上传已完成"})
+		return
+	}
+	if current := upload.offset(); offset != current {
+		c.Header("Upload-Offset", strconv.FormatInt(current, 10))
+		c.JSON(http.StatusConflict, gin.H{"error": "Upload-Offset 与已接收的数据不一致"})
+		return
+	}
+
+	f, err := os.OpenFile(tusDataPath(upload.ID), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		log.Printf("打开上传数据文件失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "写入上传数据失败"})
+		return
+	}
+	// 多读一个字节，用于发现超出 Upload-Length 的数据
+	remaining := upload.Length - offset
+	written, err := io.Copy(f, io.LimitReader(c.Request.Body, remaining+1))
+	f.Close()
+	if written > remaining {
+		// 丢弃这一段数据，客户端可以从原来的偏移重新上传
+		if err := os.Truncate(tusDataPath(upload.ID), offset); err != nil {
+			log.Printf("回滚上传数据失败: %v", err)
+		}
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "数据超过 Upload-Length"})
+		return
+	}
+	offset += written
+	if err != nil {
+		log.Printf("接收上传数据中断 %s: 已接收 %d/%d 字节: %v", upload.ID, offset, upload.Length, err)
+	}
+	c.Header("Upload-Offset", strconv.FormatInt(offset, 10))
+	c.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+
+	if offset < upload.Length {
+		c.Status(status)
+		return
+	}
+
+	// 接收完成，按普通上传的流程验证、保存和转换
+	response, err := completeTusUpload(upload)
+	if err != nil {
+		respondUploadError(c, err)
+		return
+	}
+	if status != http.StatusCreated {
+		status = http.StatusOK
+	}
+	c.JSON(status, response)
+}
+
+// completeTusUpload 处理接收完整的上传，结果保存在上传信息中直到过期
+func completeTusUpload(upload *tusUpload) (gin.H, error) {
+	defer func() {
+		if err := os.Remove(tusDataPath(upload.ID)); err != nil && !os.IsNotExist(err) {
+			log.Printf("删除上传数据失败: %v", err)
+		}
+	}()
+
+	encodeOpts, err := tusEncodeOptions(upload.Metadata)
+	if err != nil {
+		upload.remove()
+		return nil, &uploadError{http.StatusBadRequest, err.Error()}
+	}
+	f, err := os.Open(tusDataPath(upload.ID))
+	if err != nil {
+		return nil, &uploadError{http.StatusInternalServerError, "读取上传数据失败"}
+	}
+	defer f.Close()
+
+	response, err := processUpload(&uploadSource{
+		Reader:      f,
+		Filename:    upload.Metadata["filename"],
+		ContentType: upload.Metadata["filetype"],
+	}, encodeOpts)
+	if err != nil {
+		// 文件无效时重新上传也不会成功，直接删除
+		upload.remove()
+		return nil, err
+	}
+
+	upload.Result = response
+	if err := upload.save(); err != nil {
+		log.Printf("保存上传结果失败: %v", err)
+	}
+	log.Printf("可续传上传 %s 已完成: %v", upload.ID, response["url"])
+	return response, nil
+}
+
+// tusDeleteHandler 取消上传并删除已接收的数据
+func tusDeleteHandler(c *gin.Context) {
+	id := c.Param("id")
+	if !tusIDPattern.MatchString(id) {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	unlock := lockTusUpload(id)
+	defer unlock()
+
+	upload, err := loadTusUpload(id)
+	if err != nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	upload.remove()
+	tusLocks.Delete(id)
+	c.Status(http.StatusNoContent)
+}
+
+// cleanExpiredUploads 定期删除过期的可续传上传，包括未完成的数据和已完成的结果
+func cleanExpiredUploads() {
+	interval := min(max(config.ChunkExpiry/4, time.Minute), time.Hour)
+	for {
+		removeExpiredUploads()
+		time.Sleep(interval)
+	}
+}
+
+// removeExpiredUploads 扫描临时目录，删除过期的上传，以及没有信息文件的残留数据
+func removeExpiredUploads() {
+	entries, err := os.ReadDir(config.ChunkDir)
+	if err != nil {
+		log.Printf("读取可续传上传目录失败: %v", err)
+		return
+	}
+
+	now := time.Now()
+	removed := 0
+	for _, entry := range entries {
+		id, _, _ := strings.Cut(entry.Name(), ".")
+		if entry.IsDir() || !tusIDPattern.MatchString(id) {
+			continue
+		}
+
+		unlock := lockTusUpload(id)
+		if _, err := loadTusUpload(id); err != nil {
+			// 信息文件已过期或损坏；只有残留数据时按修改时间判断，避免删除正在创建的上传
+			info, statErr := entry.Info()
+			if isRegularFile(tusInfoPath(id)) || (statErr == nil && now.Sub(info.ModTime()) > config.ChunkExpiry) {
+				(&tusUpload{ID: id}).remove()
+				tusLocks.Delete(id)
+				removed++
+			}
+		}
+		unlock()
+	}
+	if removed > 0 {
+		log.Printf("已删除 %d 个过期的可续传上传", removed)
+	}
+}
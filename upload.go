@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// JSON上传请求体的大小上限，base64编码的图片需要整体读入内存
+const maxJSONUploadSize = 64 * 1024 * 1024
+
+// base64Upload JSON上传参数
+type base64Upload struct {
+	Data     string `json:"data"`     // data: URL（data:image/png;base64,...）或纯base64内容
+	Filename string `json:"filename"` // 原始文件名，可以为空
+}
+
+// isFormRequest 判断请求体是否为表单（multipart 或 urlencoded）
+func isFormRequest(c *gin.Context) bool {
+	switch c.ContentType() {
+	case "multipart/form-data", "application/x-www-form-urlencoded":
+		return true
+	}
+	return false
+}
+
+// 上传表单中除文件外最多允许的字段数（编码参数、batch等）
+const uploadMaxFormFields = 32
+
+// errTooManyParts 上传表单中的字段或文件数量超过限制
+var errTooManyParts = errors.New("表单字段过多")
+
+// partLimitBody 在表单解析读取请求体的同时统计multipart分隔符，数量超过限制时立即返回错误，
+// 不会先把整个请求体保存到磁盘再检查文件数
+type partLimitBody struct {
+	io.ReadCloser
+	delim    []byte // "--" + boundary
+	tail     []byte // 上次读取末尾可能是分隔符前缀的部分
+	count    int
+	maxParts int
+}
+
+func (p *partLimitBody) Read(b []byte) (int, error) {
+	n, err := p.ReadCloser.Read(b)
+	if n > 0 {
+		buf := append(p.tail, b[:n]...)
+		p.count += bytes.Count(buf, p.delim)
+		keep := min(len(p.delim)-1, len(buf))
+		p.tail = append(p.tail[:0], buf[len(buf)-keep:]...)
+		// 结束分隔符也会被统计一次
+		if p.count > p.maxParts+1 {
+			return n, errTooManyParts
+		}
+	}
+	return n, err
+}
+
+// parseUploadForm 限制请求体大小和字段数量后解析multipart上传表单，失败时返回 *uploadError
+func parseUploadForm(c *gin.Context) (*multipart.Form, error) {
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, config.UploadMaxBody)
+	if _, params, err := mime.ParseMediaType(c.GetHeader("Content-Type")); err == nil && params["boundary"] != "" {
+		body = &partLimitBody{
+			ReadCloser: body,
+			delim:      []byte("--" + params["boundary"]),
+			maxParts:   config.UploadMaxFiles + uploadMaxFormFields,
+		}
+	}
+	c.Request.Body = body
+
+	form, err := c.MultipartForm()
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesErr):
+			return nil, &uploadError{http.StatusRequestEntityTooLarge, fmt.Sprintf("请求体过大，单次最多上传 %d MB", config.UploadMaxBody/(1024*1024))}
+		case errors.Is(err, errTooManyParts):
+			return nil, &uploadError{http.StatusBadRequest, fmt.Sprintf("单次最多上传 %d 个文件", config.UploadMaxFiles)}
+		}
+		return nil, &uploadError{http.StatusBadRequest, "获取文件错误"}
+	}
+	return form, nil
+}
+
+// rawUploadSource 将整个请求体作为图片，例如 curl --data-binary @a.png -H "Content-Type: image/png"
+// 文件名可以通过查询参数 filename 或 Content-Disposition 头指定
+func rawUploadSource(c *gin.Context) (*uploadSource, error) {
+	if c.Request.ContentLength == 0 {
+		return nil, &uploadError{http.StatusBadRequest, "获取文件错误"}
+	}
+
+	filename := c.Query("filename")
+	if filename == "" {
+		if _, params, err := mime.ParseMediaType(c.GetHeader("Content-Disposition")); err == nil {
+			filename = params["filename"]
+		}
+	}
+
+	return &uploadSource{
+		Reader:      c.Request.Body,
+		Filename:    filename,
+		ContentType: c.ContentType(),
+	}, nil
+}
+
+// base64UploadSource 解析JSON中base64编码的图片，支持浏览器剪贴板、canvas.toDataURL 得到的 data: URL
+func base64UploadSource(c *gin.Context) (*uploadSource, error) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxJSONUploadSize)
+
+	var req base64Upload
+	if err := c.ShouldBindJSON(&req); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return nil, &uploadError{http.StatusRequestEntityTooLarge, "请求体过大"}
+		}
+		return nil, &uploadError{http.StatusBadRequest, "无效的请求参数"}
+	}
+
+	contentType, data, err := parseDataURL(req.Data)
+	if err != nil {
+		return nil, err
+	}
+	return &uploadSource{
+		Reader:      &base64Reader{base64.NewDecoder(base64.RawStdEncoding, strings.NewReader(data))},
+		Filename:    req.Filename,
+		ContentType: contentType,
+	}, nil
+}
+
+// parseDataURL 拆分 data: URL 的内容类型和base64数据，不是 data: URL 时按纯base64处理
+// 返回的数据已去掉填充和空白，并转换为标准字母表，可以直接用 RawStdEncoding 解码
+func parseDataURL(s string) (contentType, data string, err error) {
+	s = strings.TrimSpace(s)
+	if rest, ok := strings.CutPrefix(s, "data:"); ok {
+		header, payload, found := strings.Cut(rest, ",")
+		if !found || !strings.HasSuffix(header, ";base64") {
+			return "", "", &uploadError{http.StatusBadRequest, "data URL 必须是base64编码"}
+		}
+		contentType, _, _ = mime.ParseMediaType(strings.TrimSuffix(header, ";base64"))
+		s = payload
+	}
+
+	// 去掉换行等空白，兼容URL安全的base64字母表
+	s = strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\t', '\r', '\n':
+			return -1
+		case '-':
+			return '+'
+		case '_':
+			return '/'
+		}
+		return r
+	}, s)
+	s = strings.TrimRight(s, "=")
+	if s == "" {
+		return "", "", &uploadError{http.StatusBadRequest, "获取文件错误"}
+	}
+	return contentType, s, nil
+}
+
+// base64Reader 解码base64，数据无效时返回上传错误，而不是保存文件失败
+type base64Reader struct {
+	r io.Reader
+}
+
+func (b *base64Reader) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	var corrupt base64.CorruptInputError
+	if errors.As(err, &corrupt) {
+		return n, &uploadError{http.StatusBadRequest, "base64数据无效"}
+	}
+	return n, err
+}
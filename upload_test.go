@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime/multipart"
+	"testing"
+	"testing/iotest"
+)
+
+// multipartBody 生成包含 n 个文件字段的multipart请求体
+func multipartBody(t *testing.T, n int) ([]byte, string) {
+	t.Helper()
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	for i := 0; i < n; i++ {
+		part, err := w.CreateFormFile("image", "a.png")
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write([]byte("png"))
+	}
+	w.Close()
+	return buf.Bytes(), w.Boundary()
+}
+
+func TestPartLimitBody(t *testing.T) {
+	for _, tt := range []struct {
+		files, maxParts int
+		wantErr         bool
+	}{
+		{3, 3, false},
+		{4, 3, true},
+		{0, 0, false},
+	} {
+		data, boundary := multipartBody(t, tt.files)
+		// 每次只读取一个字节，分隔符会跨越多次读取
+		body := &partLimitBody{
+			ReadCloser: io.NopCloser(iotest.OneByteReader(bytes.NewReader(data))),
+			delim:      []byte("--" + boundary),
+			maxParts:   tt.maxParts,
+		}
+		_, err := io.ReadAll(body)
+		if got := errors.Is(err, errTooManyParts); got != tt.wantErr {
+			t.Errorf("%d 个文件, 限制 %d: 错误 %v, 期望超限 %v", tt.files, tt.maxParts, err, tt.wantErr)
+		}
+	}
+}
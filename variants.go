@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// variantDir 返回一张图片的衍生版本目录，形如 variants/YY/MM/DD/timestamp
+// 以WebP路径为准，与原图扩展名无关
+func variantDir(webpPath string) string {
+	rel, err := filepath.Rel(config.WebpDir, webpPath)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		rel = filepath.Base(webpPath)
+	}
+	return filepath.Join(config.VariantDir, strings.TrimSuffix(rel, filepath.Ext(rel)))
+}
+
+// variantPath 返回指定名称的衍生版本路径，例如 w128 对应 variants/YY/MM/DD/timestamp/w128.webp
+func variantPath(webpPath, name string) string {
+	return filepath.Join(variantDir(webpPath), name+".webp")
+}
+
+// listVariants 列出一张图片已生成的所有衍生版本，返回名称到路径的映射
+func listVariants(webpPath string) map[string]string {
+	dir := variantDir(webpPath)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	variants := make(map[string]string)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") || filepath.Ext(name) != ".webp" {
+			continue
+		}
+		variants[strings.TrimSuffix(name, ".webp")] = filepath.Join(dir, name)
+	}
+	return variants
+}
+
+// ensureVariant 返回指定名称的衍生版本路径，不存在时按编码参数从原图生成
+func ensureVariant(srcPath, webpPath, name string, opts *EncodeOptions) (string, error) {
+	target := variantPath(webpPath, name)
+	if isRegularFile(target) {
+		return target, nil
+	}
+
+	dir := filepath.Dir(target)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("创建衍生版本目录失败: %w", err)
+	}
+
+	// 先写入临时文件再重命名，避免并发请求读到未写完的图片
+	tmpTarget := filepath.Join(dir, "."+name+"-"+strconv.FormatInt(time.Now().UnixNano(), 36)+".webp")
+	if _, err := convertToWebP(srcPath, tmpTarget, opts); err != nil {
+		os.Remove(tmpTarget)
+		return "", err
+	}
+	if err := os.Rename(tmpTarget, target); err != nil {
+		os.Remove(tmpTarget)
+		return "", fmt.Errorf("保存衍生版本失败: %w", err)
+	}
+
+	log.Printf("成功生成衍生版本 %s: %s", name, target)
+	return target, nil
+}
@@ -0,0 +1,520 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/gif"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/suixinio/webp-img/imaging"
+	"github.com/suixinio/webp-img/storage"
+)
+
+// 校验发现的问题类型
+const (
+	problemOriginalCorrupt  = "original_corrupt"  // 原图无法解码
+	problemOriginalMissing  = "original_missing"  // WebP没有对应的原图
+	problemWebpMissing      = "webp_missing"      // 原图没有对应的WebP
+	problemWebpInvalid      = "webp_invalid"      // WebP文件损坏或内容与原图不符
+	problemWebpFallback     = "webp_fallback"     // WebP文件实际是转换失败时复制的原图
+	problemChecksumMismatch = "checksum_mismatch" // 原图与元数据中记录的校验和不一致
+	problemChecksumMissing  = "checksum_missing"  // 元数据中没有原图的校验和
+)
+
+// problemIsWarning 判断问题是否只是提示：备用副本可以正常显示，较早上传的图片没有记录校验和
+func problemIsWarning(kind string) bool {
+	return kind == problemWebpFallback || kind == problemChecksumMissing
+}
+
+// verifySettle 最近修改的图片可能正在上传或转换，校验时跳过
+const verifySettle = time.Minute
+
+// errUnverifiable 缺少解码工具，无法确认图片能否解码
+var errUnverifiable = errors.New("缺少解码工具，无法校验")
+
+// fileSHA256 计算文件的SHA-256校验和，返回十六进制字符串
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// readImage 读取并完整解码图片，返回文件内容和按文件头识别的格式
+// 动画WebP无法解码，只检查容器结构；HEIC和JPEG XL需要外部工具，没有工具时返回 errUnverifiable
+func readImage(path string) ([]byte, string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+
+	format := sniffImageHeader(data)
+	switch format {
+	case "gif":
+		// 只解码第一帧无法发现后续帧的损坏
+		_, err = gif.DecodeAll(bytes.NewReader(data))
+	case "webp":
+		err = checkWebPData(data)
+	case "jpeg", "png", "bmp", "tiff":
+		_, _, err = image.Decode(bytes.NewReader(data))
+	case "heif", "jxl":
+		err = checkExternalDecode(path)
+	case "":
+		if !strings.EqualFold(filepath.Ext(path), ".svg") {
+			return data, "", errors.New("无法识别的图片格式")
+		}
+		format = "svg"
+		err = checkSVGData(data)
+	}
+	if err != nil && !errors.Is(err, errUnverifiable) {
+		err = fmt.Errorf("%s解码失败: %w", format, err)
+	}
+	return data, format, err
+}
+
+// checkWebPData 检查WebP文件是否完整，静态图片完整解码，动画只检查RIFF长度和帧信息
+func checkWebPData(data []byte) error {
+	if size := int64(binary.LittleEndian.Uint32(data[4:8])) + 8; size > int64(len(data)) {
+		return fmt.Errorf("文件不完整 (%d/%d 字节)", len(data), size)
+	}
+	info := imaging.Inspect(data)
+	if info.Width == 0 || info.Height == 0 {
+		return errors.New("缺少图像数据")
+	}
+	if info.Frames > 1 {
+		return nil
+	}
+	_, _, err := image.Decode(bytes.NewReader(data))
+	return err
+}
+
+// checkExternalDecode 使用与转换相同的外部工具解码一次，确认图片可以读取
+func checkExternalDecode(path string) error {
+	available := false
+	for _, tool := range externalDecoders[strings.ToLower(filepath.Ext(path))] {
+		if _, err := exec.LookPath(tool); err == nil {
+			available = true
+			break
+		}
+	}
+	if !available {
+		return errUnverifiable
+	}
+	decoded, err := decodeToPNG(path, os.TempDir())
+	if err != nil {
+		return err
+	}
+	os.Remove(decoded)
+	return nil
+}
+
+// checkSVGData 检查SVG是否为格式正确、根元素为svg的XML
+func checkSVGData(data []byte) error {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	root := ""
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if start, ok := token.(xml.StartElement); ok && root == "" {
+			root = start.Name.Local
+		}
+	}
+	if root != "svg" {
+		return errors.New("根元素不是svg")
+	}
+	return nil
+}
+
+// fallbackFormat 返回转换失败时复制到WebP路径的备用文件格式
+// 需要解码的格式复制的是解码后的PNG，其他格式复制原图本身
+func fallbackFormat(originalPath, originalFormat string) string {
+	if needsDecoding(filepath.Ext(originalPath)) {
+		return "png"
+	}
+	return originalFormat
+}
+
+// verifyProblem 一张图片的一个问题
+type verifyProblem struct {
+	Path     string `json:"path"`               // 图片相对路径
+	Kind     string `json:"kind"`               // 问题类型
+	Message  string `json:"message"`            // 问题说明
+	Warning  bool   `json:"warning,omitempty"`  // 是否只是提示
+	Repaired bool   `json:"repaired,omitempty"` // 是否已修复
+	Repair   string `json:"repair,omitempty"`   // 修复失败或无法修复的原因
+}
+
+// verifyReport 一次校验的进度和结果，任务运行期间持续更新
+type verifyReport struct {
+	Status     string          `json:"status"` // running、completed 或 failed
+	Error      string          `json:"error,omitempty"`
+	Dir        string          `json:"dir,omitempty"` // 校验的目录，为空时校验全部图片
+	Repair     bool            `json:"repair"`
+	StartedAt  time.Time       `json:"started_at"`
+	FinishedAt *time.Time      `json:"finished_at,omitempty"`
+	Total      int             `json:"total"`      // 需要校验的图片数
+	Checked    int             `json:"checked"`    // 已校验的图片数
+	OK         int             `json:"ok"`         // 没有问题的图片数
+	Skipped    int             `json:"skipped"`    // 最近修改过或无法解码而跳过的图片数
+	Repaired   int             `json:"repaired"`   // 已修复的问题数
+	Unresolved int             `json:"unresolved"` // 未修复的问题数（不含提示）
+	Counts     map[string]int  `json:"counts"`     // 各类问题的数量
+	Problems   []verifyProblem `json:"problems"`
+}
+
+// verifier 执行一次校验，通过互斥锁与查询进度的请求共享报告
+type verifier struct {
+	mu     sync.Mutex
+	report verifyReport
+}
+
+// newVerifier 创建校验任务，dir 为相对于图片根目录的子目录
+func newVerifier(dir string, repair bool) *verifier {
+	return &verifier{report: verifyReport{
+		Status:    "running",
+		Dir:       dir,
+		Repair:    repair,
+		StartedAt: time.Now(),
+		Counts:    make(map[string]int),
+		Problems:  []verifyProblem{},
+	}}
+}
+
+// run 依次校验目录下的所有图片，需要修复时立即修复
+func (v *verifier) run() {
+	images, err := collectDirImages(v.report.Dir)
+
+	v.mu.Lock()
+	if err == nil {
+		v.report.Total = len(images)
+	}
+	v.mu.Unlock()
+
+	if err == nil {
+		for _, files := range images {
+			v.check(files)
+		}
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	now := time.Now()
+	v.report.FinishedAt = &now
+	v.report.Status = "completed"
+	if err != nil {
+		v.report.Status, v.report.Error = "failed", err.Error()
+		log.Printf("读取校验目录失败: %v", err)
+		return
+	}
+	log.Printf("校验完成: %d 张图片，正常 %d 张，跳过 %d 张，修复 %d 个问题，未修复 %d 个",
+		v.report.Checked, v.report.OK, v.report.Skipped, v.report.Repaired, v.report.Unresolved)
+}
+
+// check 校验一张图片的原图、WebP和校验和，并记录结果
+func (v *verifier) check(files *imageFiles) {
+	rel := filepath.ToSlash(files.RelPath)
+	var problems []*verifyProblem
+	add := func(kind, message string) *verifyProblem {
+		p := &verifyProblem{Path: rel, Kind: kind, Message: message, Warning: problemIsWarning(kind)}
+		problems = append(problems, p)
+		return p
+	}
+
+	skipped := recentlyModified(files.OriginalPath) || recentlyModified(files.WebpPath)
+	if !skipped {
+		func() {
+			// 校验在后台goroutine中运行，解码时的panic只跳过这张图片，不影响服务
+			defer func() {
+				if r := recover(); r != nil {
+					log.Printf("校验图片 %s 时发生panic: %v\n%s", rel, r, debug.Stack())
+					skipped, problems = true, nil
+				}
+			}()
+			// 没有发现问题但原图无法解码校验时，也计为跳过
+			skipped = !v.checkFiles(files, add) && len(problems) == 0
+		}()
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.report.Checked++
+	switch {
+	case skipped:
+		v.report.Skipped++
+	case len(problems) == 0:
+		v.report.OK++
+	}
+	for _, p := range problems {
+		v.report.Counts[p.Kind]++
+		if p.Repaired {
+			v.report.Repaired++
+		} else if !p.Warning {
+			v.report.Unresolved++
+		}
+		v.report.Problems = append(v.report.Problems, *p)
+	}
+}
+
+// checkFiles 检查原图能否解码、校验和是否一致，以及WebP是否有效；需要修复时重新生成WebP并补充校验和
+// 缺少解码工具、无法确认原图能否解码时返回false
+func (v *verifier) checkFiles(files *imageFiles, add func(kind, message string) *verifyProblem) (decoded bool) {
+	var data []byte
+	var originalFormat string
+	originalOK, decoded := false, true
+	if files.OriginalPath == "" {
+		p := add(problemOriginalMissing, "WebP没有对应的原图")
+		p.Repair = "原图丢失，无法修复"
+	} else {
+		var err error
+		data, originalFormat, err = readImage(files.OriginalPath)
+		switch {
+		case errors.Is(err, errUnverifiable):
+			originalOK, decoded = true, false
+			log.Printf("跳过原图解码校验 %s: %v", files.OriginalPath, err)
+		case err != nil:
+			p := add(problemOriginalCorrupt, err.Error())
+			p.Repair = "原图损坏，可以从历史版本或备份恢复"
+		default:
+			originalOK = true
+		}
+	}
+
+	// WebP的检查结果，需要修复时从原图重新生成
+	webpPath := files.WebpPath
+	if webpPath == "" && files.OriginalPath != "" {
+		webpPath = filepath.Join(config.WebpDir, strings.TrimSuffix(files.RelPath, filepath.Ext(files.RelPath))+".webp")
+	}
+	var webpProblem *verifyProblem
+	switch {
+	case files.WebpPath == "":
+		// 未启用栅格化时SVG不生成WebP
+		if originalFormat != "svg" || config.SVGRasterize {
+			webpProblem = add(problemWebpMissing, "原图没有对应的WebP")
+		}
+	default:
+		kind, message := checkWebPFile(files, originalFormat)
+		if kind != "" {
+			webpProblem = add(kind, message)
+		}
+	}
+	if webpProblem != nil && v.report.Repair && files.OriginalPath != "" {
+		if !originalOK {
+			webpProblem.Repair = "原图损坏，无法重新生成"
+		} else {
+			v.repairWebP(files, webpPath, originalFormat, webpProblem)
+		}
+	}
+
+	// 重新生成会更新元数据，校验和在之后检查和补充
+	if data == nil {
+		return decoded
+	}
+	checksum := sha256.Sum256(data)
+	sum := hex.EncodeToString(checksum[:])
+	meta, err := metaStore.Load(files.RelPath)
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("读取图片元数据失败: %v", err)
+		return decoded
+	}
+	switch {
+	case meta == nil || meta.SHA256 == "":
+		p := add(problemChecksumMissing, "元数据中没有原图的校验和")
+		if v.report.Repair && originalOK {
+			if meta == nil {
+				meta = &storage.Metadata{OriginalName: filepath.Base(files.RelPath), OriginalSize: int64(len(data))}
+			}
+			meta.SHA256 = sum
+			if err := metaStore.Save(files.RelPath, meta); err != nil {
+				p.Repair = "保存元数据失败: " + err.Error()
+			} else {
+				p.Repaired = true
+			}
+		}
+	case meta.SHA256 != sum:
+		p := add(problemChecksumMismatch, fmt.Sprintf("原图校验和为 %s，元数据中记录的是 %s", sum, meta.SHA256))
+		p.Repair = "原图在上传后被修改或损坏，可以从历史版本或备份恢复"
+	}
+	return decoded
+}
+
+// checkWebPFile 检查WebP文件，有效时返回空的问题类型
+// 内容与原图格式相同且可以解码的是转换失败时复制的备用副本，其他情况视为无效
+func checkWebPFile(files *imageFiles, originalFormat string) (kind, message string) {
+	_, format, err := readImage(files.WebpPath)
+	switch {
+	case err == nil && format == "webp":
+		return "", ""
+	case err != nil && format == "webp":
+		return problemWebpInvalid, err.Error()
+	case files.OriginalPath != "" && format != "" && format == fallbackFormat(files.OriginalPath, originalFormat):
+		if err != nil {
+			return problemWebpInvalid, err.Error()
+		}
+		return problemWebpFallback, fmt.Sprintf("WebP文件实际是%s格式，是转换失败时复制的备用副本", format)
+	case format == "":
+		return problemWebpInvalid, "WebP文件不是可识别的图片格式"
+	}
+	return problemWebpInvalid, fmt.Sprintf("WebP文件实际是%s格式，与原图不符", format)
+}
+
+// repairWebP 从原图重新生成WebP，并检查生成结果
+func (v *verifier) repairWebP(files *imageFiles, webpPath, originalFormat string, p *verifyProblem) {
+	var err error
+	withConversionSlot(func() {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("重新生成WebP %s 时发生panic: %v\n%s", webpPath, r, debug.Stack())
+				err = fmt.Errorf("%v", r)
+			}
+		}()
+		err = regenerate(files.OriginalPath, webpPath, files.RelPath)
+	})
+	if err != nil {
+		p.Repair = "重新生成失败: " + err.Error()
+		return
+	}
+
+	regenerated := *files
+	regenerated.WebpPath = webpPath
+	switch kind, message := checkWebPFile(&regenerated, originalFormat); kind {
+	case "":
+		p.Repaired = true
+		log.Printf("已重新生成WebP: %s", webpPath)
+	case problemWebpFallback:
+		// 转换仍然失败（例如缺少gif2webp或WebP比原图更大），备用副本可以正常显示
+		p.Repair = "重新生成后仍是备用副本"
+		if p.Kind != problemWebpFallback {
+			p.Repaired = true
+		}
+	default:
+		p.Repair = "重新生成后仍然无效: " + message
+	}
+}
+
+// recentlyModified 判断文件是否在 verifySettle 之内修改过，路径为空时返回false
+func recentlyModified(path string) bool {
+	if path == "" {
+		return false
+	}
+	info, err := os.Stat(path)
+	return err == nil && time.Since(info.ModTime()) < verifySettle
+}
+
+// verifyCommand 在命令行中校验图片，有未修复的问题时返回1
+func verifyCommand(args []string) int {
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	repair := flags.Bool("repair", false, "从原图重新生成有问题的WebP，并补充缺少的校验和")
+	dir := flags.String("dir", "", "只校验指定的子目录，例如 25/06")
+	jsonOutput := flags.Bool("json", false, "以JSON格式输出报告")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	v := newVerifier(strings.TrimPrefix(filepath.Clean("/"+*dir), "/"), *repair)
+	v.run()
+	report := &v.report
+
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(report)
+	} else {
+		for _, p := range report.Problems {
+			line := fmt.Sprintf("%-18s %s: %s", p.Kind, p.Path, p.Message)
+			switch {
+			case p.Repaired && p.Repair != "":
+				line += " [已修复，" + p.Repair + "]"
+			case p.Repaired:
+				line += " [已修复]"
+			case p.Repair != "":
+				line += " [" + p.Repair + "]"
+			}
+			fmt.Println(line)
+		}
+		fmt.Printf("校验 %d 张图片: 正常 %d 张，跳过 %d 张，修复 %d 个问题，未修复 %d 个\n",
+			report.Checked, report.OK, report.Skipped, report.Repaired, report.Unresolved)
+	}
+
+	if report.Status == "failed" || report.Unresolved > 0 {
+		return 1
+	}
+	return 0
+}
+
+// currentVerify 最近一次通过API启动的校验任务，同一时间只运行一个
+var currentVerify struct {
+	sync.Mutex
+	job *verifier
+}
+
+// verifyStartHandler 在后台启动校验任务，dir 指定子目录，repair=true 时修复发现的问题
+func verifyStartHandler(c *gin.Context) {
+	repairStr := c.Query("repair")
+	repair := repairStr == "true" || repairStr == "1" || repairStr == "yes"
+	dir := strings.TrimPrefix(filepath.Clean("/"+c.Query("dir")), "/")
+
+	currentVerify.Lock()
+	defer currentVerify.Unlock()
+	if job := currentVerify.job; job != nil {
+		job.mu.Lock()
+		running := job.report.Status == "running"
+		job.mu.Unlock()
+		if running {
+			c.JSON(http.StatusConflict, gin.H{"error": "校验任务正在运行"})
+			return
+		}
+	}
+
+	job := newVerifier(dir, repair)
+	currentVerify.job = job
+	go job.run()
+	log.Printf("开始校验图片: 目录 %q，修复 %v", dir, repair)
+
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	c.JSON(http.StatusAccepted, &job.report)
+}
+
+// verifyStatusHandler 返回最近一次校验任务的进度和结果
+func verifyStatusHandler(c *gin.Context) {
+	currentVerify.Lock()
+	job := currentVerify.job
+	currentVerify.Unlock()
+	if job == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "还没有运行过校验任务"})
+		return
+	}
+
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	c.JSON(http.StatusOK, &job.report)
+}
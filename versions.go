@@ -0,0 +1,462 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/suixinio/webp-img/storage"
+)
+
+// 历史版本目录中的文件名
+const (
+	versionInfoName = "version.json" // 版本信息
+	versionOrigName = "original"     // 原图（不含扩展名）
+	versionWebpName = "image.webp"   // WebP
+)
+
+// 并发保存时查找空闲版本号的最大尝试次数
+const versionMkdirAttempts = 100
+
+// imageVersion 一张图片被替换前保存的历史版本
+type imageVersion struct {
+	Version   int               `json:"version"`            // 版本号，从1开始递增
+	Reason    string            `json:"reason"`             // 保存原因: edit/regenerate/rollback
+	From      int               `json:"from,omitempty"`     // 回滚时恢复的版本号
+	CreatedAt time.Time         `json:"created_at"`         // 保存时间，即该版本被替换的时间
+	Original  string            `json:"original,omitempty"` // 原图文件名，形如 original.jpg
+	WebP      bool              `json:"webp"`               // 是否保存了WebP
+	Meta      *storage.Metadata `json:"meta,omitempty"`     // 当时的元数据，回滚时恢复
+	Pinned    bool              `json:"pinned,omitempty"`   // 第一次编辑前上传的原图，永久保留，不计入 MaxVersions
+
+	dir string // 版本目录
+}
+
+// errVersionNotFound 指定的历史版本不存在
+var errVersionNotFound = errors.New("历史版本不存在")
+
+// versionRoot 返回一张图片的历史版本目录，形如 versions/YY/MM/DD/timestamp，与衍生版本目录结构一致
+func versionRoot(webpPath string) string {
+	rel, err := filepath.Rel(config.WebpDir, webpPath)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		rel = filepath.Base(webpPath)
+	}
+	return filepath.Join(config.VersionDir, strings.TrimSuffix(rel, filepath.Ext(rel)))
+}
+
+// originalPath 返回历史版本中原图的路径，未保存原图时为空
+func (v *imageVersion) originalPath() string {
+	if v.Original == "" {
+		return ""
+	}
+	return filepath.Join(v.dir, v.Original)
+}
+
+// webpPath 返回历史版本中WebP的路径，未保存WebP时为空
+func (v *imageVersion) webpPath() string {
+	if !v.WebP {
+		return ""
+	}
+	return filepath.Join(v.dir, versionWebpName)
+}
+
+// listVersions 列出一张图片的所有历史版本，按版本号从小到大排列
+func listVersions(webpPath string) []*imageVersion {
+	root := versionRoot(webpPath)
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return nil
+	}
+
+	var versions []*imageVersion
+	for _, entry := range entries {
+		n, err := strconv.Atoi(entry.Name())
+		if !entry.IsDir() || err != nil || n <= 0 {
+			continue
+		}
+		dir := filepath.Join(root, entry.Name())
+		data, err := os.ReadFile(filepath.Join(dir, versionInfoName))
+		if err != nil {
+			continue
+		}
+		var v imageVersion
+		if err := json.Unmarshal(data, &v); err != nil {
+			log.Printf("解析版本信息失败 %s: %v", dir, err)
+			continue
+		}
+		v.Version, v.dir = n, dir
+		versions = append(versions, &v)
+	}
+
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Version < versions[j].Version })
+	return versions
+}
+
+// findVersion 查找指定版本号的历史版本
+func findVersion(webpPath string, n int) (*imageVersion, error) {
+	for _, v := range listVersions(webpPath) {
+		if v.Version == n {
+			return v, nil
+		}
+	}
+	return nil, errVersionNotFound
+}
+
+// saveVersion 在替换图片前将当前的原图、WebP和元数据保存为新的历史版本
+// 第一次编辑前的版本即上传的原图，标记为永久保留，未启用历史版本时也会保存
+// 未启用历史版本（MaxVersions 为0）或没有可保存的文件时返回nil
+// 不会删除超出数量的旧版本，调用方在替换完成后调用 pruneVersions，避免回滚时删除要恢复的版本
+func saveVersion(files *imageFiles, webpPath, reason string) (*imageVersion, error) {
+	if files.OriginalPath == "" && !isRegularFile(webpPath) {
+		return nil, nil
+	}
+	versions := listVersions(webpPath)
+	pin := reason == "edit" && files.OriginalPath != "" && !slices.ContainsFunc(versions, func(v *imageVersion) bool { return v.Pinned })
+	if config.MaxVersions <= 0 && !pin {
+		return nil, nil
+	}
+
+	root := versionRoot(webpPath)
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, fmt.Errorf("创建历史版本目录失败: %w", err)
+	}
+
+	// 使用 os.Mkdir 占用版本号，并发保存时不会写入同一个目录
+	n := 1
+	if len(versions) > 0 {
+		n = versions[len(versions)-1].Version + 1
+	}
+	var dir string
+	for i := 0; ; i++ {
+		dir = filepath.Join(root, strconv.Itoa(n))
+		err := os.Mkdir(dir, 0755)
+		if err == nil {
+			break
+		}
+		if !os.IsExist(err) || i >= versionMkdirAttempts {
+			return nil, fmt.Errorf("创建历史版本目录失败: %w", err)
+		}
+		n++
+	}
+
+	v := &imageVersion{Version: n, Reason: reason, CreatedAt: time.Now(), Pinned: pin, dir: dir}
+	if files.OriginalPath != "" {
+		v.Original = versionOrigName + filepath.Ext(files.OriginalPath)
+		// 原图只会通过重命名整体替换，可以使用硬链接节省空间
+		if err := linkOrCopy(files.OriginalPath, v.originalPath()); err != nil {
+			os.RemoveAll(dir)
+			return nil, fmt.Errorf("保存原图失败: %w", err)
+		}
+	}
+	if isRegularFile(webpPath) {
+		// WebP可能被转换工具原地覆盖，必须复制
+		v.WebP = true
+		if err := copyFile(webpPath, v.webpPath()); err != nil {
+			os.RemoveAll(dir)
+			return nil, fmt.Errorf("保存WebP失败: %w", err)
+		}
+	}
+	if meta, err := metaStore.Load(files.RelPath); err == nil {
+		v.Meta = meta
+	}
+	if err := v.writeInfo(); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	log.Printf("已保存历史版本 v%d (%s): %s", n, reason, files.RelPath)
+	return v, nil
+}
+
+// writeInfo 将版本信息写入版本目录
+func (v *imageVersion) writeInfo() error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化版本信息失败: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(v.dir, versionInfoName), data, 0644); err != nil {
+		return fmt.Errorf("写入版本信息失败: %w", err)
+	}
+	return nil
+}
+
+// linkOrCopy 为文件创建硬链接，跨文件系统等无法链接时复制
+func linkOrCopy(src, dst string) error {
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+	return copyFile(src, dst)
+}
+
+// pruneVersions 删除超出保留数量的最早的历史版本，永久保留的上传原图不删除也不计数
+func pruneVersions(webpPath string) {
+	versions := slices.DeleteFunc(listVersions(webpPath), func(v *imageVersion) bool { return v.Pinned })
+	for len(versions) > config.MaxVersions {
+		if err := os.RemoveAll(versions[0].dir); err != nil {
+			log.Printf("删除历史版本失败: %v", err)
+		}
+		versions = versions[1:]
+	}
+}
+
+// discardVersion 删除一个历史版本，用于重新生成的结果与之前完全相同时
+func discardVersion(v *imageVersion) {
+	if err := os.RemoveAll(v.dir); err != nil {
+		log.Printf("删除历史版本失败: %v", err)
+	}
+}
+
+// sameFile 比较两个文件的内容是否相同
+func sameFile(a, b string) bool {
+	infoA, errA := os.Stat(a)
+	infoB, errB := os.Stat(b)
+	if errA != nil || errB != nil || infoA.Size() != infoB.Size() {
+		return false
+	}
+	dataA, errA := os.ReadFile(a)
+	dataB, errB := os.ReadFile(b)
+	return errA == nil && errB == nil && bytes.Equal(dataA, dataB)
+}
+
+// restoreFile 将历史版本中的文件复制到临时文件再重命名到目标路径
+// 不能使用硬链接，否则之后保存新版本时会与历史版本共用同一个文件
+func restoreFile(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(dst), ".restore-*"+filepath.Ext(dst))
+	if err != nil {
+		return err
+	}
+	f.Close()
+	if err := copyFile(src, f.Name()); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), dst); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+// splitVersionPath 解析 <图片路径>/versions/N 和 <图片路径>/versions/N/original 形式的路径
+func splitVersionPath(param string) (imagePath, version string, original, ok bool) {
+	param = strings.TrimSuffix(param, "/")
+	if trimmed, found := strings.CutSuffix(param, "/original"); found {
+		param, original = trimmed, true
+	}
+	i := strings.LastIndex(param, "/versions/")
+	if i <= 0 {
+		return "", "", false, false
+	}
+	version = param[i+len("/versions/"):]
+	if version == "" || strings.Contains(version, "/") {
+		return "", "", false, false
+	}
+	return param[:i], version, original, true
+}
+
+// imageVersionFileHandler 提供历史版本的文件，需要登录
+// 历史版本可能包含编辑时裁剪掉的内容，不能通过公开的 /img 或 /uploads 访问
+func imageVersionFileHandler(c *gin.Context, imagePath, version string, original bool) {
+	files, ok := resolveImage(imagePath)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "图片不存在"})
+		return
+	}
+	webpPath := filepath.Join(config.WebpDir, strings.TrimSuffix(files.RelPath, filepath.Ext(files.RelPath))+".webp")
+	serveVersion(c, webpPath, version, original)
+}
+
+// serveVersion 提供历史版本的WebP，未保存WebP时提供原图；original 为 true 时只提供原图
+func serveVersion(c *gin.Context, webpPath, param string, original bool) {
+	n, err := strconv.Atoi(param)
+	if err != nil || n <= 0 {
+		c.String(http.StatusBadRequest, "无效的版本号")
+		return
+	}
+	v, err := findVersion(webpPath, n)
+	if err != nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	// 只提供给登录用户，不能被共享缓存保存
+	c.Header("Cache-Control", "private")
+
+	if path := v.webpPath(); path != "" && !original {
+		contentType := "image/webp"
+		if sniffImageType(path) == "gif" {
+			contentType = "image/gif"
+		}
+		serveFile(c, path, contentType, "")
+		return
+	}
+	if path := v.originalPath(); path != "" {
+		serveFile(c, path, contentTypeByExt(filepath.Ext(path)), "")
+		return
+	}
+	c.Status(http.StatusNotFound)
+}
+
+// versionURL 返回历史版本的访问地址
+func versionURL(relPath string, version int) string {
+	return "/api/images/" + filepath.ToSlash(relPath) + "/versions/" + strconv.Itoa(version)
+}
+
+// versionResponse 历史版本接口中的单个版本
+func versionResponse(relPath string, v *imageVersion) gin.H {
+	item := gin.H{
+		"version":    v.Version,
+		"reason":     v.Reason,
+		"created_at": v.CreatedAt,
+		"url":        versionURL(relPath, v.Version),
+	}
+	if v.From > 0 {
+		item["from"] = v.From
+	}
+	if v.Pinned {
+		item["pinned"] = true
+	}
+	if path := v.originalPath(); path != "" {
+		item["original_format"] = formatOf(path)
+		if info, err := os.Stat(path); err == nil {
+			item["original_size"] = info.Size()
+		}
+		item["original_url"] = versionURL(relPath, v.Version) + "/original"
+	}
+	if path := v.webpPath(); path != "" {
+		if info, err := os.Stat(path); err == nil {
+			item["webp_size"] = info.Size()
+		}
+	}
+	if v.Meta != nil && v.Meta.EditedAt != nil {
+		item["edited_at"] = v.Meta.EditedAt
+	}
+	return item
+}
+
+// imageVersionsHandler 列出图片的历史版本，最新的版本在前
+func imageVersionsHandler(c *gin.Context, imagePath string) {
+	files, ok := resolveImage(imagePath)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "图片不存在"})
+		return
+	}
+	webpPath := filepath.Join(config.WebpDir, strings.TrimSuffix(files.RelPath, filepath.Ext(files.RelPath))+".webp")
+
+	versions := listVersions(webpPath)
+	list := make([]gin.H, 0, len(versions))
+	for i := len(versions) - 1; i >= 0; i-- {
+		list = append(list, versionResponse(files.RelPath, versions[i]))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"path":         filepath.ToSlash(files.RelPath),
+		"url":          "/img/" + filepath.ToSlash(files.RelPath),
+		"max_versions": config.MaxVersions,
+		"versions":     list,
+	})
+}
+
+// rollbackRequest 回滚参数
+type rollbackRequest struct {
+	Version int `json:"version"` // 要恢复的版本号
+}
+
+// imageRollbackHandler 将图片恢复到指定的历史版本
+// 当前的原图和WebP先保存为新的历史版本，因此回滚本身也可以撤销
+func imageRollbackHandler(c *gin.Context, imagePath string) {
+	files, ok := resolveImage(imagePath)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "图片不存在"})
+		return
+	}
+
+	var req rollbackRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Version <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的版本号"})
+		return
+	}
+
+	oldExt := filepath.Ext(files.RelPath)
+	webpPath := filepath.Join(config.WebpDir, strings.TrimSuffix(files.RelPath, oldExt)+".webp")
+	target, err := findVersion(webpPath, req.Version)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	saved, err := saveVersion(files, webpPath, "rollback")
+	if err != nil {
+		log.Printf("保存历史版本失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "回滚失败"})
+		return
+	}
+	if saved != nil {
+		saved.From = req.Version
+		if err := saved.writeInfo(); err != nil {
+			log.Printf("%v", err)
+		}
+	}
+
+	// 恢复原图，版本中的扩展名与当前不同时（例如编辑时HEIC保存为PNG）删除当前的原图
+	relPath := files.RelPath
+	originalPath := files.OriginalPath
+	if src := target.originalPath(); src != "" {
+		relPath = strings.TrimSuffix(files.RelPath, oldExt) + filepath.Ext(src)
+		originalPath = filepath.Join(config.PicsDir, relPath)
+		if err := restoreFile(src, originalPath); err != nil {
+			log.Printf("恢复原图失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "回滚失败"})
+			return
+		}
+		if files.OriginalPath != "" && files.OriginalPath != originalPath {
+			os.Remove(files.OriginalPath)
+		}
+	}
+
+	// 衍生版本和视频对应当前的图片，删除后按恢复的版本重新生成
+	removeDerivedFiles(webpPath)
+	if src := target.webpPath(); src != "" {
+		if err := restoreFile(src, webpPath); err != nil {
+			log.Printf("恢复WebP失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "回滚失败"})
+			return
+		}
+	}
+	if target.Meta != nil {
+		if err := metaStore.Save(relPath, target.Meta); err != nil {
+			log.Printf("保存图片元数据失败: %v", err)
+		}
+	}
+	if originalPath != "" {
+		if target.WebP {
+			generateResponsive(originalPath, webpPath, nil)
+		} else if err := regenerate(originalPath, webpPath, relPath); err != nil {
+			// 版本中没有WebP（例如转换失败时），从恢复的原图重新生成
+			log.Printf("重新生成WebP失败: %v", err)
+		}
+	}
+
+	pruneVersions(webpPath)
+
+	log.Printf("已回滚图片 %s 到版本 v%d", files.RelPath, req.Version)
+	c.JSON(http.StatusOK, gin.H{
+		"path":         filepath.ToSlash(relPath),
+		"url":          "/img/" + filepath.ToSlash(relPath),
+		"versions_url": "/api/images/" + filepath.ToSlash(relPath) + "/versions",
+		"version":      req.Version,
+	})
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// videoContentTypes 支持的视频格式及其内容类型
+var videoContentTypes = map[string]string{
+	"mp4":  "video/mp4",
+	"webm": "video/webm",
+}
+
+// VideoConverter 将动画图片转换为视频的工具
+type VideoConverter interface {
+	// Available 检查转换工具是否可用
+	Available() bool
+	// Convert 将动画图片转换为指定格式 (mp4/webm) 的视频
+	Convert(srcPath, dstPath, format string) error
+}
+
+// ffmpegConverter 使用ffmpeg转换视频，编码质量使用配置中的CRF
+type ffmpegConverter struct{}
+
+func (f ffmpegConverter) Available() bool {
+	_, err := exec.LookPath("ffmpeg")
+	return err == nil
+}
+
+func (f ffmpegConverter) Convert(srcPath, dstPath, format string) error {
+	// 视频编码要求宽高为偶数，不带音轨
+	args := []string{"-y", "-loglevel", "error", "-i", srcPath, "-an",
+		"-vf", "scale=trunc(iw/2)*2:trunc(ih/2)*2"}
+
+	switch format {
+	case "mp4":
+		// H.264 的CRF范围为 0-51；faststart 将索引移到文件头部以便边下边播
+		crf := config.VideoCRF
+		if crf > 51 {
+			crf = 51
+		}
+		args = append(args, "-c:v", "libx264", "-preset", "slow", "-crf", strconv.Itoa(crf),
+			"-pix_fmt", "yuv420p", "-movflags", "+faststart", "-f", "mp4")
+	case "webm":
+		args = append(args, "-c:v", "libvpx-vp9", "-crf", strconv.Itoa(config.VideoCRF), "-b:v", "0",
+			"-row-mt", "1", "-pix_fmt", "yuv420p", "-f", "webm")
+	default:
+		return fmt.Errorf("不支持的视频格式: %s", format)
+	}
+
+	output, err := exec.Command("ffmpeg", append(args, dstPath)...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("ffmpeg转换失败: %v, 输出: %s", err, output)
+	}
+	return nil
+}
+
+// videoConverter 当前使用的视频转换工具
+var videoConverter VideoConverter = ffmpegConverter{}
+
+// videoPath 返回与WebP同目录、同名的视频文件路径
+func videoPath(webpPath, format string) string {
+	return strings.TrimSuffix(webpPath, filepath.Ext(webpPath)) + "." + format
+}
+
+// convertVideos 为动画图片生成配置中的各种视频格式，失败只记录日志
+func convertVideos(srcPath, webpPath string) {
+	if len(config.VideoFormats) == 0 {
+		return
+	}
+	if !videoConverter.Available() {
+		log.Printf("未找到视频转换工具，跳过生成视频: %s", srcPath)
+		return
+	}
+
+	for _, format := range config.VideoFormats {
+		dstPath := videoPath(webpPath, format)
+		// 先写入唯一的临时文件，避免并发请求读到未写完的视频或同时写入同一个文件
+		tmp, err := os.CreateTemp(filepath.Dir(dstPath), ".video-*."+format)
+		if err != nil {
+			log.Printf("创建临时文件失败: %v", err)
+			continue
+		}
+		tmp.Close()
+		tmpPath := tmp.Name()
+		if err := videoConverter.Convert(srcPath, tmpPath, format); err != nil {
+			os.Remove(tmpPath)
+			log.Printf("生成%s视频失败: %v", format, err)
+			continue
+		}
+		if err := os.Rename(tmpPath, dstPath); err != nil {
+			os.Remove(tmpPath)
+			log.Printf("保存%s视频失败: %v", format, err)
+			continue
+		}
+
+		if info, err := os.Stat(dstPath); err == nil {
+			log.Printf("成功生成%s视频: %s (%d字节)", format, dstPath, info.Size())
+		}
+	}
+}
+
+// 按需生成视频的后台任务，同一张图片同时只有一个任务；生成失败的图片记录原图的修改时间，原图不变时不再重试
+var (
+	videoJobs     sync.Map // WebP路径 -> struct{}
+	videoFailures sync.Map // WebP路径 -> 原图修改时间
+)
+
+// scheduleVideos 在后台为缺少视频的动画GIF生成视频，通过转换池限制同时进行的转换数量
+// 请求不等待生成完成；已有任务或之前生成失败且原图未改变时直接返回
+func scheduleVideos(srcPath, webpPath string) {
+	if len(config.VideoFormats) == 0 || !videoConverter.Available() {
+		return
+	}
+	info, err := os.Stat(srcPath)
+	if err != nil {
+		return
+	}
+	if failedAt, ok := videoFailures.Load(webpPath); ok && failedAt.(time.Time).Equal(info.ModTime()) {
+		return
+	}
+	if _, running := videoJobs.LoadOrStore(webpPath, struct{}{}); running {
+		return
+	}
+
+	go func() {
+		defer videoJobs.Delete(webpPath)
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("生成视频 %s 时发生panic: %v\n%s", srcPath, r, debug.Stack())
+			}
+		}()
+		withConversionSlot(func() {
+			convertVideos(srcPath, webpPath)
+		})
+		if len(listVideos(webpPath)) == 0 {
+			videoFailures.Store(webpPath, info.ModTime())
+		} else {
+			videoFailures.Delete(webpPath)
+		}
+	}()
+}
+
+// listVideos 按配置的优先级列出一张图片已生成的视频，返回格式和路径
+func listVideos(webpPath string) [][2]string {
+	var videos [][2]string
+	seen := make(map[string]bool)
+	// 先按配置顺序，再补充配置变更前生成的其他格式
+	for _, format := range append(append([]string(nil), config.VideoFormats...), "mp4", "webm") {
+		if seen[format] {
+			continue
+		}
+		seen[format] = true
+		if path := videoPath(webpPath, format); isRegularFile(path) {
+			videos = append(videos, [2]string{format, path})
+		}
+	}
+	return videos
+}
+
+// videoURLs 返回一张图片各视频格式的访问地址
+func videoURLs(webpPath string) map[string]string {
+	urls := make(map[string]string)
+	for _, video := range listVideos(webpPath) {
+		rel, err := filepath.Rel(config.WebpDir, video[1])
+		if err != nil {
+			continue
+		}
+		urls[video[0]] = "/video/" + filepath.ToSlash(rel)
+	}
+	return urls
+}
+
+// scheduledVideoURLs 返回配置中各视频格式的访问地址，用于刚安排后台生成视频的图片，生成完成前访问返回404
+func scheduledVideoURLs(webpPath string) map[string]string {
+	urls := make(map[string]string)
+	if !videoConverter.Available() {
+		return urls
+	}
+	rel, err := filepath.Rel(config.WebpDir, webpPath)
+	if err != nil {
+		return urls
+	}
+	for _, format := range config.VideoFormats {
+		urls[format] = "/video/" + filepath.ToSlash(videoPath(rel, format))
+	}
+	return urls
+}
+
+// negotiateVideo 根据Accept头选择视频版本，只有客户端明确接受对应的视频类型时才返回
+// 浏览器的 <img> 请求只带有 */*，不会因此收到视频
+func negotiateVideo(c *gin.Context, webpPath string) (path, contentType string, ok bool) {
+	videos := listVideos(webpPath)
+	if len(videos) == 0 {
+		return "", "", false
+	}
+	// 同一地址会根据Accept返回不同内容，需告知缓存
+	c.Writer.Header().Add("Vary", "Accept")
+
+	accepted := acceptedTypes(c.GetHeader("Accept"))
+	for _, video := range videos {
+		if contentType := videoContentTypes[video[0]]; accepted[contentType] {
+			return video[1], contentType, true
+		}
+	}
+	return "", "", false
+}
+
+// acceptedTypes 解析Accept头中明确列出且q值大于0的类型
+func acceptedTypes(accept string) map[string]bool {
+	types := make(map[string]bool)
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(fields[0]))
+		if mediaType == "" || strings.Contains(mediaType, "*") {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			if v, found := strings.CutPrefix(strings.TrimSpace(param), "q="); found {
+				if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		if q > 0 {
+			types[mediaType] = true
+		}
+	}
+	return types
+}
+
+// videoHandler 提供动画图片的视频版本
+// 路径可以是视频文件（.mp4/.webm），也可以是图片路径，此时按Accept头和配置的优先级选择格式
+// 请求不会同步运行ffmpeg，缺少的视频由 scheduleVideos 在后台生成
+func videoHandler(c *gin.Context) {
+	filePath := c.Param("filename")
+	ext := strings.ToLower(filepath.Ext(filePath))
+	format := strings.TrimPrefix(ext, ".")
+
+	if contentType, ok := videoContentTypes[format]; ok {
+		path := filepath.Join(config.WebpDir, filepath.Clean("/"+filePath))
+		if !isRegularFile(path) {
+			// 视频尚未生成时在后台从原图生成，本次请求返回404
+			if files, found := resolveImage(filePath); found && files.OriginalPath != "" && isAnimatedGIF(files.OriginalPath) {
+				scheduleVideos(files.OriginalPath, strings.TrimSuffix(path, ext)+".webp")
+			}
+			c.Status(http.StatusNotFound)
+			return
+		}
+		serveFile(c, path, contentType, "")
+		return
+	}
+
+	files, ok := resolveImage(filePath)
+	if !ok {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	webpPath := filepath.Join(config.WebpDir, strings.TrimSuffix(files.RelPath, filepath.Ext(files.RelPath))+".webp")
+	if len(listVideos(webpPath)) == 0 && files.OriginalPath != "" && isAnimatedGIF(files.OriginalPath) {
+		scheduleVideos(files.OriginalPath, webpPath)
+	}
+
+	if path, contentType, ok := negotiateVideo(c, webpPath); ok {
+		serveFile(c, path, contentType, "")
+		return
+	}
+	// 客户端没有明确指定视频类型时使用优先级最高的格式
+	if videos := listVideos(webpPath); len(videos) > 0 {
+		serveFile(c, videos[0][1], videoContentTypes[videos[0][0]], "")
+		return
+	}
+	c.Status(http.StatusNotFound)
+}
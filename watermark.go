@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	cfg "github.com/suixinio/webp-img/config"
+	"github.com/suixinio/webp-img/imaging"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/opentype"
+)
+
+// 测量文字宽度时使用的字号，实际字号按比例换算
+const watermarkMeasureSize = 100
+
+// 水印文字的最小字号，太小的字无法辨认
+const watermarkMinFontSize = 8
+
+// 已加载的字体和水印图片，按文件路径缓存
+var (
+	watermarkFonts  sync.Map // 字体路径（内置字体为空）-> *opentype.Font
+	watermarkImages sync.Map // 图片路径 -> image.Image
+)
+
+// defaultWatermark 返回转换时使用的水印预设，未配置时返回nil
+func defaultWatermark() *cfg.WatermarkPreset {
+	if config.Watermark == "" {
+		return nil
+	}
+	preset := config.WatermarkPresets[config.Watermark]
+	return &preset
+}
+
+// watermarkVariantName 返回加了水印的衍生版本名称，width 大于0时为对应宽度的版本
+// 预设名称中不允许出现点，可以用来分隔宽度
+func watermarkVariantName(preset string, width int) string {
+	name := "wm-" + preset
+	if width > 0 {
+		name += "." + widthName(width)
+	}
+	return name
+}
+
+// loadWatermarkFont 加载字体文件，路径为空时使用内置的Go Bold字体
+func loadWatermarkFont(path string) (*opentype.Font, error) {
+	if cached, ok := watermarkFonts.Load(path); ok {
+		return cached.(*opentype.Font), nil
+	}
+
+	data := gobold.TTF
+	if path != "" {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, fmt.Errorf("读取字体文件失败: %w", err)
+		}
+	}
+	f, err := opentype.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("解析字体文件失败: %w", err)
+	}
+	watermarkFonts.Store(path, f)
+	return f, nil
+}
+
+// loadWatermarkImage 加载水印图片
+func loadWatermarkImage(path string) (image.Image, error) {
+	if cached, ok := watermarkImages.Load(path); ok {
+		return cached.(image.Image), nil
+	}
+	img, _, err := imaging.Load(path)
+	if err != nil {
+		return nil, fmt.Errorf("加载水印图片失败: %w", err)
+	}
+	watermarkImages.Store(path, img)
+	return img, nil
+}
+
+// renderWatermark 按预设生成宽度约为 width 像素的水印图片
+func renderWatermark(preset *cfg.WatermarkPreset, width int) (image.Image, error) {
+	if preset.Image != "" {
+		mark, err := loadWatermarkImage(preset.Image)
+		if err != nil {
+			return nil, err
+		}
+		b := mark.Bounds()
+		height := max(1, b.Dy()*width/max(1, b.Dx()))
+		return imaging.Resize(mark, width, height), nil
+	}
+
+	f, err := loadWatermarkFont(preset.Font)
+	if err != nil {
+		return nil, err
+	}
+
+	// 先按固定字号测量文字宽度，再换算出占满目标宽度的字号
+	measureFace, err := opentype.NewFace(f, &opentype.FaceOptions{Size: watermarkMeasureSize, DPI: 72})
+	if err != nil {
+		return nil, fmt.Errorf("创建字体失败: %w", err)
+	}
+	measured := font.MeasureString(measureFace, preset.Text).Ceil()
+	measureFace.Close()
+	if measured <= 0 {
+		return nil, fmt.Errorf("水印文字为空")
+	}
+
+	size := max(watermarkMinFontSize, float64(watermarkMeasureSize)*float64(width)/float64(measured))
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, fmt.Errorf("创建字体失败: %w", err)
+	}
+	defer face.Close()
+
+	r, g, b := parseHexColor(preset.Color)
+	return imaging.RenderText(preset.Text, face, color.NRGBA{R: r, G: g, B: b, A: 0xff}), nil
+}
+
+// parseHexColor 解析 #rrggbb 形式的颜色，格式已在加载配置时检查
+func parseHexColor(s string) (r, g, b uint8) {
+	v, _ := strconv.ParseUint(s[1:], 16, 32)
+	return uint8(v >> 16), uint8(v >> 8), uint8(v)
+}
+
+// applyWatermark 为图片添加水印，结果写入临时PNG文件并返回路径
+func applyWatermark(srcPath, tmpDir string, preset *cfg.WatermarkPreset) (string, error) {
+	img, _, err := imaging.Load(srcPath)
+	if err != nil {
+		return "", err
+	}
+
+	w := img.Bounds().Dx()
+	mark, err := renderWatermark(preset, max(1, int(float64(w)*preset.Scale)))
+	if err != nil {
+		return "", err
+	}
+	marked := imaging.Overlay(img, mark, preset.Position, preset.Opacity, int(float64(w)*preset.Margin))
+
+	path, err := writeTempPNG(marked, tmpDir, ".watermark-*.png")
+	if err != nil {
+		return "", err
+	}
+
+	log.Printf("已添加水印: %s", srcPath)
+	return path, nil
+}
+
+// errWatermarkUnsupported 图片格式不支持添加水印（动画或未栅格化的矢量图）
+var errWatermarkUnsupported = errors.New("该图片不支持添加水印")
+
+// ensureWatermarked 返回使用指定水印预设的衍生版本路径，不存在时从原图生成
+// width 大于0时生成对应宽度的版本，不会放大图片
+func ensureWatermarked(files *imageFiles, webpPath, name string, width int) (string, error) {
+	preset, ok := config.WatermarkPresets[name]
+	if !ok {
+		return "", fmt.Errorf("水印预设不存在: %s", name)
+	}
+	if files.OriginalPath == "" || isAnimatedGIF(files.OriginalPath) || isAnimatedWebP(files.OriginalPath) ||
+		strings.EqualFold(filepath.Ext(files.OriginalPath), ".svg") {
+		return "", errWatermarkUnsupported
+	}
+
+	if width > 0 {
+		if info, ok := inspectFile(webpPath); !ok || width >= info.Width {
+			width = 0
+		}
+	}
+
+	opts := responsiveOptions(files.OriginalPath, nil)
+	opts.Watermark = &preset
+	opts.Width = width
+	return ensureVariant(files.OriginalPath, webpPath, watermarkVariantName(name, width), opts)
+}